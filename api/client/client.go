@@ -0,0 +1,228 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"sky/api/internal/model"
+)
+
+// Client is a typed client for the metrics api
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+}
+
+// Option configures the client
+type Option func(*Client)
+
+// WithHTTPClient sets the http client used for the requests
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetries sets how many times a failed request is retried and the initial wait between the attempts;
+// the wait is doubled after every attempt, and is at least the Retry-After of the response
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// New creates a client for the api running at the given base url, e.g. http://localhost:8080
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 15 * time.Second},
+		retries:    3,
+		backoff:    200 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Error is returned when the api responds with an error message
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("api responded with status %d: %s", e.StatusCode, e.Message)
+}
+
+// IsNotFound reports whether the api found no data for the query
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// GetTimeline returns the series of metrics for the query
+func (c *Client) GetTimeline(ctx context.Context, query model.Query) ([]model.Metric, error) {
+	var series []model.Metric
+	if err := c.do(ctx, http.MethodGet, c.queryURL("", query), nil, nil, &series); err != nil {
+		return nil, err
+	}
+	return series, nil
+}
+
+// GetAverage returns the average of the metrics for the query; the frequency of the query is ignored
+func (c *Client) GetAverage(ctx context.Context, query model.Query) (*model.MetricAverage, error) {
+	var average model.MetricAverage
+	if err := c.do(ctx, http.MethodGet, c.queryURL("/average", query), nil, nil, &average); err != nil {
+		return nil, err
+	}
+	return &average, nil
+}
+
+// WriteMetrics saves the metrics through the api; the retries of the write share an Idempotency-Key,
+// so the metrics are queued only once
+func (c *Client) WriteMetrics(ctx context.Context, metrics []model.Metric) error {
+	body, err := json.Marshal(metrics)
+	if err != nil {
+		return fmt.Errorf("failed to encode metrics: %w", err)
+	}
+
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("failed to generate idempotency key: %w", err)
+	}
+	header := http.Header{}
+	header.Set("Idempotency-Key", hex.EncodeToString(key))
+
+	return c.do(ctx, http.MethodPost, c.baseURL+"/metrics", body, header, nil)
+}
+
+// WriteMetric saves a single metric through the api
+func (c *Client) WriteMetric(ctx context.Context, metric model.Metric) error {
+	return c.WriteMetrics(ctx, []model.Metric{metric})
+}
+
+func (c *Client) queryURL(suffix string, query model.Query) string {
+	path := "/metrics"
+	if query.MetricType != model.MetricTypeNone {
+		path += "/" + query.MetricType.String()
+	}
+
+	params := url.Values{}
+	params.Set("start", query.StartAt.Format(time.RFC3339Nano))
+	params.Set("end", query.EndAt.Format(time.RFC3339Nano))
+	if query.Frequency != model.FrequencyNone && suffix == "" {
+		params.Set("frequency", query.Frequency.String())
+	}
+	return c.baseURL + path + suffix + "?" + params.Encode()
+}
+
+// do sends the request, retrying it on connection errors and on responses that might succeed later
+func (c *Client) do(ctx context.Context, method, url string, body []byte, header http.Header, out interface{}) error {
+	backoff := c.backoff
+	var retryAfter time.Duration
+	var err error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			wait := backoff
+			if retryAfter > wait {
+				wait = retryAfter
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(wait):
+			}
+			backoff *= 2
+		}
+
+		var retry bool
+		retry, retryAfter, err = c.send(ctx, method, url, body, header, out)
+		if !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// send sends the request once; it reports whether the request can be retried, and how long the server asked
+// to wait before retrying it
+func (c *Client) send(ctx context.Context, method, url string, body []byte, header http.Header, out interface{}) (bool, time.Duration, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBody = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, 0, ctx.Err()
+		}
+		return true, 0, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return true, 0, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		var msg map[string]string
+		if err := json.Unmarshal(respBody, &msg); err == nil {
+			apiErr.Message = msg["message"]
+		} else {
+			apiErr.Message = strings.TrimSpace(string(respBody))
+		}
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+		return retry, parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()), apiErr
+	}
+
+	if out == nil {
+		return false, 0, nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return false, 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return false, 0, nil
+}
+
+// parseRetryAfter returns the wait of a Retry-After header, which is a number of seconds or an http date;
+// it is 0 if the header is missing or not valid
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sky/api/internal/model"
+)
+
+func TestGetTimeline(t *testing.T) {
+	now := time.Date(2022, 4, 25, 0, 42, 21, 5e8, time.UTC)
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"message":"try again"}`))
+			return
+		}
+		assert.Equal(t, "/metrics/cpu_load", r.URL.Path)
+		assert.Equal(t, "2022-04-25T00:42:21.5Z", r.URL.Query().Get("end"))
+		assert.Equal(t, "hours", r.URL.Query().Get("frequency"))
+		json.NewEncoder(w).Encode([]model.Metric{{Timestamp: now, CPULoad: 42}})
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithRetries(2, time.Millisecond))
+	series, err := c.GetTimeline(context.Background(), model.Query{
+		StartAt:    now.Add(-time.Hour),
+		EndAt:      now,
+		MetricType: model.MetricTypeCPULoad,
+		Frequency:  model.FrequencyByHours,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, 1, len(series))
+	assert.Equal(t, 42.0, series[0].CPULoad)
+}
+
+func TestErrorResponses(t *testing.T) {
+	cases := []struct {
+		description      string
+		status           int
+		expectedRequests int
+		notFound         bool
+	}{
+		{"not found is not retried", http.StatusNotFound, 1, true},
+		{"bad request is not retried", http.StatusBadRequest, 1, false},
+		{"server errors are retried", http.StatusInternalServerError, 3, false},
+	}
+
+	for _, c := range cases {
+		var requests int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.WriteHeader(c.status)
+			w.Write([]byte(`{"message":"failed"}`))
+		}))
+
+		_, err := New(srv.URL, WithRetries(2, time.Millisecond)).GetAverage(context.Background(), model.Query{})
+		srv.Close()
+
+		var apiErr *Error
+		assert.ErrorAs(t, err, &apiErr, c.description)
+		assert.Equal(t, c.status, apiErr.StatusCode, c.description)
+		assert.Equal(t, "failed", apiErr.Message, c.description)
+		assert.Equal(t, c.expectedRequests, requests, c.description)
+		assert.Equal(t, c.notFound, IsNotFound(err), c.description)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	var requests int
+	var retried time.Time
+	start := time.Now()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"message":"write queue is full"}`))
+			return
+		}
+		retried = time.Now()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	err := New(srv.URL, WithRetries(2, time.Millisecond)).WriteMetric(context.Background(), model.Metric{Timestamp: start, CPULoad: 42})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, requests)
+	assert.GreaterOrEqual(t, retried.Sub(start), time.Second)
+
+	now := time.Date(2022, 4, 25, 10, 0, 0, 0, time.UTC)
+	assert.Equal(t, 3*time.Second, parseRetryAfter("3", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter("Mon, 25 Apr 2022 10:00:30 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Mon, 25 Apr 2022 09:00:00 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+}
@@ -0,0 +1,314 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"sky/api/internal/config"
+	"sky/api/internal/model"
+	"sky/api/internal/rules"
+)
+
+// Reader is an interface for reading the raw metrics of the window of a rule; the metrics without the field of the
+// metric type of the query are left out, so they are not aggregated as 0
+type Reader interface {
+	GetSeries(ctx context.Context, filter model.Query) ([]model.Metric, error)
+}
+
+// State is the state of an alert rule
+type State string
+
+const (
+	// StateInactive is a rule whose condition is not met
+	StateInactive State = "inactive"
+	// StatePending is a rule whose condition is met, but not for its For duration yet
+	StatePending State = "pending"
+	// StateFiring is a rule whose condition has been met for its For duration; it was notified as firing
+	StateFiring State = "firing"
+)
+
+// StatusResolved is the status of the notification sent when a firing rule's condition is not met anymore
+const StatusResolved = "resolved"
+
+// Notification is the json body posted to the webhooks when an alert fires or resolves
+type Notification struct {
+	// Status is firing or resolved
+	Status      string            `json:"status"`
+	Alert       string            `json:"alert"`
+	Metric      string            `json:"metric"`
+	Aggregation string            `json:"aggregation"`
+	Operator    string            `json:"operator"`
+	Threshold   float64           `json:"threshold"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	// Value is the last evaluated aggregation; it is missing if the window had no metrics
+	Value *float64 `json:"value,omitempty"`
+	// ActiveSince is when the condition was first met
+	ActiveSince time.Time `json:"activeSince"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// rule is a parsed alert rule with its current state
+type rule struct {
+	config.AlertRule
+	metricType  model.MetricType
+	aggregation rules.Aggregation
+	compare     func(value, threshold float64) bool
+
+	state       State
+	activeSince time.Time
+	// unsent is the last notification of the rule by the index of each webhook that didn't receive it yet; it is
+	// sent again on the next evaluations until the webhook receives it
+	unsent map[int]*Notification
+}
+
+// Engine evaluates the alert rules on a schedule and notifies the webhooks about the firing and resolved alerts
+type Engine struct {
+	reader   Reader
+	client   *http.Client
+	interval time.Duration
+	webhooks []config.Webhook
+
+	mu    sync.Mutex
+	rules []*rule
+}
+
+// NewEngine creates an alerting engine for the rules and webhooks of the configuration
+func NewEngine(reader Reader, cfg config.AlertingConfig) (*Engine, error) {
+	e := &Engine{
+		reader:   reader,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		interval: time.Duration(cfg.Interval),
+		webhooks: cfg.Webhooks,
+	}
+	for _, r := range cfg.Rules {
+		if r.Aggregation == "" {
+			r.Aggregation = "avg"
+		}
+		metricType, err := model.ParseMetricType(r.Metric)
+		if err != nil {
+			return nil, fmt.Errorf("alert rule %s: %w", r.Name, err)
+		}
+		aggregation, err := rules.ParseAggregation(r.Aggregation)
+		if err != nil {
+			return nil, fmt.Errorf("alert rule %s: %w", r.Name, err)
+		}
+		compare, err := parseOperator(r.Operator)
+		if err != nil {
+			return nil, fmt.Errorf("alert rule %s: %w", r.Name, err)
+		}
+		e.rules = append(e.rules, &rule{
+			AlertRule:   r,
+			metricType:  metricType,
+			aggregation: aggregation,
+			compare:     compare,
+			state:       StateInactive,
+			unsent:      make(map[int]*Notification),
+		})
+	}
+	return e, nil
+}
+
+func parseOperator(operator string) (func(value, threshold float64) bool, error) {
+	switch operator {
+	case ">":
+		return func(v, t float64) bool { return v > t }, nil
+	case ">=":
+		return func(v, t float64) bool { return v >= t }, nil
+	case "<":
+		return func(v, t float64) bool { return v < t }, nil
+	case "<=":
+		return func(v, t float64) bool { return v <= t }, nil
+	case "==":
+		return func(v, t float64) bool { return v == t }, nil
+	case "!=":
+		return func(v, t float64) bool { return v != t }, nil
+	default:
+		return nil, fmt.Errorf("operator is not valid; expected >, >=, <, <=, == or !=, but received %q", operator)
+	}
+}
+
+// Run evaluates the rules every interval until the context is cancelled
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		e.Evaluate(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// States returns the current state of the rules by their name
+func (e *Engine) States() map[string]State {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	states := make(map[string]State, len(e.rules))
+	for _, r := range e.rules {
+		states[r.Name] = r.state
+	}
+	return states
+}
+
+// Evaluate evaluates the rules over their windows ending at now and moves their states: a met condition makes an
+// inactive rule pending, and a rule pending for its For duration firing; a condition that is not met makes the rule
+// inactive, and a firing rule resolved. The firing and resolved alerts are notified after the states are moved, so
+// a slow webhook doesn't block the readers of the states; a notification that a webhook failed to receive is sent
+// again on the next evaluations, until it is received or replaced by the next notification of the rule.
+func (e *Engine) Evaluate(ctx context.Context, now time.Time) {
+	// the windows are read without the lock, as they only depend on the configuration of the rules
+	values := make([]*float64, len(e.rules))
+	failed := make([]bool, len(e.rules))
+	for i, r := range e.rules {
+		value, err := e.evaluate(ctx, r, now)
+		if err != nil {
+			// the state is kept until the rule can be evaluated again
+			log.Printf("failed to evaluate alert rule %s: %s", r.Name, err.Error())
+			failed[i] = true
+			continue
+		}
+		values[i] = value
+	}
+
+	e.mu.Lock()
+	for i, r := range e.rules {
+		if failed[i] {
+			continue
+		}
+		value := values[i]
+		if value != nil && r.compare(*value, r.Threshold) {
+			if r.state == StateInactive {
+				r.state = StatePending
+				r.activeSince = now
+			}
+			if r.state == StatePending && now.Sub(r.activeSince) >= time.Duration(r.For) {
+				r.state = StateFiring
+				r.queue(len(e.webhooks), r.notification(string(StateFiring), value, now))
+			}
+			continue
+		}
+
+		if r.state == StateFiring {
+			r.queue(len(e.webhooks), r.notification(StatusResolved, value, now))
+		}
+		r.state = StateInactive
+		r.activeSince = time.Time{}
+	}
+
+	var deliveries []delivery
+	for _, r := range e.rules {
+		for webhook, n := range r.unsent {
+			deliveries = append(deliveries, delivery{rule: r, webhook: webhook, notification: n})
+		}
+	}
+	e.mu.Unlock()
+
+	for i, d := range deliveries {
+		deliveries[i].err = e.notify(ctx, e.webhooks[d.webhook], d.notification)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, d := range deliveries {
+		// a notification replaced while it was sent is not sent again
+		if d.err == nil && d.rule.unsent[d.webhook] == d.notification {
+			delete(d.rule.unsent, d.webhook)
+		}
+	}
+}
+
+// delivery is a notification of a rule sent to a webhook
+type delivery struct {
+	rule         *rule
+	webhook      int
+	notification *Notification
+	err          error
+}
+
+// queue replaces the unsent notification of the rule for every webhook
+func (r *rule) queue(webhooks int, n Notification) {
+	for i := 0; i < webhooks; i++ {
+		r.unsent[i] = &n
+	}
+}
+
+// evaluate returns the aggregation of the metric over the window of the rule, or nil if the window has no metrics
+func (e *Engine) evaluate(ctx context.Context, r *rule, now time.Time) (*float64, error) {
+	metrics, err := e.reader.GetSeries(ctx, model.Query{
+		StartAt:    now.Add(-time.Duration(r.Window)),
+		EndAt:      now,
+		MetricType: r.metricType,
+	})
+	if err != nil || len(metrics) == 0 {
+		return nil, err
+	}
+
+	values := make([]float64, 0, len(metrics))
+	for _, m := range metrics {
+		if r.metricType == model.MetricTypeCPULoad {
+			values = append(values, m.CPULoad)
+		} else {
+			values = append(values, float64(m.Concurrency))
+		}
+	}
+	value := r.aggregation(values)
+	return &value, nil
+}
+
+func (r *rule) notification(status string, value *float64, now time.Time) Notification {
+	return Notification{
+		Status:      status,
+		Alert:       r.Name,
+		Metric:      r.Metric,
+		Aggregation: r.Aggregation,
+		Operator:    r.Operator,
+		Threshold:   r.Threshold,
+		Labels:      r.Labels,
+		Value:       value,
+		ActiveSince: r.activeSince,
+		Timestamp:   now,
+	}
+}
+
+// notify posts the notification to the webhook; the failure is logged and returned
+func (e *Engine) notify(ctx context.Context, webhook config.Webhook, n *Notification) error {
+	body, err := json.Marshal(n)
+	if err == nil {
+		err = e.post(ctx, webhook, body)
+	}
+	if err != nil {
+		log.Printf("failed to notify %s about alert %s, retrying on the next evaluation: %s", webhook.URL, n.Alert, err.Error())
+	}
+	return err
+}
+
+func (e *Engine) post(ctx context.Context, webhook config.Webhook, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range webhook.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
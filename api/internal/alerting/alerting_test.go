@@ -0,0 +1,186 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sky/api/internal/config"
+	"sky/api/internal/model"
+)
+
+func TestEvaluate(t *testing.T) {
+	var mu sync.Mutex
+	var received []Notification
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		var n Notification
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&n))
+		mu.Lock()
+		received = append(received, n)
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	reader := &mockReader{}
+	engine, err := NewEngine(reader, config.AlertingConfig{
+		Webhooks: []config.Webhook{{URL: receiver.URL, Headers: map[string]string{"Authorization": "Bearer token"}}},
+		Rules: []config.AlertRule{{
+			Name:      "HighCPULoad",
+			Metric:    "cpu_load",
+			Window:    config.Duration(5 * time.Minute),
+			Operator:  ">",
+			Threshold: 90,
+			For:       config.Duration(10 * time.Minute),
+			Labels:    map[string]string{"severity": "critical"},
+		}},
+	})
+	assert.Nil(t, err)
+
+	start := time.Date(2022, 4, 25, 10, 0, 0, 0, time.UTC)
+	steps := []struct {
+		description   string
+		loads         []float64
+		expectedState State
+		expectedSent  int
+	}{
+		{"below the threshold", []float64{50, 60}, StateInactive, 0},
+		{"above the threshold", []float64{95, 93}, StatePending, 0},
+		{"pending for less than 10m", []float64{91, 99}, StatePending, 0},
+		{"pending for 10m", []float64{92, 96}, StateFiring, 1},
+		{"still firing", []float64{97}, StateFiring, 1},
+		{"no metrics", nil, StateInactive, 2},
+		{"above the threshold again", []float64{100}, StatePending, 2},
+	}
+
+	for i, step := range steps {
+		reader.loads = step.loads
+		engine.Evaluate(context.Background(), start.Add(time.Duration(i)*5*time.Minute))
+		assert.Equal(t, step.expectedState, engine.States()["HighCPULoad"], step.description)
+		mu.Lock()
+		assert.Len(t, received, step.expectedSent, step.description)
+		mu.Unlock()
+	}
+
+	firing := 94.0
+	assert.Equal(t, Notification{
+		Status:      "firing",
+		Alert:       "HighCPULoad",
+		Metric:      "cpu_load",
+		Aggregation: "avg",
+		Operator:    ">",
+		Threshold:   90,
+		Labels:      map[string]string{"severity": "critical"},
+		Value:       &firing,
+		ActiveSince: start.Add(5 * time.Minute),
+		Timestamp:   start.Add(15 * time.Minute),
+	}, received[0])
+	assert.Equal(t, StatusResolved, received[1].Status)
+	assert.Nil(t, received[1].Value)
+	assert.Equal(t, start.Add(25*time.Minute), received[1].Timestamp)
+}
+
+func TestNotifyUnlocked(t *testing.T) {
+	var engine *Engine
+	var states []map[string]State
+	// the webhook reads the states while the notification is sent
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		states = append(states, engine.States())
+	}))
+	defer receiver.Close()
+
+	var err error
+	engine, err = NewEngine(&mockReader{loads: []float64{95}}, config.AlertingConfig{
+		Webhooks: []config.Webhook{{URL: receiver.URL}},
+		Rules:    []config.AlertRule{{Name: "HighCPULoad", Metric: "cpu_load", Window: config.Duration(time.Minute), Operator: ">", Threshold: 90}},
+	})
+	assert.Nil(t, err)
+
+	engine.Evaluate(context.Background(), time.Date(2022, 4, 25, 10, 0, 0, 0, time.UTC))
+	assert.Equal(t, []map[string]State{{"HighCPULoad": StateFiring}}, states)
+}
+
+func TestNotifyRetried(t *testing.T) {
+	var mu sync.Mutex
+	var received []Notification
+	failures := 1
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var n Notification
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&n))
+		received = append(received, n)
+	}))
+	defer receiver.Close()
+
+	reader := &mockReader{loads: []float64{95}}
+	engine, err := NewEngine(reader, config.AlertingConfig{
+		Webhooks: []config.Webhook{{URL: receiver.URL}},
+		Rules:    []config.AlertRule{{Name: "HighCPULoad", Metric: "cpu_load", Window: config.Duration(time.Minute), Operator: ">", Threshold: 90}},
+	})
+	assert.Nil(t, err)
+
+	// the failed firing notification is sent again on the next evaluation, and only until it is received
+	start := time.Date(2022, 4, 25, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		engine.Evaluate(context.Background(), start.Add(time.Duration(i)*time.Minute))
+	}
+	mu.Lock()
+	if assert.Len(t, received, 1) {
+		assert.Equal(t, "firing", received[0].Status)
+		assert.Equal(t, start, received[0].Timestamp)
+	}
+	mu.Unlock()
+
+	// an unsent notification is replaced by the next one of the rule
+	mu.Lock()
+	failures = 1
+	mu.Unlock()
+	reader.loads = nil
+	engine.Evaluate(context.Background(), start.Add(3*time.Minute))
+	reader.loads = []float64{95}
+	engine.Evaluate(context.Background(), start.Add(4*time.Minute))
+	mu.Lock()
+	if assert.Len(t, received, 2) {
+		assert.Equal(t, "firing", received[1].Status)
+		assert.Equal(t, start.Add(4*time.Minute), received[1].Timestamp)
+	}
+	mu.Unlock()
+}
+
+func TestNewEngine(t *testing.T) {
+	rule := config.AlertRule{Name: "HighCPULoad", Metric: "cpu_load", Window: config.Duration(time.Minute), Operator: ">"}
+	_, err := NewEngine(&mockReader{}, config.AlertingConfig{Rules: []config.AlertRule{rule}})
+	assert.Nil(t, err)
+
+	rule.Operator = "=>"
+	_, err = NewEngine(&mockReader{}, config.AlertingConfig{Rules: []config.AlertRule{rule}})
+	assert.NotNil(t, err)
+
+	rule.Operator = ">"
+	rule.Aggregation = "median"
+	_, err = NewEngine(&mockReader{}, config.AlertingConfig{Rules: []config.AlertRule{rule}})
+	assert.NotNil(t, err)
+}
+
+type mockReader struct {
+	loads []float64
+}
+
+func (m *mockReader) GetSeries(ctx context.Context, filter model.Query) ([]model.Metric, error) {
+	var series []model.Metric
+	for i, load := range m.loads {
+		series = append(series, model.Metric{Timestamp: filter.StartAt.Add(time.Duration(i) * time.Minute), CPULoad: load})
+	}
+	return series, nil
+}
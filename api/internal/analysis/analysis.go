@@ -0,0 +1,67 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"sky/api/internal/model"
+)
+
+// Point is a value of a series at a time
+type Point struct {
+	Timestamp time.Time
+	Value     float64
+}
+
+// Points returns the values of the metric type from the series, ordered by time; the models assume the points are
+// in order, which the store doesn't promise
+func Points(metrics []model.Metric, metricType model.MetricType) []Point {
+	points := make([]Point, 0, len(metrics))
+	for _, m := range metrics {
+		value := m.CPULoad
+		if metricType == model.MetricTypeConcurrency {
+			value = float64(m.Concurrency)
+		}
+		points = append(points, Point{Timestamp: m.Timestamp, Value: value})
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Timestamp.Before(points[j].Timestamp)
+	})
+	return points
+}
+
+func values(points []Point) []float64 {
+	v := make([]float64, 0, len(points))
+	for _, p := range points {
+		v = append(v, p.Value)
+	}
+	return v
+}
+
+func mean(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total / float64(len(values))
+}
+
+// stddev returns the population standard deviation of the values
+func stddev(values []float64) float64 {
+	m := mean(values)
+	total := 0.0
+	for _, v := range values {
+		total += (v - m) * (v - m)
+	}
+	return math.Sqrt(total / float64(len(values)))
+}
+
+func median(values []float64) float64 {
+	s := append([]float64(nil), values...)
+	sort.Float64s(s)
+	if len(s)%2 == 1 {
+		return s[len(s)/2]
+	}
+	return (s[len(s)/2-1] + s[len(s)/2]) / 2
+}
@@ -0,0 +1,39 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+
+	"sky/api/internal/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoints(t *testing.T) {
+	start := time.Date(2022, 4, 25, 0, 0, 0, 0, time.UTC)
+	metrics := []model.Metric{
+		{Timestamp: start.Add(2 * time.Hour), CPULoad: 30, Concurrency: 300},
+		{Timestamp: start, CPULoad: 10, Concurrency: 100},
+		{Timestamp: start.Add(time.Hour), CPULoad: 20, Concurrency: 200},
+	}
+
+	// the points are ordered by time, whatever the order of the metrics
+	assert.Equal(t, []Point{
+		{Timestamp: start, Value: 10},
+		{Timestamp: start.Add(time.Hour), Value: 20},
+		{Timestamp: start.Add(2 * time.Hour), Value: 30},
+	}, Points(metrics, model.MetricTypeCPULoad))
+	assert.Equal(t, []Point{
+		{Timestamp: start, Value: 100},
+		{Timestamp: start.Add(time.Hour), Value: 200},
+		{Timestamp: start.Add(2 * time.Hour), Value: 300},
+	}, Points(metrics, model.MetricTypeConcurrency))
+
+	// a forecast of the unordered metrics continues after the newest one
+	forecast, err := Forecast(Points(metrics, model.MetricTypeCPULoad), ForecastOptions{Model: ModelLinear, Step: time.Hour, Horizon: 1, Confidence: 0.95})
+	assert.Nil(t, err)
+	if assert.Len(t, forecast.Points, 1) {
+		assert.Equal(t, start.Add(3*time.Hour), forecast.Points[0].Timestamp)
+		assert.InDelta(t, 40, forecast.Points[0].Value, 1e-9)
+	}
+}
@@ -0,0 +1,126 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Method is how the baseline of a point is computed
+type Method string
+
+const (
+	// MethodZScore scores a point by its distance from the mean of the preceding window, in standard deviations
+	MethodZScore Method = "zscore"
+	// MethodMAD scores a point by its distance from the median of the preceding window, in scaled median absolute
+	// deviations; it is less affected by the outliers in the window than the z-score
+	MethodMAD Method = "mad"
+	// MethodHoltWinters scores a point by its distance from the Holt-Winters forecast, in standard deviations of the
+	// residuals of the preceding window; the forecast follows the trend and the seasonality of the series
+	MethodHoltWinters Method = "holtwinters"
+)
+
+// madScale makes the median absolute deviation comparable to the standard deviation of normally distributed values
+const madScale = 1.4826
+
+// ParseMethod returns the method for its name; an empty name is MethodZScore
+func ParseMethod(name string) (Method, error) {
+	switch m := Method(name); m {
+	case "":
+		return MethodZScore, nil
+	case MethodZScore, MethodMAD, MethodHoltWinters:
+		return m, nil
+	default:
+		return "", fmt.Errorf("method is not valid; expected zscore, mad or holtwinters, but received %s", name)
+	}
+}
+
+// AnomalyOptions sets up the anomaly detection
+type AnomalyOptions struct {
+	Method Method
+	// Window is the number of preceding points the baseline of a point is computed from
+	Window int
+	// Season is the number of points of a season for MethodHoltWinters, e.g. 24 for daily seasonality of hourly points;
+	// 0 fits a trend without seasonality
+	Season int
+	// Threshold is the score above which a point is flagged; the expected band is the range within the threshold
+	Threshold float64
+}
+
+// Anomaly is a flagged point with its score and the expected band of its baseline
+type Anomaly struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+	Expected  float64   `json:"expected"`
+	Lower     float64   `json:"lower"`
+	Upper     float64   `json:"upper"`
+	// Score is the signed distance of the value from the expected value, in the deviations of the method
+	Score float64 `json:"score"`
+}
+
+// DetectAnomalies scores the points against their baseline and returns the ones whose absolute score is above the
+// threshold. The points without a full window before them are not scored, neither are the points whose baseline
+// has no variation. MethodHoltWinters returns an error if the series is too short to fit the model of the season.
+func DetectAnomalies(points []Point, opts AnomalyOptions) ([]Anomaly, error) {
+	vals := values(points)
+	expected := make([]float64, len(vals))
+	spread := make([]float64, len(vals))
+	for i := range vals {
+		expected[i], spread[i] = math.NaN(), math.NaN()
+	}
+
+	switch opts.Method {
+	case MethodHoltWinters:
+		hw := fitHoltWinters(vals, opts.Season)
+		if hw == nil {
+			return nil, fmt.Errorf("at least %d points are needed to fit a model with a season of %d points, but the series has %d",
+				minHoltWintersPoints(opts.Season), opts.Season, len(vals))
+		}
+		residuals := make([]float64, 0, len(vals))
+		for i, forecast := range hw.fitted {
+			if math.IsNaN(forecast) {
+				continue
+			}
+			if len(residuals) >= opts.Window {
+				expected[i] = forecast
+				spread[i] = stddev(residuals[len(residuals)-opts.Window:])
+			}
+			residuals = append(residuals, vals[i]-forecast)
+		}
+	case MethodMAD:
+		for i := opts.Window; i < len(vals); i++ {
+			window := vals[i-opts.Window : i]
+			m := median(window)
+			deviations := make([]float64, len(window))
+			for j, v := range window {
+				deviations[j] = math.Abs(v - m)
+			}
+			expected[i], spread[i] = m, madScale*median(deviations)
+		}
+	default:
+		for i := opts.Window; i < len(vals); i++ {
+			window := vals[i-opts.Window : i]
+			expected[i], spread[i] = mean(window), stddev(window)
+		}
+	}
+
+	anomalies := make([]Anomaly, 0)
+	for i, p := range points {
+		if math.IsNaN(expected[i]) || spread[i] == 0 {
+			continue
+		}
+		score := (p.Value - expected[i]) / spread[i]
+		if math.Abs(score) <= opts.Threshold {
+			continue
+		}
+		anomalies = append(anomalies, Anomaly{
+			Timestamp: p.Timestamp,
+			Value:     p.Value,
+			Expected:  expected[i],
+			Lower:     expected[i] - opts.Threshold*spread[i],
+			Upper:     expected[i] + opts.Threshold*spread[i],
+			Score:     score,
+		})
+	}
+	return anomalies, nil
+}
@@ -0,0 +1,55 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectAnomalies(t *testing.T) {
+	start := time.Date(2022, 4, 25, 0, 0, 0, 0, time.UTC)
+	var points []Point
+	for i := 0; i < 100; i++ {
+		// a seasonal series of 5 points with some noise
+		value := 10 + 2*float64(i%5) + 0.5*math.Sin(float64(i)*1.7)
+		if i == 60 {
+			value = 100
+		}
+		points = append(points, Point{Timestamp: start.Add(time.Duration(i) * time.Minute), Value: value})
+	}
+
+	for _, method := range []Method{MethodZScore, MethodMAD, MethodHoltWinters} {
+		anomalies, err := DetectAnomalies(points, AnomalyOptions{Method: method, Window: 20, Season: 5, Threshold: 3})
+		assert.Nil(t, err, method)
+		if assert.Len(t, anomalies, 1, method) {
+			anomaly := anomalies[0]
+			assert.Equal(t, points[60].Timestamp, anomaly.Timestamp, method)
+			assert.Equal(t, 100.0, anomaly.Value, method)
+			assert.Greater(t, anomaly.Score, 3.0, method)
+			assert.Less(t, anomaly.Upper, anomaly.Value, method)
+			assert.InDelta(t, anomaly.Expected, (anomaly.Lower+anomaly.Upper)/2, 1e-9, method)
+		}
+	}
+}
+
+func TestDetectAnomaliesWithoutEnoughPoints(t *testing.T) {
+	points := []Point{{Value: 1}, {Value: 2}, {Value: 50}}
+	anomalies, err := DetectAnomalies(points, AnomalyOptions{Method: MethodZScore, Window: 5, Threshold: 3})
+	assert.Nil(t, err)
+	assert.Empty(t, anomalies)
+
+	// the model of the season can't be fitted on less than two seasons
+	_, err = DetectAnomalies(points, AnomalyOptions{Method: MethodHoltWinters, Window: 5, Season: 24, Threshold: 3})
+	assert.NotNil(t, err)
+}
+
+func TestParseMethod(t *testing.T) {
+	method, err := ParseMethod("")
+	assert.Nil(t, err)
+	assert.Equal(t, MethodZScore, method)
+
+	_, err = ParseMethod("prophet")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,142 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"sky/api/internal/model"
+)
+
+// Range is the time range of a compared query
+type Range struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Change compares a current value with the previous one; the values are missing if there is no point, and the
+// percentage is missing if the previous value is 0
+type Change struct {
+	Current      *float64 `json:"current"`
+	Previous     *float64 `json:"previous"`
+	Delta        *float64 `json:"delta"`
+	DeltaPercent *float64 `json:"deltaPercent"`
+}
+
+// NewChange returns the absolute and percentage changes from the previous value to the current one
+func NewChange(current, previous *float64) Change {
+	c := Change{Current: current, Previous: previous}
+	if current == nil || previous == nil {
+		return c
+	}
+	delta := *current - *previous
+	c.Delta = &delta
+	if *previous != 0 {
+		percent := 100 * delta / math.Abs(*previous)
+		c.DeltaPercent = &percent
+	}
+	return c
+}
+
+// ComparedPoint is a point of the current range with the aligned point of the previous range, by metric type
+type ComparedPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	// PreviousTimestamp is the time of the aligned previous point, missing if there is none
+	PreviousTimestamp *time.Time        `json:"previousTimestamp"`
+	Values            map[string]Change `json:"values"`
+}
+
+// SeriesComparison compares a series with the series of the previous range
+type SeriesComparison struct {
+	Offset   string          `json:"offset"`
+	Current  Range           `json:"current"`
+	Previous Range           `json:"previous"`
+	Points   []ComparedPoint `json:"points"`
+}
+
+// AverageComparison compares the averages of a range with the ones of the previous range
+type AverageComparison struct {
+	Offset   string               `json:"offset"`
+	Current  *model.MetricAverage `json:"current"`
+	Previous *model.MetricAverage `json:"previous"`
+	Values   map[string]Change    `json:"values"`
+}
+
+// CompareSeries aligns every point of the current series with the point of the previous series closest to its time
+// shifted back by the offset, within half the typical time between the current points, so the buckets of a
+// frequency are aligned with the same buckets of the previous range. The previous points without a current point
+// are left out.
+func CompareSeries(current, previous []model.Metric, metricTypes []model.MetricType, shift func(time.Time) time.Time) []ComparedPoint {
+	sorted := append([]model.Metric(nil), previous...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Timestamp.Before(sorted[j].Timestamp) })
+	tolerance := InferStep(Points(current, model.MetricTypeNone)) / 2
+
+	points := make([]ComparedPoint, 0, len(current))
+	for _, m := range current {
+		p := ComparedPoint{Timestamp: m.Timestamp, Values: make(map[string]Change, len(metricTypes))}
+		prev := closest(sorted, shift(m.Timestamp), tolerance)
+		if prev != nil {
+			p.PreviousTimestamp = &prev.Timestamp
+		}
+		for _, metricType := range metricTypes {
+			var previousValue *float64
+			if prev != nil {
+				previousValue = metricValue(*prev, metricType)
+			}
+			p.Values[metricType.String()] = NewChange(metricValue(m, metricType), previousValue)
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+// CompareAverages returns the changes of the averages of the metric types
+func CompareAverages(current, previous *model.MetricAverage, metricTypes []model.MetricType) map[string]Change {
+	changes := make(map[string]Change, len(metricTypes))
+	for _, metricType := range metricTypes {
+		changes[metricType.String()] = NewChange(averageValue(current, metricType), averageValue(previous, metricType))
+	}
+	return changes
+}
+
+// closest returns the metric of the sorted metrics closest to the time, within the tolerance
+func closest(sorted []model.Metric, t time.Time, tolerance time.Duration) *model.Metric {
+	i := sort.Search(len(sorted), func(i int) bool { return !sorted[i].Timestamp.Before(t) })
+	var best *model.Metric
+	for _, j := range []int{i - 1, i} {
+		if j < 0 || j >= len(sorted) {
+			continue
+		}
+		d := absDuration(sorted[j].Timestamp.Sub(t))
+		if d <= tolerance && (best == nil || d < absDuration(best.Timestamp.Sub(t))) {
+			best = &sorted[j]
+		}
+	}
+	return best
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+func metricValue(m model.Metric, metricType model.MetricType) *float64 {
+	v := m.CPULoad
+	if metricType == model.MetricTypeConcurrency {
+		v = float64(m.Concurrency)
+	}
+	return &v
+}
+
+func averageValue(avg *model.MetricAverage, metricType model.MetricType) *float64 {
+	if avg == nil {
+		return nil
+	}
+	v := avg.CPULoad
+	if metricType == model.MetricTypeConcurrency {
+		v = avg.Concurrency
+	}
+	return &v
+}
@@ -0,0 +1,63 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sky/api/internal/model"
+)
+
+func TestCompareSeries(t *testing.T) {
+	week := 7 * 24 * time.Hour
+	start := time.Date(2022, 4, 25, 0, 0, 0, 0, time.UTC)
+	current := []model.Metric{
+		{Timestamp: start, CPULoad: 60, Concurrency: 1200},
+		{Timestamp: start.Add(time.Hour), CPULoad: 30, Concurrency: 900},
+		{Timestamp: start.Add(2 * time.Hour), CPULoad: 45, Concurrency: 1000},
+	}
+	previous := []model.Metric{
+		// slightly off the bucket, still aligned
+		{Timestamp: start.Add(-week + time.Hour + time.Minute), CPULoad: 0, Concurrency: 1000},
+		{Timestamp: start.Add(-week), CPULoad: 40, Concurrency: 1000},
+	}
+
+	points := CompareSeries(current, previous, []model.MetricType{model.MetricTypeCPULoad, model.MetricTypeConcurrency},
+		func(t time.Time) time.Time { return t.Add(-week) })
+	if !assert.Len(t, points, 3) {
+		return
+	}
+
+	cpu := points[0].Values["cpu_load"]
+	assert.Equal(t, start.Add(-week), *points[0].PreviousTimestamp)
+	assert.Equal(t, 40.0, *cpu.Previous)
+	assert.Equal(t, 20.0, *cpu.Delta)
+	assert.Equal(t, 50.0, *cpu.DeltaPercent)
+	assert.Equal(t, 20.0, *points[0].Values["concurrency"].DeltaPercent)
+
+	// the previous value is 0, so the percentage is not defined
+	cpu = points[1].Values["cpu_load"]
+	assert.Equal(t, 30.0, *cpu.Delta)
+	assert.Nil(t, cpu.DeltaPercent)
+	assert.Equal(t, -10.0, *points[1].Values["concurrency"].DeltaPercent)
+
+	// no previous point
+	assert.Nil(t, points[2].PreviousTimestamp)
+	assert.Equal(t, 45.0, *points[2].Values["cpu_load"].Current)
+	assert.Nil(t, points[2].Values["cpu_load"].Previous)
+	assert.Nil(t, points[2].Values["cpu_load"].Delta)
+}
+
+func TestCompareAverages(t *testing.T) {
+	current := &model.MetricAverage{CPULoad: 30, Concurrency: 1500}
+	previous := &model.MetricAverage{CPULoad: 40, Concurrency: 1000}
+
+	changes := CompareAverages(current, previous, []model.MetricType{model.MetricTypeCPULoad, model.MetricTypeConcurrency})
+	assert.Equal(t, -10.0, *changes["cpu_load"].Delta)
+	assert.Equal(t, -25.0, *changes["cpu_load"].DeltaPercent)
+	assert.Equal(t, 50.0, *changes["concurrency"].DeltaPercent)
+
+	changes = CompareAverages(current, nil, []model.MetricType{model.MetricTypeCPULoad})
+	assert.Equal(t, 30.0, *changes["cpu_load"].Current)
+	assert.Nil(t, changes["cpu_load"].Delta)
+}
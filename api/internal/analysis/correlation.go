@@ -0,0 +1,181 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// maxAlignedPoints limits the number of steps the series are aligned to
+const maxAlignedPoints = 100000
+
+// Series is a named series of points
+type Series struct {
+	Name   string
+	Points []Point
+}
+
+// LagCorrelation is the correlation of the first series of a pair with the second series shifted by the lag;
+// at a positive lag the second series follows the first one
+type LagCorrelation struct {
+	// Lag is the number of steps the second series is shifted by
+	Lag    int    `json:"lag"`
+	Offset string `json:"offset"`
+	// Correlation is the pearson correlation; it is missing if it is not defined, e.g. for a constant series
+	Correlation *float64 `json:"correlation"`
+}
+
+// PairCorrelation is the correlation of two aligned series
+type PairCorrelation struct {
+	X string `json:"x"`
+	Y string `json:"y"`
+	// Points is the number of steps where both series have a value
+	Points           int              `json:"points"`
+	Pearson          *float64         `json:"pearson"`
+	Spearman         *float64         `json:"spearman"`
+	CrossCorrelation []LagCorrelation `json:"crossCorrelation,omitempty"`
+	// BestLag is the lag of the strongest, positive or negative, correlation
+	BestLag *LagCorrelation `json:"bestLag,omitempty"`
+}
+
+// CorrelationResult is the correlation of every pair of the series
+type CorrelationResult struct {
+	Step  string            `json:"step"`
+	Pairs []PairCorrelation `json:"pairs"`
+}
+
+// Correlate aligns the series to the step, averaging the points of a step, and correlates every pair of them,
+// including the cross-correlation at the lags from -maxLag to maxLag steps
+func Correlate(series []Series, step time.Duration, maxLag int) (*CorrelationResult, error) {
+	grid, err := align(series, step)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &CorrelationResult{Step: step.String(), Pairs: make([]PairCorrelation, 0)}
+	for i := 0; i < len(series); i++ {
+		for j := i + 1; j < len(series); j++ {
+			x, y := grid[i], grid[j]
+			pair := PairCorrelation{X: series[i].Name, Y: series[j].Name}
+			pair.Points, pair.Pearson = pearson(x, y, 0)
+			_, pair.Spearman = spearman(x, y)
+
+			for lag := -maxLag; lag <= maxLag && maxLag > 0; lag++ {
+				_, c := pearson(x, y, lag)
+				lc := LagCorrelation{Lag: lag, Offset: (time.Duration(lag) * step).String(), Correlation: c}
+				pair.CrossCorrelation = append(pair.CrossCorrelation, lc)
+				if c != nil && (pair.BestLag == nil || math.Abs(*c) > math.Abs(*pair.BestLag.Correlation)) {
+					best := lc
+					pair.BestLag = &best
+				}
+			}
+			result.Pairs = append(result.Pairs, pair)
+		}
+	}
+	return result, nil
+}
+
+// align returns the average of every series at every step from the first to the last point; NaN marks the steps
+// without points
+func align(series []Series, step time.Duration) ([][]float64, error) {
+	var first, last time.Time
+	for _, s := range series {
+		for _, p := range s.Points {
+			if first.IsZero() || p.Timestamp.Before(first) {
+				first = p.Timestamp
+			}
+			if p.Timestamp.After(last) {
+				last = p.Timestamp
+			}
+		}
+	}
+	first = first.Truncate(step)
+	n := int(last.Sub(first)/step) + 1
+	if n > maxAlignedPoints {
+		return nil, fmt.Errorf("the range has more than %d steps of %s", maxAlignedPoints, step)
+	}
+
+	grid := make([][]float64, len(series))
+	for i, s := range series {
+		sums := make([]float64, n)
+		counts := make([]int, n)
+		for _, p := range s.Points {
+			k := int(p.Timestamp.Sub(first) / step)
+			sums[k] += p.Value
+			counts[k]++
+		}
+		grid[i] = make([]float64, n)
+		for k := range sums {
+			grid[i][k] = math.NaN()
+			if counts[k] > 0 {
+				grid[i][k] = sums[k] / float64(counts[k])
+			}
+		}
+	}
+	return grid, nil
+}
+
+// pearson returns the number of pairs and the pearson correlation of x[t] and y[t+lag], over the steps where both
+// have a value; the correlation is nil if it is not defined
+func pearson(x, y []float64, lag int) (int, *float64) {
+	var xs, ys []float64
+	for t := range x {
+		if t+lag < 0 || t+lag >= len(y) || math.IsNaN(x[t]) || math.IsNaN(y[t+lag]) {
+			continue
+		}
+		xs = append(xs, x[t])
+		ys = append(ys, y[t+lag])
+	}
+	if len(xs) < 2 {
+		return len(xs), nil
+	}
+
+	xMean, yMean := mean(xs), mean(ys)
+	var sxy, sxx, syy float64
+	for i := range xs {
+		sxy += (xs[i] - xMean) * (ys[i] - yMean)
+		sxx += (xs[i] - xMean) * (xs[i] - xMean)
+		syy += (ys[i] - yMean) * (ys[i] - yMean)
+	}
+	if sxx == 0 || syy == 0 {
+		return len(xs), nil
+	}
+	c := sxy / math.Sqrt(sxx*syy)
+	return len(xs), &c
+}
+
+// spearman returns the number of pairs and the spearman rank correlation of x and y
+func spearman(x, y []float64) (int, *float64) {
+	var xs, ys []float64
+	for t := range x {
+		if !math.IsNaN(x[t]) && !math.IsNaN(y[t]) {
+			xs = append(xs, x[t])
+			ys = append(ys, y[t])
+		}
+	}
+	return pearson(ranks(xs), ranks(ys), 0)
+}
+
+// ranks returns the rank of every value, ties getting the average of their ranks
+func ranks(values []float64) []float64 {
+	order := make([]int, len(values))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return values[order[a]] < values[order[b]] })
+
+	r := make([]float64, len(values))
+	for i := 0; i < len(order); {
+		j := i
+		for j+1 < len(order) && values[order[j+1]] == values[order[i]] {
+			j++
+		}
+		rank := float64(i+j)/2 + 1
+		for k := i; k <= j; k++ {
+			r[order[k]] = rank
+		}
+		i = j + 1
+	}
+	return r
+}
@@ -0,0 +1,90 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorrelate(t *testing.T) {
+	start := time.Date(2022, 4, 25, 0, 0, 0, 0, time.UTC)
+	concurrency := Series{Name: "concurrency"}
+	cpuLoad := Series{Name: "cpu_load"}
+	exponential := Series{Name: "exponential"}
+	for i := 0; i < 200; i++ {
+		// two points a step, averaged by the alignment
+		for _, offset := range []time.Duration{0, 30 * time.Second} {
+			timestamp := start.Add(time.Duration(i)*time.Minute + offset)
+			concurrency.Points = append(concurrency.Points, Point{Timestamp: timestamp, Value: 1000 + 100*math.Sin(float64(i)/5)})
+			// cpu load follows concurrency by 3 minutes
+			cpuLoad.Points = append(cpuLoad.Points, Point{Timestamp: timestamp, Value: 50 + 10*math.Sin(float64(i-3)/5)})
+			exponential.Points = append(exponential.Points, Point{Timestamp: timestamp, Value: math.Exp(math.Sin(float64(i) / 5))})
+		}
+	}
+
+	result, err := Correlate([]Series{concurrency, cpuLoad, exponential}, time.Minute, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, "1m0s", result.Step)
+	assert.Len(t, result.Pairs, 3)
+
+	lagged := result.Pairs[0]
+	assert.Equal(t, "concurrency", lagged.X)
+	assert.Equal(t, "cpu_load", lagged.Y)
+	assert.Equal(t, 200, lagged.Points)
+	assert.Len(t, lagged.CrossCorrelation, 11)
+	assert.Equal(t, 3, lagged.BestLag.Lag)
+	assert.Equal(t, "3m0s", lagged.BestLag.Offset)
+	assert.InDelta(t, 1, *lagged.BestLag.Correlation, 1e-9)
+	assert.Less(t, *lagged.Pearson, *lagged.BestLag.Correlation)
+
+	// a monotonic transformation has a perfect rank correlation, but not a perfect linear one
+	monotonic := result.Pairs[1]
+	assert.Equal(t, "exponential", monotonic.Y)
+	assert.InDelta(t, 1, *monotonic.Spearman, 1e-9)
+	assert.Less(t, *monotonic.Pearson, 0.99)
+}
+
+func TestCorrelateUnordered(t *testing.T) {
+	start := time.Date(2022, 4, 25, 0, 0, 0, 0, time.UTC)
+	var concurrency, cpuLoad Series
+	for i := 0; i < 60; i++ {
+		timestamp := start.Add(time.Duration(i) * time.Minute)
+		concurrency.Points = append(concurrency.Points, Point{Timestamp: timestamp, Value: 1000 + 10*float64(i%10)})
+		cpuLoad.Points = append(cpuLoad.Points, Point{Timestamp: timestamp, Value: float64(i % 10)})
+	}
+	// the newest points first, like a store returning the points out of order
+	for _, s := range []*Series{&concurrency, &cpuLoad} {
+		for i, j := 0, len(s.Points)-1; i < j; i, j = i+1, j-1 {
+			s.Points[i], s.Points[j] = s.Points[j], s.Points[i]
+		}
+	}
+
+	step := InferStep(concurrency.Points)
+	assert.Equal(t, time.Minute, step)
+	result, err := Correlate([]Series{concurrency, cpuLoad}, step, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 60, result.Pairs[0].Points)
+	assert.InDelta(t, 1, *result.Pairs[0].Pearson, 1e-9)
+}
+
+func TestCorrelateConstantSeries(t *testing.T) {
+	start := time.Date(2022, 4, 25, 0, 0, 0, 0, time.UTC)
+	var constant, varying Series
+	for i := 0; i < 10; i++ {
+		timestamp := start.Add(time.Duration(i) * time.Minute)
+		constant.Points = append(constant.Points, Point{Timestamp: timestamp, Value: 1})
+		varying.Points = append(varying.Points, Point{Timestamp: timestamp, Value: float64(i)})
+	}
+
+	result, err := Correlate([]Series{constant, varying}, time.Minute, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, result.Pairs[0].Pearson)
+	assert.Nil(t, result.Pairs[0].BestLag)
+	assert.Empty(t, result.Pairs[0].CrossCorrelation)
+}
+
+func TestRanks(t *testing.T) {
+	assert.Equal(t, []float64{3, 1, 4.5, 2, 4.5}, ranks([]float64{7, 1, 9, 3, 9}))
+}
@@ -0,0 +1,171 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// ForecastModel is the model fitted on the history of a forecast
+type ForecastModel string
+
+const (
+	// ModelLinear fits a least squares linear trend
+	ModelLinear ForecastModel = "linear"
+	// ModelHoltWinters fits an additive Holt-Winters model, with the seasonality of the options
+	ModelHoltWinters ForecastModel = "holtwinters"
+)
+
+// ParseForecastModel returns the model for its name; an empty name is ModelHoltWinters
+func ParseForecastModel(name string) (ForecastModel, error) {
+	switch m := ForecastModel(name); m {
+	case "":
+		return ModelHoltWinters, nil
+	case ModelLinear, ModelHoltWinters:
+		return m, nil
+	default:
+		return "", fmt.Errorf("model is not valid; expected linear or holtwinters, but received %s", name)
+	}
+}
+
+// ForecastOptions sets up a forecast
+type ForecastOptions struct {
+	Model ForecastModel
+	// Step is the time between the points of the history and of the forecast
+	Step time.Duration
+	// Horizon is the number of forecast points
+	Horizon int
+	// Season is the number of points of a season for ModelHoltWinters; 0 fits a trend without seasonality
+	Season int
+	// Confidence is the probability of the future values falling within the interval of their forecast, e.g. 0.95
+	Confidence float64
+}
+
+// ForecastPoint is a predicted value with its confidence interval
+type ForecastPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+	Lower     float64   `json:"lower"`
+	Upper     float64   `json:"upper"`
+}
+
+// ForecastResult is the forecast of a series
+type ForecastResult struct {
+	Model      ForecastModel   `json:"model"`
+	Step       string          `json:"step"`
+	Confidence float64         `json:"confidence"`
+	Points     []ForecastPoint `json:"points"`
+}
+
+// InferStep returns the median time between the consecutive points in time, as the step of an unaggregated series;
+// the points don't have to be ordered
+func InferStep(points []Point) time.Duration {
+	if len(points) < 2 {
+		return 0
+	}
+	timestamps := make([]time.Time, 0, len(points))
+	for _, p := range points {
+		timestamps = append(timestamps, p.Timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i].Before(timestamps[j]) })
+
+	steps := make([]float64, 0, len(timestamps)-1)
+	for i := 1; i < len(timestamps); i++ {
+		steps = append(steps, float64(timestamps[i].Sub(timestamps[i-1])))
+	}
+	sort.Float64s(steps)
+	return time.Duration(steps[len(steps)/2])
+}
+
+// Forecast fits the model on the points, which are expected to be a step apart, and predicts the Horizon points
+// after the last one
+func Forecast(points []Point, opts ForecastOptions) (*ForecastResult, error) {
+	vals := values(points)
+	// the quantile of the normal distribution for the two sided interval
+	z := math.Sqrt2 * math.Erfinv(opts.Confidence)
+
+	var predict func(k int) (value, deviation float64)
+	switch opts.Model {
+	case ModelLinear:
+		if len(vals) < 3 {
+			return nil, fmt.Errorf("at least 3 points are needed to fit a linear trend, but the series has %d", len(vals))
+		}
+		predict = linearTrend(vals)
+	default:
+		hw := fitHoltWinters(vals, opts.Season)
+		if hw == nil {
+			return nil, fmt.Errorf("at least %d points are needed to fit a model with a season of %d points, but the series has %d",
+				minHoltWintersPoints(opts.Season), opts.Season, len(vals))
+		}
+		predict = hw.predict
+	}
+
+	last := points[len(points)-1].Timestamp
+	result := &ForecastResult{
+		Model:      opts.Model,
+		Step:       opts.Step.String(),
+		Confidence: opts.Confidence,
+		Points:     make([]ForecastPoint, 0, opts.Horizon),
+	}
+	for k := 1; k <= opts.Horizon; k++ {
+		value, deviation := predict(k)
+		result.Points = append(result.Points, ForecastPoint{
+			Timestamp: last.Add(time.Duration(k) * opts.Step),
+			Value:     value,
+			Lower:     value - z*deviation,
+			Upper:     value + z*deviation,
+		})
+	}
+	return result, nil
+}
+
+// linearTrend fits a least squares line on the values by their index; the deviation of a prediction is the standard
+// error of a new observation
+func linearTrend(vals []float64) func(k int) (float64, float64) {
+	n := float64(len(vals))
+	xMean := (n - 1) / 2
+	yMean := mean(vals)
+	var sxx, sxy float64
+	for i, y := range vals {
+		x := float64(i)
+		sxx += (x - xMean) * (x - xMean)
+		sxy += (x - xMean) * (y - yMean)
+	}
+	slope := sxy / sxx
+	intercept := yMean - slope*xMean
+
+	var sse float64
+	for i, y := range vals {
+		r := y - (intercept + slope*float64(i))
+		sse += r * r
+	}
+	sigma := math.Sqrt(sse / (n - 2))
+
+	return func(k int) (float64, float64) {
+		x := n - 1 + float64(k)
+		return intercept + slope*x, sigma * math.Sqrt(1+1/n+(x-xMean)*(x-xMean)/sxx)
+	}
+}
+
+// predict returns the forecast k steps ahead and its deviation, which grows with k by the approximation of the
+// variance of the additive Holt-Winters forecasts
+func (h *holtWinters) predict(k int) (float64, float64) {
+	fitted := 0
+	for _, f := range h.fitted {
+		if !math.IsNaN(f) {
+			fitted++
+		}
+	}
+	sigma := math.Sqrt(h.sse / float64(fitted))
+
+	variance := 1.0
+	for j := 1; j < k; j++ {
+		c := h.alpha * (1 + float64(j)*h.beta)
+		if h.season > 1 && j%h.season == 0 {
+			c += h.gamma * (1 - h.alpha)
+		}
+		variance += c * c
+	}
+	return h.forecast(k), sigma * math.Sqrt(variance)
+}
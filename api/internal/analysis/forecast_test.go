@@ -0,0 +1,47 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForecast(t *testing.T) {
+	start := time.Date(2022, 4, 25, 0, 0, 0, 0, time.UTC)
+	var trend, seasonal []Point
+	for i := 0; i < 96; i++ {
+		timestamp := start.Add(time.Duration(i) * time.Hour)
+		noise := 0.5 * math.Sin(float64(i)*1.7)
+		trend = append(trend, Point{Timestamp: timestamp, Value: 100 + 2*float64(i) + noise})
+		seasonal = append(seasonal, Point{Timestamp: timestamp, Value: 100 + 20*math.Sin(2*math.Pi*float64(i%24)/24) + noise})
+	}
+	assert.Equal(t, time.Hour, InferStep(trend))
+
+	linear, err := Forecast(trend, ForecastOptions{Model: ModelLinear, Step: time.Hour, Horizon: 24, Confidence: 0.95})
+	assert.Nil(t, err)
+	assert.Len(t, linear.Points, 24)
+	assert.Equal(t, "1h0m0s", linear.Step)
+	for k, p := range linear.Points {
+		assert.Equal(t, start.Add(time.Duration(96+k)*time.Hour), p.Timestamp)
+		assert.InDelta(t, 100+2*float64(96+k), p.Value, 1)
+		assert.Less(t, p.Lower, p.Value)
+		assert.Greater(t, p.Upper, p.Value)
+	}
+
+	hw, err := Forecast(seasonal, ForecastOptions{Model: ModelHoltWinters, Step: time.Hour, Horizon: 24, Season: 24, Confidence: 0.9})
+	assert.Nil(t, err)
+	for k, p := range hw.Points {
+		expected := 100 + 20*math.Sin(2*math.Pi*float64((96+k)%24)/24)
+		assert.InDelta(t, expected, p.Value, 2, "hour %d", k)
+		assert.LessOrEqual(t, p.Lower, expected)
+		assert.GreaterOrEqual(t, p.Upper, expected)
+	}
+	// the interval widens with the horizon
+	first, last := hw.Points[0], hw.Points[len(hw.Points)-1]
+	assert.Greater(t, last.Upper-last.Lower, first.Upper-first.Lower)
+
+	_, err = Forecast(seasonal[:30], ForecastOptions{Model: ModelHoltWinters, Step: time.Hour, Horizon: 24, Season: 24, Confidence: 0.9})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,99 @@
+package analysis
+
+import "math"
+
+// holtWinters is an additive Holt-Winters model; without a season (0 or 1) it is Holt's linear trend model
+type holtWinters struct {
+	alpha, beta, gamma float64
+	season             int
+
+	level, trend float64
+	seasonal     []float64
+	// n is the number of the fitted values
+	n int
+	// fitted are the one step ahead forecasts of the fitted values; NaN for the values initialising the model
+	fitted []float64
+	sse    float64
+}
+
+// minHoltWintersPoints returns how many values are needed to fit a model of the season
+func minHoltWintersPoints(season int) int {
+	if season > 1 {
+		return 2 * season
+	}
+	return 2
+}
+
+// fitHoltWinters fits the model with the smoothing parameters of the smallest squared one step ahead error;
+// it returns nil if there are not enough values for the season
+func fitHoltWinters(values []float64, season int) *holtWinters {
+	if len(values) < minHoltWintersPoints(season) {
+		return nil
+	}
+
+	gammas := []float64{0}
+	if season > 1 {
+		gammas = []float64{0.1, 0.3, 0.5, 0.7}
+	}
+	var best *holtWinters
+	for _, alpha := range []float64{0.1, 0.3, 0.5, 0.7, 0.9} {
+		for _, beta := range []float64{0.01, 0.05, 0.1, 0.3} {
+			for _, gamma := range gammas {
+				h := &holtWinters{alpha: alpha, beta: beta, gamma: gamma, season: season}
+				h.fit(values)
+				if best == nil || h.sse < best.sse {
+					best = h
+				}
+			}
+		}
+	}
+	return best
+}
+
+func (h *holtWinters) fit(values []float64) {
+	h.n = len(values)
+	h.fitted = make([]float64, len(values))
+	start := 1
+	if h.season > 1 {
+		start = h.season
+		first, second := mean(values[:h.season]), mean(values[h.season:2*h.season])
+		h.level = first
+		h.trend = (second - first) / float64(h.season)
+		h.seasonal = make([]float64, h.season)
+		for i := 0; i < h.season; i++ {
+			h.seasonal[i] = values[i] - first
+		}
+	} else {
+		h.level = values[0]
+		h.trend = values[1] - values[0]
+	}
+	for i := 0; i < start; i++ {
+		h.fitted[i] = math.NaN()
+	}
+
+	for t := start; t < len(values); t++ {
+		s := h.seasonalAt(t)
+		forecast := h.level + h.trend + s
+		h.fitted[t] = forecast
+		h.sse += (values[t] - forecast) * (values[t] - forecast)
+
+		level := h.alpha*(values[t]-s) + (1-h.alpha)*(h.level+h.trend)
+		h.trend = h.beta*(level-h.level) + (1-h.beta)*h.trend
+		h.level = level
+		if h.season > 1 {
+			h.seasonal[t%h.season] = h.gamma*(values[t]-level) + (1-h.gamma)*s
+		}
+	}
+}
+
+func (h *holtWinters) seasonalAt(t int) float64 {
+	if h.season > 1 {
+		return h.seasonal[t%h.season]
+	}
+	return 0
+}
+
+// forecast returns the value forecast k steps after the last fitted value
+func (h *holtWinters) forecast(k int) float64 {
+	return h.level + float64(k)*h.trend + h.seasonalAt(h.n+k-1)
+}
@@ -0,0 +1,267 @@
+package collector
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"sky/api/internal/model"
+)
+
+const (
+	// ConcurrencyConnections counts the established tcp connections of the host
+	ConcurrencyConnections = "connections"
+	// ConcurrencyProcesses counts the processes (scheduling entities) of the host
+	ConcurrencyProcesses = "processes"
+)
+
+// Writer is an interface for saving the collected metrics
+type Writer interface {
+	InsertMetrics(ctx context.Context, metrics []model.Metric) error
+}
+
+// Collector samples the cpu load and concurrency of the host from the proc filesystem
+type Collector struct {
+	writer      Writer
+	procPath    string
+	interval    time.Duration
+	concurrency string
+
+	prevCPU *cpuTimes
+}
+
+type cpuTimes struct {
+	busy  uint64
+	total uint64
+}
+
+// NewCollector creates a collector that reads from procPath (usually /proc) every interval;
+// concurrency is either ConcurrencyConnections or ConcurrencyProcesses
+func NewCollector(writer Writer, procPath string, interval time.Duration, concurrency string) (*Collector, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("collection interval has to be positive; received %s", interval)
+	}
+	if concurrency != ConcurrencyConnections && concurrency != ConcurrencyProcesses {
+		return nil, fmt.Errorf("concurrency source is not valid; expected %s or %s, but received %s",
+			ConcurrencyConnections, ConcurrencyProcesses, concurrency)
+	}
+	return &Collector{
+		writer:      writer,
+		procPath:    procPath,
+		interval:    interval,
+		concurrency: concurrency,
+	}, nil
+}
+
+// Run samples and saves the metrics until the context is cancelled;
+// a failed sample or write is logged and the collection continues with the next tick
+func (c *Collector) Run(ctx context.Context) error {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	// the first sample primes the cpu counters, so the load of the first tick covers a full interval
+	if _, err := c.readCPUTimes(); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			metric, err := c.Sample(now)
+			if err != nil {
+				log.Printf("failed to sample host metrics: %s", err.Error())
+				continue
+			}
+			if err := c.writer.InsertMetrics(ctx, []model.Metric{metric}); err != nil {
+				log.Printf("failed to save host metrics: %s", err.Error())
+			}
+		}
+	}
+}
+
+// Sample reads the current cpu load and concurrency of the host
+func (c *Collector) Sample(now time.Time) (model.Metric, error) {
+	load, err := c.cpuLoad()
+	if err != nil {
+		return model.Metric{}, err
+	}
+
+	var concurrency int
+	switch c.concurrency {
+	case ConcurrencyConnections:
+		concurrency, err = c.establishedConnections()
+	case ConcurrencyProcesses:
+		concurrency, err = c.processes()
+	}
+	if err != nil {
+		return model.Metric{}, err
+	}
+	if concurrency > math.MaxInt32 {
+		concurrency = math.MaxInt32
+	}
+
+	return model.Metric{
+		Timestamp:   now,
+		CPULoad:     load,
+		Concurrency: int32(concurrency),
+	}, nil
+}
+
+// cpuLoad returns the cpu usage in percentage since the previous sample;
+// without a previous sample, the 1 minute load average relative to the number of cpus is used
+func (c *Collector) cpuLoad() (float64, error) {
+	prev := c.prevCPU
+	curr, err := c.readCPUTimes()
+	if err != nil {
+		return 0, err
+	}
+	if prev != nil && curr.total > prev.total {
+		return round(float64(curr.busy-prev.busy) / float64(curr.total-prev.total) * 100), nil
+	}
+	return c.loadAverage()
+}
+
+// readCPUTimes parses the aggregated cpu line of /proc/stat and stores it as the previous sample
+func (c *Collector) readCPUTimes() (*cpuTimes, error) {
+	lines, err := c.readLines("stat")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		var times cpuTimes
+		for i, field := range fields[1:] {
+			// guest and guest_nice, the 9th and 10th values, are already counted in user and nice
+			if i >= 8 {
+				break
+			}
+			v, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("cpu times are not valid in %s: %w", filepath.Join(c.procPath, "stat"), err)
+			}
+			times.total += v
+			// idle and iowait are the 4th and 5th values
+			if i != 3 && i != 4 {
+				times.busy += v
+			}
+		}
+		c.prevCPU = &times
+		return &times, nil
+	}
+	return nil, fmt.Errorf("cpu line is missing from %s", filepath.Join(c.procPath, "stat"))
+}
+
+func (c *Collector) loadAverage() (float64, error) {
+	fields, err := c.loadAvgFields()
+	if err != nil {
+		return 0, err
+	}
+	avg, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("load average is not valid: %w", err)
+	}
+
+	lines, err := c.readLines("stat")
+	if err != nil {
+		return 0, err
+	}
+	cpus := 0
+	for _, line := range lines {
+		if strings.HasPrefix(line, "cpu") && !strings.HasPrefix(line, "cpu ") {
+			cpus++
+		}
+	}
+	if cpus == 0 {
+		cpus = 1
+	}
+	return round(math.Min(avg/float64(cpus)*100, 100)), nil
+}
+
+// processes returns the number of scheduling entities from the 4th field of /proc/loadavg (running/total)
+func (c *Collector) processes() (int, error) {
+	fields, err := c.loadAvgFields()
+	if err != nil {
+		return 0, err
+	}
+	parts := strings.Split(fields[3], "/")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("process count is not valid in %s: %s", filepath.Join(c.procPath, "loadavg"), fields[3])
+	}
+	return strconv.Atoi(parts[1])
+}
+
+// establishedConnections counts the tcp connections in ESTABLISHED (01) state, for both ipv4 and ipv6
+func (c *Collector) establishedConnections() (int, error) {
+	count := 0
+	found := false
+	for _, file := range []string{"net/tcp", "net/tcp6"} {
+		lines, err := c.readLines(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		found = true
+		if len(lines) == 0 {
+			continue
+		}
+		// the first line is the header of the table
+		for _, line := range lines[1:] {
+			fields := strings.Fields(line)
+			if len(fields) > 3 && fields[3] == "01" {
+				count++
+			}
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("no tcp connection tables found in %s", filepath.Join(c.procPath, "net"))
+	}
+	return count, nil
+}
+
+func (c *Collector) loadAvgFields() ([]string, error) {
+	lines, err := c.readLines("loadavg")
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("%s is empty", filepath.Join(c.procPath, "loadavg"))
+	}
+	fields := strings.Fields(lines[0])
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("%s is not valid: %s", filepath.Join(c.procPath, "loadavg"), lines[0])
+	}
+	return fields, nil
+}
+
+func (c *Collector) readLines(name string) ([]string, error) {
+	f, err := os.Open(filepath.Join(c.procPath, name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,57 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const tcpTable = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:6989 00000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 1 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:6989 0100007F:D2F4 01 00000000:00000000 00:00000000 00000000   999        0 2 1 0000000000000000 20 4 30 10 -1
+   2: 0100007F:D2F4 0100007F:6989 01 00000000:00000000 00:00000000 00000000   999        0 3 1 0000000000000000 20 4 30 10 -1
+`
+
+func TestSample(t *testing.T) {
+	proc := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(proc, "net"), 0755))
+	writeFile(t, proc, "net/tcp", tcpTable)
+	writeFile(t, proc, "loadavg", "1.00 0.50 0.25 2/512 12345\n")
+	writeFile(t, proc, "stat", "cpu  100 0 100 800 0 0 0 0 0 0\ncpu0 50 0 50 400 0 0 0 0 0 0\ncpu1 50 0 50 400 0 0 0 0 0 0\n")
+
+	c, err := NewCollector(nil, proc, time.Minute, ConcurrencyConnections)
+	assert.Nil(t, err)
+
+	// without a previous sample the load average is used: 1.00 on 2 cpus
+	metric, err := c.Sample(time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 50.0, metric.CPULoad)
+	assert.Equal(t, int32(2), metric.Concurrency)
+
+	// 300 busy out of 400 jiffies since the previous sample; the guest time is part of the user time
+	writeFile(t, proc, "stat", "cpu  250 0 250 900 0 0 0 0 100 0\n")
+	metric, err = c.Sample(time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 75.0, metric.CPULoad)
+
+	c, err = NewCollector(nil, proc, time.Minute, ConcurrencyProcesses)
+	assert.Nil(t, err)
+	metric, err = c.Sample(time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, int32(512), metric.Concurrency)
+}
+
+func TestNewCollectorValidation(t *testing.T) {
+	_, err := NewCollector(nil, "/proc", 0, ConcurrencyConnections)
+	assert.NotNil(t, err)
+
+	_, err = NewCollector(nil, "/proc", time.Minute, "threads")
+	assert.NotNil(t, err)
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+}
@@ -0,0 +1,373 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"sky/api/internal/model"
+	"sky/api/internal/storage/conflict"
+	"sky/api/internal/storage/late"
+)
+
+// Config is the optional json configuration file of the api, setting up the ingestion subsystems
+type Config struct {
+	Writes     WritesConfig     `json:"writes"`
+	Validation ValidationConfig `json:"validation"`
+	Pipeline   PipelineConfig   `json:"pipeline"`
+	Rollups    RollupsConfig    `json:"rollups"`
+	Retention  RetentionConfig  `json:"retention"`
+	Rules      RulesConfig      `json:"rules"`
+	Alerting   AlertingConfig   `json:"alerting"`
+	Scrape     ScrapeConfig     `json:"scrape"`
+	StatsD     StatsDConfig     `json:"statsd"`
+	Graphite   GraphiteConfig   `json:"graphite"`
+}
+
+// WritesConfig sets up how the written points are saved
+type WritesConfig struct {
+	// ConflictPolicy is what happens to a point with the same timestamp and series as a stored one:
+	// append (default), reject, overwrite, keep-first or merge
+	ConflictPolicy conflict.Policy `json:"conflictPolicy"`
+	// LatePolicy is what happens to a point older than the newest point of its series by more than the
+	// LateTolerance: accept (default), route (to the late data collections) or reject
+	LatePolicy    late.Policy `json:"latePolicy"`
+	LateTolerance Duration    `json:"lateTolerance"`
+}
+
+// ValidationConfig sets up the checks of the written points; invalid points are rejected
+type ValidationConfig struct {
+	// MaxFutureSkew is how far the timestamp of a point can be ahead of the clock of the api
+	MaxFutureSkew Duration `json:"maxFutureSkew"`
+	// MaxLabels is the maximum number of labels of a sample
+	MaxLabels int `json:"maxLabels"`
+	// Schemas are the rules of the named metrics; the cpu_load and concurrency fields of the metrics collection
+	// are validated by the schemas of the same name, with built in defaults
+	Schemas map[string]MetricSchema `json:"schemas"`
+}
+
+// MetricSchema declares the accepted values and labels of a metric
+type MetricSchema struct {
+	// Type is the expected sample type, e.g. gauge or counter; any type is accepted if empty
+	Type string   `json:"type"`
+	Min  *float64 `json:"min"`
+	Max  *float64 `json:"max"`
+	// RequiredLabels have to be set on every sample of the metric
+	RequiredLabels []string `json:"requiredLabels"`
+	// AllowedLabels are the only labels accepted, if set
+	AllowedLabels []string `json:"allowedLabels"`
+}
+
+// PipelineConfig sets up the buffering of the metrics written through the api
+type PipelineConfig struct {
+	// QueueSize is the number of metrics that can wait to be saved; writes are rejected while the queue is full
+	QueueSize int `json:"queueSize"`
+	// BatchSize is the number of metrics saved at once
+	BatchSize     int      `json:"batchSize"`
+	FlushInterval Duration `json:"flushInterval"`
+}
+
+// RollupsConfig sets up the background jobs maintaining the hourly and daily rollups of the metrics
+type RollupsConfig struct {
+	Enabled  bool     `json:"enabled"`
+	Interval Duration `json:"interval"`
+	// Lookback is how far before the previous run the buckets are rolled up again, to include the late points
+	Lookback Duration `json:"lookback"`
+}
+
+// RetentionConfig is how long the points of each resolution are kept; it is applied when the collections are
+// created, afterwards it can be changed through the api
+type RetentionConfig struct {
+	Raw    Duration `json:"raw"`
+	Hourly Duration `json:"hourly"`
+	Daily  Duration `json:"daily"`
+}
+
+// Seconds returns the retention in the seconds of the collection expiry
+func (c RetentionConfig) Seconds() model.Retention {
+	return model.Retention{
+		Raw:    int64(time.Duration(c.Raw).Seconds()),
+		Hourly: int64(time.Duration(c.Hourly).Seconds()),
+		Daily:  int64(time.Duration(c.Daily).Seconds()),
+	}
+}
+
+// RulesConfig lists the recording rules, whose results are saved as samples
+type RulesConfig struct {
+	// Interval is how often the rules are checked for completed windows
+	Interval Duration `json:"interval"`
+	// Delay is how long after its end a window is evaluated, so the points written late are included
+	Delay     Duration        `json:"delay"`
+	Recording []RecordingRule `json:"recording"`
+}
+
+// RecordingRule aggregates a metric over windows of the step, e.g. the hourly p95 of cpu_load
+type RecordingRule struct {
+	// Name is the name of the recorded series
+	Name string `json:"name"`
+	// Metric is the aggregated field of the metrics collection: cpu_load or concurrency
+	Metric string `json:"metric"`
+	// Aggregation is avg, min, max, sum, count or a percentile like p95
+	Aggregation string            `json:"aggregation"`
+	Step        Duration          `json:"step"`
+	Labels      map[string]string `json:"labels"`
+}
+
+// AlertingConfig lists the alerting rules and the webhooks notified when an alert fires or resolves
+type AlertingConfig struct {
+	// Interval is how often the rules are evaluated
+	Interval Duration    `json:"interval"`
+	Webhooks []Webhook   `json:"webhooks"`
+	Rules    []AlertRule `json:"rules"`
+}
+
+// Webhook is an endpoint the notifications are posted to as json; the headers are sent with every notification,
+// e.g. for authentication
+type Webhook struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+}
+
+// AlertRule fires when the aggregation of a metric over the window meets the condition for the For duration,
+// e.g. avg cpu_load over 5m > 90 for 10m
+type AlertRule struct {
+	Name string `json:"name"`
+	// Metric is the field of the metrics collection: cpu_load or concurrency
+	Metric string `json:"metric"`
+	// Aggregation is avg (default), min, max, sum, count or a percentile like p95
+	Aggregation string   `json:"aggregation"`
+	Window      Duration `json:"window"`
+	// Operator compares the aggregation to the threshold: >, >=, <, <=, == or !=
+	Operator  string   `json:"operator"`
+	Threshold float64  `json:"threshold"`
+	For       Duration `json:"for"`
+	// Labels are added to the notifications of the rule, e.g. the severity
+	Labels map[string]string `json:"labels"`
+}
+
+// ScrapeConfig lists the prometheus endpoints that are scraped periodically
+type ScrapeConfig struct {
+	Interval Duration       `json:"interval"`
+	Timeout  Duration       `json:"timeout"`
+	Targets  []ScrapeTarget `json:"targets"`
+}
+
+// ScrapeTarget is a prometheus exposition format endpoint; the labels are added to all of its samples
+type ScrapeTarget struct {
+	URL    string            `json:"url"`
+	Labels map[string]string `json:"labels"`
+}
+
+// StatsDConfig sets up the statsd udp listener; the listener is started when the address is set
+type StatsDConfig struct {
+	Address       string    `json:"address"`
+	FlushInterval Duration  `json:"flushInterval"`
+	Percentiles   []float64 `json:"percentiles"`
+}
+
+// GraphiteConfig sets up the graphite plaintext tcp listener; the listener is started when the address is set
+type GraphiteConfig struct {
+	Address       string   `json:"address"`
+	FlushInterval Duration `json:"flushInterval"`
+	// Templates map the dotted paths onto metric names and labels, e.g. "servers.* .host.measurement*"
+	Templates []string `json:"templates"`
+}
+
+// Duration is a time.Duration that is written as a string in json, e.g. "15s", "5m" or "30d"
+type Duration time.Duration
+
+// UnmarshalJSON parses the duration from a json string with ParseDuration
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration is expected to be a string, e.g. \"15s\": %w", err)
+	}
+	v, err := ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// durationUnits are the units ParseDuration accepts on top of the ones of time.ParseDuration
+var durationUnits = map[string]time.Duration{
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+	"y": 365 * 24 * time.Hour,
+}
+
+// ParseDuration parses a duration like time.ParseDuration, also accepting whole numbers of days, weeks and years,
+// e.g. 7d, 2w or 1y; the api and the configuration file share it
+func ParseDuration(s string) (time.Duration, error) {
+	for suffix, unit := range durationUnits {
+		if !strings.HasSuffix(s, suffix) {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(s, suffix))
+		if err != nil {
+			break
+		}
+		return time.Duration(n) * unit, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("duration is not valid; expected e.g. 90m, 36h, 7d, 2w or 1y, but received %s", s)
+	}
+	return d, nil
+}
+
+// MarshalJSON writes the duration as a json string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Load reads the configuration file; an empty path returns the default configuration
+func Load(path string) (*Config, error) {
+	cfg := &Config{
+		Writes: WritesConfig{
+			ConflictPolicy: conflict.PolicyAppend,
+			LatePolicy:     late.PolicyAccept,
+			LateTolerance:  Duration(time.Hour),
+		},
+		Validation: ValidationConfig{
+			MaxFutureSkew: Duration(5 * time.Minute),
+			MaxLabels:     32,
+		},
+		Pipeline: PipelineConfig{
+			QueueSize:     100000,
+			BatchSize:     1000,
+			FlushInterval: Duration(time.Second),
+		},
+		Rollups: RollupsConfig{
+			Enabled:  true,
+			Interval: Duration(5 * time.Minute),
+			Lookback: Duration(2 * time.Hour),
+		},
+		Retention: RetentionConfig{
+			Raw:    Duration(87600 * time.Hour),
+			Hourly: Duration(87600 * time.Hour),
+			Daily:  Duration(87600 * time.Hour),
+		},
+		Rules: RulesConfig{
+			Interval: Duration(time.Minute),
+			Delay:    Duration(time.Minute),
+		},
+		Alerting: AlertingConfig{
+			Interval: Duration(time.Minute),
+		},
+		Scrape: ScrapeConfig{
+			Interval: Duration(time.Minute),
+			Timeout:  Duration(10 * time.Second),
+		},
+		StatsD: StatsDConfig{
+			FlushInterval: Duration(10 * time.Second),
+			Percentiles:   []float64{90},
+		},
+		Graphite: GraphiteConfig{
+			FlushInterval: Duration(time.Second),
+		},
+	}
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("config file %s is not valid: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("config file %s is not valid: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (c *Config) validate() error {
+	policy, err := conflict.ParsePolicy(string(c.Writes.ConflictPolicy))
+	if err != nil {
+		return err
+	}
+	c.Writes.ConflictPolicy = policy
+	latePolicy, err := late.ParsePolicy(string(c.Writes.LatePolicy))
+	if err != nil {
+		return err
+	}
+	c.Writes.LatePolicy = latePolicy
+	if c.Writes.LateTolerance < 0 {
+		return fmt.Errorf("late tolerance can't be negative")
+	}
+	if c.Validation.MaxFutureSkew < 0 || c.Validation.MaxLabels <= 0 {
+		return fmt.Errorf("validation max future skew can't be negative and max labels has to be positive")
+	}
+	for name, schema := range c.Validation.Schemas {
+		if schema.Min != nil && schema.Max != nil && *schema.Min > *schema.Max {
+			return fmt.Errorf("schema of %s is not valid; min is larger than max", name)
+		}
+	}
+	if c.Pipeline.QueueSize <= 0 || c.Pipeline.BatchSize <= 0 || c.Pipeline.FlushInterval <= 0 {
+		return fmt.Errorf("pipeline queue size, batch size and flush interval have to be positive")
+	}
+	if c.Rollups.Interval <= 0 || c.Rollups.Lookback < 0 {
+		return fmt.Errorf("rollups interval has to be positive and lookback can't be negative")
+	}
+	if c.Retention.Raw < Duration(time.Second) || c.Retention.Hourly < Duration(time.Second) || c.Retention.Daily < Duration(time.Second) {
+		return fmt.Errorf("retention has to be at least a second")
+	}
+	if c.Retention.Hourly > Duration(math.MaxInt32*time.Second) || c.Retention.Daily > Duration(math.MaxInt32*time.Second) {
+		return fmt.Errorf("retention of the rollups can be at most %d seconds", math.MaxInt32)
+	}
+	if c.Rules.Interval <= 0 || c.Rules.Delay < 0 {
+		return fmt.Errorf("rules interval has to be positive and delay can't be negative")
+	}
+	for _, rule := range c.Rules.Recording {
+		if rule.Name == "" || rule.Step <= 0 {
+			return fmt.Errorf("recording rule %q needs a name and a positive step", rule.Name)
+		}
+		if metricType, err := model.ParseMetricType(rule.Metric); err != nil || metricType == model.MetricTypeNone {
+			return fmt.Errorf("metric of recording rule %s is not valid; expected cpu_load or concurrency, but received %q", rule.Name, rule.Metric)
+		}
+	}
+	if c.Alerting.Interval <= 0 {
+		return fmt.Errorf("alerting interval has to be positive")
+	}
+	for _, webhook := range c.Alerting.Webhooks {
+		if webhook.URL == "" {
+			return fmt.Errorf("alerting webhook url is missing")
+		}
+	}
+	for _, rule := range c.Alerting.Rules {
+		if rule.Name == "" || rule.Window <= 0 || rule.For < 0 {
+			return fmt.Errorf("alert rule %q needs a name, a positive window and a for duration that is not negative", rule.Name)
+		}
+		if metricType, err := model.ParseMetricType(rule.Metric); err != nil || metricType == model.MetricTypeNone {
+			return fmt.Errorf("metric of alert rule %s is not valid; expected cpu_load or concurrency, but received %q", rule.Name, rule.Metric)
+		}
+	}
+	if c.Scrape.Interval <= 0 || c.Scrape.Timeout <= 0 {
+		return fmt.Errorf("scrape interval and timeout have to be positive")
+	}
+	for _, target := range c.Scrape.Targets {
+		if target.URL == "" {
+			return fmt.Errorf("scrape target url is missing")
+		}
+	}
+	if c.StatsD.FlushInterval <= 0 {
+		return fmt.Errorf("statsd flush interval has to be positive")
+	}
+	if c.Graphite.FlushInterval <= 0 {
+		return fmt.Errorf("graphite flush interval has to be positive")
+	}
+	for _, p := range c.StatsD.Percentiles {
+		if p <= 0 || p > 100 {
+			return fmt.Errorf("statsd percentile %v is not valid; expected to be in (0, 100]", p)
+		}
+	}
+	return nil
+}
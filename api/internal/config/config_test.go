@@ -0,0 +1,25 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuration(t *testing.T) {
+	var retention RetentionConfig
+	assert.Nil(t, json.Unmarshal([]byte(`{"raw": "30d", "hourly": "1y", "daily": "10y"}`), &retention))
+	assert.Equal(t, RetentionConfig{
+		Raw:    Duration(30 * 24 * time.Hour),
+		Hourly: Duration(365 * 24 * time.Hour),
+		Daily:  Duration(3650 * 24 * time.Hour),
+	}, retention)
+
+	var d Duration
+	assert.Nil(t, json.Unmarshal([]byte(`"90m"`), &d))
+	assert.Equal(t, Duration(90*time.Minute), d)
+	assert.NotNil(t, json.Unmarshal([]byte(`"1.5d"`), &d))
+	assert.NotNil(t, json.Unmarshal([]byte(`30`), &d))
+}
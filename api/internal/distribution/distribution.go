@@ -0,0 +1,173 @@
+package distribution
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"sky/api/internal/model"
+)
+
+// ErrIncompatible is returned for merging distributions with different buckets
+var ErrIncompatible = errors.New("distributions have different buckets")
+
+// Validate checks that the buckets of the distribution are consistent with its count and sum
+func Validate(d model.Distribution) error {
+	for i, b := range d.Bounds {
+		if math.IsNaN(b) || math.IsInf(b, 0) {
+			return fmt.Errorf("boundary %v is not a finite number", b)
+		}
+		if i > 0 && b <= d.Bounds[i-1] {
+			return errors.New("boundaries have to be increasing")
+		}
+	}
+	if len(d.Counts) != len(d.Bounds)+1 {
+		return fmt.Errorf("has %d bucket counts, but %d boundaries make %d buckets", len(d.Counts), len(d.Bounds), len(d.Bounds)+1)
+	}
+	var count int64
+	for _, c := range d.Counts {
+		if c < 0 {
+			return fmt.Errorf("bucket count %d is negative", c)
+		}
+		count += c
+	}
+	if count != d.Count {
+		return fmt.Errorf("count %d is not the %d values of the buckets", d.Count, count)
+	}
+	if math.IsNaN(d.Sum) || math.IsInf(d.Sum, 0) {
+		return fmt.Errorf("sum %v is not a finite number", d.Sum)
+	}
+	return nil
+}
+
+// Merge adds the values of d to the distribution; both have to have the same boundaries
+func Merge(into *model.Distribution, d model.Distribution) error {
+	if len(into.Bounds) != len(d.Bounds) || len(into.Counts) != len(d.Counts) {
+		return fmt.Errorf("%w: %s", ErrIncompatible, d.SeriesID())
+	}
+	for i, b := range d.Bounds {
+		if into.Bounds[i] != b {
+			return fmt.Errorf("%w: %s", ErrIncompatible, d.SeriesID())
+		}
+	}
+	for i, c := range d.Counts {
+		into.Counts[i] += c
+	}
+	into.Sum += d.Sum
+	into.Count += d.Count
+	return nil
+}
+
+// Aggregate merges the distributions of each series within each step, so e.g. the distributions of a minute are
+// summed up into the distribution of the hour; the timestamp of a merged distribution is the start of its step.
+// A zero step merges all the distributions of a series, at the timestamp of the earliest one.
+// The result is ordered by time, then by series.
+func Aggregate(dists []model.Distribution, step time.Duration) ([]model.Distribution, error) {
+	type key struct {
+		series    string
+		timestamp time.Time
+	}
+	sorted := append([]model.Distribution(nil), dists...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Timestamp.Before(sorted[j].Timestamp) })
+
+	merged := make(map[key]*model.Distribution)
+	var keys []key
+	for _, d := range sorted {
+		k := key{series: d.SeriesID()}
+		if step > 0 {
+			k.timestamp = d.Timestamp.Truncate(step)
+		}
+		m, ok := merged[k]
+		if !ok {
+			m = &model.Distribution{
+				Timestamp: d.Timestamp,
+				Name:      d.Name,
+				Labels:    d.Labels,
+				Bounds:    d.Bounds,
+				Counts:    make([]int64, len(d.Counts)),
+			}
+			if step > 0 {
+				m.Timestamp = k.timestamp
+			}
+			merged[k] = m
+			keys = append(keys, k)
+		}
+		if err := Merge(m, d); err != nil {
+			return nil, err
+		}
+	}
+
+	result := make([]model.Distribution, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, *merged[k])
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if !result[i].Timestamp.Equal(result[j].Timestamp) {
+			return result[i].Timestamp.Before(result[j].Timestamp)
+		}
+		return result[i].SeriesID() < result[j].SeriesID()
+	})
+	return result, nil
+}
+
+// Quantile estimates the q-quantile of the distribution by interpolating linearly within the bucket it falls into;
+// the first bucket starts at 0 if its boundary is positive, and the quantiles above the last boundary are the last
+// boundary. It returns NaN for a distribution without values or without boundaries.
+func Quantile(d model.Distribution, q float64) float64 {
+	if d.Count == 0 || len(d.Bounds) == 0 {
+		return math.NaN()
+	}
+	rank := q * float64(d.Count)
+	var cumulative int64
+	for i, c := range d.Counts {
+		if c == 0 || float64(cumulative+c) < rank {
+			cumulative += c
+			continue
+		}
+		if i == len(d.Bounds) {
+			return d.Bounds[i-1]
+		}
+		upper := d.Bounds[i]
+		lower := 0.0
+		switch {
+		case i > 0:
+			lower = d.Bounds[i-1]
+		case upper <= 0:
+			return upper
+		}
+		return lower + (upper-lower)*(rank-float64(cumulative))/float64(c)
+	}
+	return d.Bounds[len(d.Bounds)-1]
+}
+
+// Summary is a distribution with its mean and quantiles
+type Summary struct {
+	model.Distribution
+	// Mean is missing for a distribution without values
+	Mean *float64 `json:"mean,omitempty"`
+	// Quantiles are the estimated quantiles by their probability, e.g. "0.99"
+	Quantiles map[string]float64 `json:"quantiles,omitempty"`
+}
+
+// Summarize returns the distribution with its mean and the quantiles of the probabilities that can be estimated
+func Summarize(d model.Distribution, quantiles []float64) Summary {
+	s := Summary{Distribution: d}
+	if d.Count > 0 {
+		mean := d.Sum / float64(d.Count)
+		s.Mean = &mean
+	}
+	for _, q := range quantiles {
+		v := Quantile(d, q)
+		if math.IsNaN(v) {
+			continue
+		}
+		if s.Quantiles == nil {
+			s.Quantiles = make(map[string]float64, len(quantiles))
+		}
+		s.Quantiles[strconv.FormatFloat(q, 'g', -1, 64)] = v
+	}
+	return s
+}
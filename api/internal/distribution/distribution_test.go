@@ -0,0 +1,105 @@
+package distribution
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sky/api/internal/model"
+)
+
+func latency(minute int, route string, counts []int64, sum float64) model.Distribution {
+	var count int64
+	for _, c := range counts {
+		count += c
+	}
+	return model.Distribution{
+		Timestamp: time.Date(2022, 4, 25, 10, minute, 0, 0, time.UTC),
+		Name:      "latency",
+		Labels:    map[string]string{"route": route},
+		Bounds:    []float64{0.1, 0.5, 1},
+		Counts:    counts,
+		Sum:       sum,
+		Count:     count,
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	dists := []model.Distribution{
+		latency(30, "/cart", []int64{4, 0, 0, 0}, 0.2),
+		latency(0, "/", []int64{10, 5, 1, 0}, 3.5),
+		latency(15, "/", []int64{0, 5, 3, 2}, 9),
+	}
+
+	hourly, err := Aggregate(dists, time.Hour)
+	assert.Nil(t, err)
+	if assert.Len(t, hourly, 2) {
+		assert.Equal(t, time.Date(2022, 4, 25, 10, 0, 0, 0, time.UTC), hourly[0].Timestamp)
+		assert.Equal(t, "latency{route=\"/\"}", hourly[0].SeriesID())
+		assert.Equal(t, []int64{10, 10, 4, 2}, hourly[0].Counts)
+		assert.Equal(t, 12.5, hourly[0].Sum)
+		assert.Equal(t, int64(26), hourly[0].Count)
+		assert.Equal(t, []int64{4, 0, 0, 0}, hourly[1].Counts)
+	}
+	// the merged distributions are copies
+	assert.Equal(t, []int64{10, 5, 1, 0}, dists[1].Counts)
+
+	minutely, err := Aggregate(dists, time.Minute)
+	assert.Nil(t, err)
+	assert.Len(t, minutely, 3)
+
+	whole, err := Aggregate(dists, 0)
+	assert.Nil(t, err)
+	if assert.Len(t, whole, 2) {
+		assert.Equal(t, dists[1].Timestamp, whole[0].Timestamp)
+	}
+
+	other := latency(45, "/", []int64{1, 0, 0}, 0.05)
+	other.Bounds = []float64{0.1, 1}
+	_, err = Aggregate(append(dists, other), time.Hour)
+	assert.True(t, errors.Is(err, ErrIncompatible))
+}
+
+func TestQuantile(t *testing.T) {
+	d := latency(0, "/", []int64{10, 5, 5, 0}, 6)
+	assert.InDelta(t, 0.05, Quantile(d, 0.25), 1e-9)
+	assert.InDelta(t, 0.1, Quantile(d, 0.5), 1e-9)
+	assert.InDelta(t, 0.75, Quantile(d, 0.875), 1e-9)
+	assert.InDelta(t, 1, Quantile(d, 1), 1e-9)
+
+	// the values above the last boundary
+	d = latency(0, "/", []int64{0, 0, 1, 1}, 3)
+	assert.Equal(t, 1.0, Quantile(d, 0.99))
+
+	assert.True(t, math.IsNaN(Quantile(latency(0, "/", []int64{0, 0, 0, 0}, 0), 0.5)))
+}
+
+func TestSummarize(t *testing.T) {
+	s := Summarize(latency(0, "/", []int64{10, 5, 5, 0}, 6), []float64{0.5, 0.875})
+	assert.Equal(t, 0.3, *s.Mean)
+	assert.InDelta(t, 0.1, s.Quantiles["0.5"], 1e-9)
+	assert.InDelta(t, 0.75, s.Quantiles["0.875"], 1e-9)
+
+	s = Summarize(latency(0, "/", []int64{0, 0, 0, 0}, 0), []float64{0.5})
+	assert.Nil(t, s.Mean)
+	assert.Nil(t, s.Quantiles)
+}
+
+func TestValidate(t *testing.T) {
+	assert.Nil(t, Validate(latency(0, "/", []int64{1, 2, 3, 4}, 12)))
+	assert.Nil(t, Validate(model.Distribution{Counts: []int64{3}, Count: 3, Sum: 1}))
+
+	invalid := []model.Distribution{
+		{Bounds: []float64{1, 1}, Counts: []int64{0, 0, 0}},
+		{Bounds: []float64{math.Inf(1)}, Counts: []int64{0, 0}},
+		{Bounds: []float64{1}, Counts: []int64{1}, Count: 1},
+		{Bounds: []float64{1}, Counts: []int64{1, -1}},
+		{Bounds: []float64{1}, Counts: []int64{1, 1}, Count: 3},
+		{Bounds: []float64{1}, Counts: []int64{1, 1}, Count: 2, Sum: math.NaN()},
+	}
+	for _, d := range invalid {
+		assert.NotNil(t, Validate(d), "%+v", d)
+	}
+}
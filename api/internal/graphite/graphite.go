@@ -0,0 +1,186 @@
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sky/api/internal/config"
+	"sky/api/internal/model"
+)
+
+// Writer is an interface for saving the received samples
+type Writer interface {
+	InsertSamples(ctx context.Context, samples []model.Sample) error
+}
+
+// Listener receives metrics in the graphite plaintext protocol over tcp and saves them every flush interval
+type Listener struct {
+	writer        Writer
+	listener      net.Listener
+	flushInterval time.Duration
+	templates     templates
+
+	mu      sync.Mutex
+	pending []model.Sample
+}
+
+// NewListener opens the tcp socket of the configured address
+func NewListener(writer Writer, cfg config.GraphiteConfig) (*Listener, error) {
+	ts, err := parseTemplates(cfg.Templates)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{
+		writer:        writer,
+		listener:      listener,
+		flushInterval: time.Duration(cfg.FlushInterval),
+		templates:     ts,
+	}, nil
+}
+
+// Addr returns the address the listener receives the metrics on
+func (l *Listener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// Run accepts connections and flushes the received samples until the context is cancelled;
+// the pending samples are flushed before returning
+func (l *Listener) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		l.accept(ctx)
+	}()
+
+	ticker := time.NewTicker(l.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			l.listener.Close()
+			wg.Wait()
+			// the context of the caller is done, the last flush gets a context of its own
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			l.flush(flushCtx)
+			cancel()
+			return
+		case <-ticker.C:
+			l.flush(ctx)
+		}
+	}
+}
+
+func (l *Listener) accept(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := l.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("graphite: failed to accept connection: %s", err.Error())
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.handle(ctx, conn)
+		}()
+	}
+}
+
+// handle reads the lines of a connection until the client closes it or the listener shuts down
+func (l *Listener) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		sample, err := l.parseLine(line, time.Now())
+		if err != nil {
+			log.Printf("graphite: line %q is not valid: %s", line, err.Error())
+			continue
+		}
+		l.mu.Lock()
+		l.pending = append(l.pending, sample)
+		l.mu.Unlock()
+	}
+}
+
+// parseLine parses a line of the form: <path> <value> <timestamp>; a missing or -1 timestamp means now
+func (l *Listener) parseLine(line string, now time.Time) (model.Sample, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return model.Sample{}, fmt.Errorf("expected \"path value timestamp\"")
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return model.Sample{}, fmt.Errorf("value is not valid: %w", err)
+	}
+
+	timestamp := now
+	if len(fields) == 3 && fields[2] != "-1" {
+		epoch, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return model.Sample{}, fmt.Errorf("timestamp is not valid: %w", err)
+		}
+		timestamp = time.Unix(0, int64(epoch*float64(time.Second)))
+	}
+
+	name, labels := l.templates.apply(fields[0])
+	return model.Sample{
+		Timestamp: timestamp,
+		Name:      name,
+		Type:      model.SampleTypeGauge,
+		Labels:    labels,
+		Value:     value,
+	}, nil
+}
+
+func (l *Listener) flush(ctx context.Context) {
+	l.mu.Lock()
+	samples := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+
+	if len(samples) == 0 {
+		return
+	}
+	err := l.writer.InsertSamples(ctx, samples)
+	var rejected *model.RejectedError
+	switch {
+	case errors.As(err, &rejected):
+		log.Printf("graphite: saved %d samples, %s", len(samples)-rejected.Rejected, err.Error())
+	case err != nil:
+		log.Printf("graphite: failed to save %d samples: %s", len(samples), err.Error())
+	}
+}
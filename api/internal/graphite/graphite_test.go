@@ -0,0 +1,103 @@
+package graphite
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sky/api/internal/config"
+	"sky/api/internal/model"
+)
+
+func TestTemplates(t *testing.T) {
+	ts, err := parseTemplates([]string{
+		"servers.* .host.measurement* env=prod",
+		"stats.*.* .region.app.measurement.measurement",
+		"apps.* .service.service.measurement*",
+	})
+	assert.Nil(t, err)
+
+	cases := []struct {
+		path           string
+		expectedName   string
+		expectedLabels map[string]string
+	}{
+		{"servers.web01.cpu.load", "cpu.load", map[string]string{"host": "web01", "env": "prod"}},
+		{"stats.eu.shop.requests.count", "requests.count", map[string]string{"region": "eu", "app": "shop"}},
+		{"apps.billing.api.latency", "latency", map[string]string{"service": "billing.api"}},
+		{"unmatched.path", "unmatched.path", nil},
+	}
+	for _, c := range cases {
+		name, labels := ts.apply(c.path)
+		assert.Equal(t, c.expectedName, name, c.path)
+		assert.Equal(t, c.expectedLabels, labels, c.path)
+	}
+}
+
+func TestInvalidTemplates(t *testing.T) {
+	for _, spec := range []string{
+		".host.name",
+		"measurement*.host",
+		"a b c d",
+	} {
+		_, err := parseTemplate(spec)
+		assert.NotNil(t, err, spec)
+	}
+}
+
+func TestListener(t *testing.T) {
+	writer := &mockWriter{}
+	l, err := NewListener(writer, config.GraphiteConfig{
+		Address:       "127.0.0.1:0",
+		FlushInterval: config.Duration(time.Hour),
+		Templates:     []string{"servers.* .host.measurement*"},
+	})
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		l.Run(ctx)
+		close(done)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	fmt.Fprintf(conn, "servers.web01.cpu.load 42.5 1650843741\nbroken line\nservers.web02.cpu.load 12 -1\n")
+	conn.Close()
+
+	// shutting down flushes the pending samples
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	<-done
+
+	samples := writer.get()
+	assert.Equal(t, 2, len(samples))
+	assert.Equal(t, "cpu.load", samples[0].Name)
+	assert.Equal(t, "web01", samples[0].Labels["host"])
+	assert.Equal(t, 42.5, samples[0].Value)
+	assert.Equal(t, time.Unix(1650843741, 0), samples[0].Timestamp)
+	assert.Equal(t, "web02", samples[1].Labels["host"])
+}
+
+type mockWriter struct {
+	mu      sync.Mutex
+	samples []model.Sample
+}
+
+func (m *mockWriter) InsertSamples(ctx context.Context, samples []model.Sample) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.samples = append(m.samples, samples...)
+	return nil
+}
+
+func (m *mockWriter) get() []model.Sample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.samples
+}
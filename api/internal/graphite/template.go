@@ -0,0 +1,142 @@
+package graphite
+
+import (
+	"fmt"
+	"strings"
+)
+
+// template maps the dotted graphite path onto a metric name and labels; it is written as
+// "[filter] template [label=value,...]", e.g. "servers.* .host.measurement* env=prod":
+// * the filter matches the path by segments, where * matches any segment; without a filter every path matches
+// * the template names each segment: "measurement" segments are joined into the name, "measurement*" takes all
+//   the remaining segments into the name, empty segments are dropped and any other word is a label
+// * the labels are added to all the samples matching the template
+type template struct {
+	filter []string
+	parts  []string
+	labels map[string]string
+}
+
+func parseTemplate(s string) (*template, error) {
+	fields := strings.Fields(s)
+	t := &template{}
+	switch len(fields) {
+	case 1:
+		t.parts = strings.Split(fields[0], ".")
+	case 2:
+		if strings.Contains(fields[1], "=") {
+			t.parts = strings.Split(fields[0], ".")
+			t.labels = parseLabels(fields[1])
+		} else {
+			t.filter = strings.Split(fields[0], ".")
+			t.parts = strings.Split(fields[1], ".")
+		}
+	case 3:
+		t.filter = strings.Split(fields[0], ".")
+		t.parts = strings.Split(fields[1], ".")
+		t.labels = parseLabels(fields[2])
+	default:
+		return nil, fmt.Errorf("template %q is not valid; expected \"[filter] template [label=value,...]\"", s)
+	}
+
+	hasMeasurement := false
+	for i, part := range t.parts {
+		if part == "measurement*" && i != len(t.parts)-1 {
+			return nil, fmt.Errorf("template %q is not valid; measurement* has to be the last segment", s)
+		}
+		if part == "measurement" || part == "measurement*" {
+			hasMeasurement = true
+		}
+	}
+	if !hasMeasurement {
+		return nil, fmt.Errorf("template %q is not valid; it has no measurement segment", s)
+	}
+	return t, nil
+}
+
+func parseLabels(s string) map[string]string {
+	labels := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			labels[parts[0]] = parts[1]
+		}
+	}
+	return labels
+}
+
+func (t *template) matches(segments []string) bool {
+	if t.filter == nil {
+		return true
+	}
+	if len(segments) < len(t.filter) {
+		return false
+	}
+	for i, f := range t.filter {
+		if f != "*" && f != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// apply returns the metric name and the labels of the path segments
+func (t *template) apply(segments []string) (string, map[string]string) {
+	var name []string
+	labels := make(map[string]string)
+	for k, v := range t.labels {
+		labels[k] = v
+	}
+
+	// labels named by several segments are joined with dots
+	seen := make(map[string]bool)
+	for i, part := range t.parts {
+		if i >= len(segments) {
+			break
+		}
+		switch part {
+		case "":
+		case "measurement":
+			name = append(name, segments[i])
+		case "measurement*":
+			name = append(name, segments[i:]...)
+		default:
+			if seen[part] {
+				labels[part] += "." + segments[i]
+			} else {
+				labels[part] = segments[i]
+			}
+			seen[part] = true
+		}
+	}
+	if len(labels) == 0 {
+		labels = nil
+	}
+	return strings.Join(name, "."), labels
+}
+
+// templates picks the first matching template of the configured order; paths matching no template keep the full
+// path as the metric name
+type templates []*template
+
+func parseTemplates(specs []string) (templates, error) {
+	var ts templates
+	for _, spec := range specs {
+		t, err := parseTemplate(spec)
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+func (ts templates) apply(path string) (string, map[string]string) {
+	segments := strings.Split(path, ".")
+	for _, t := range ts {
+		if t.matches(segments) {
+			return t.apply(segments)
+		}
+	}
+	return path, nil
+}
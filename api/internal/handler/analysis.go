@@ -0,0 +1,255 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"sky/api/internal/analysis"
+	"sky/api/internal/config"
+	"sky/api/internal/model"
+)
+
+// GetAnomalies returns the points of the series of the type that are flagged as anomalies, with their scores and
+// expected bands; accepted query parameters, besides the ones of GetTimeline:
+// * method - zscore (default), mad or holtwinters
+// * window - the number of preceding points of the baseline, 30 by default
+// * season - the number of points of a season for holtwinters, e.g. 24 with frequency=hours for daily seasonality
+// * threshold - the score above which a point is flagged, 3 by default
+func (h *Handler) GetAnomalies(w http.ResponseWriter, r *http.Request) {
+	filter := buildQueryFilter(w, r)
+	if filter == nil {
+		return
+	}
+	if filter.MetricType == model.MetricTypeNone {
+		writeError(w, "metric type is required", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	method, err := analysis.ParseMethod(query.Get("method"))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := analysis.AnomalyOptions{Method: method}
+	if opts.Window, err = intParam(query, "window", 30, 2); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Season, err = intParam(query, "season", 0, 0); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if opts.Threshold, err = floatParam(query, "threshold", 3); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	series, err := h.store.GetSeries(r.Context(), *filter)
+	switch {
+	case err != nil:
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	case len(series) == 0:
+		writeError(w, fmt.Sprintf("data for specified filter does not exist; filter: %v", *filter), http.StatusNotFound)
+		return
+	}
+
+	anomalies, err := analysis.DetectAnomalies(analysis.Points(series, filter.MetricType), opts)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jsonResp, _ := json.Marshal(anomalies)
+	writeResponse(w, http.StatusOK, jsonResp)
+}
+
+// maxForecastPoints limits the number of points of a forecast
+const maxForecastPoints = 10000
+
+// seasonalities are the named seasonalities of the forecasts
+var seasonalities = map[string]time.Duration{
+	"daily":  24 * time.Hour,
+	"weekly": 7 * 24 * time.Hour,
+}
+
+// GetForecast fits a model on the series of the type in the time range and returns the predicted values after its end,
+// with their confidence intervals; accepted query parameters, besides the ones of GetTimeline:
+// * horizon - how far the forecast goes after the last point, e.g. 36h or 7d; 24h by default
+// * model - holtwinters (default) or linear
+// * seasonality - daily, weekly or a duration like 12h, for holtwinters; no seasonality by default
+// * confidence - the probability of the values falling within the intervals, 0.95 by default
+// The frequency is the step of the forecast; without a frequency, the step is the typical time between the points.
+func (h *Handler) GetForecast(w http.ResponseWriter, r *http.Request) {
+	filter := buildQueryFilter(w, r)
+	if filter == nil {
+		return
+	}
+	if filter.MetricType == model.MetricTypeNone {
+		writeError(w, "metric type is required", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	forecastModel, err := analysis.ParseForecastModel(query.Get("model"))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	horizon := 24 * time.Hour
+	if s := query.Get("horizon"); s != "" {
+		if horizon, err = config.ParseDuration(s); err != nil || horizon <= 0 {
+			writeError(w, fmt.Sprintf("horizon is not valid; expected a positive duration like 36h or 7d, but received %s", s), http.StatusBadRequest)
+			return
+		}
+	}
+	var seasonality time.Duration
+	if s := query.Get("seasonality"); s != "" {
+		var ok bool
+		if seasonality, ok = seasonalities[s]; !ok {
+			if seasonality, err = config.ParseDuration(s); err != nil || seasonality <= 0 {
+				writeError(w, fmt.Sprintf("seasonality is not valid; expected daily, weekly or a duration like 12h, but received %s", s), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	confidence, err := floatParam(query, "confidence", 0.95)
+	if err != nil || confidence >= 1 {
+		writeError(w, fmt.Sprintf("confidence is not valid; expected a probability like 0.95, but received %s", query.Get("confidence")), http.StatusBadRequest)
+		return
+	}
+
+	var step time.Duration
+	switch filter.Frequency {
+	case model.FrequencyNone:
+	case model.FrequencyBySeconds, model.FrequencyByMinutes:
+		step = time.Minute
+	case model.FrequencyByHours:
+		step = time.Hour
+	case model.FrequencyByDays:
+		step = 24 * time.Hour
+	default:
+		writeError(w, "forecasts need a frequency of a fixed length, up to days", http.StatusBadRequest)
+		return
+	}
+
+	series, err := h.store.GetSeries(r.Context(), *filter)
+	switch {
+	case err != nil:
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	case len(series) == 0:
+		writeError(w, fmt.Sprintf("data for specified filter does not exist; filter: %v", *filter), http.StatusNotFound)
+		return
+	}
+	points := analysis.Points(series, filter.MetricType)
+	if step == 0 {
+		if step = analysis.InferStep(points); step <= 0 {
+			writeError(w, "the series needs points at different times for a forecast", http.StatusBadRequest)
+			return
+		}
+	}
+
+	opts := analysis.ForecastOptions{
+		Model:      forecastModel,
+		Step:       step,
+		Horizon:    int((horizon + step - 1) / step),
+		Season:     int(seasonality / step),
+		Confidence: confidence,
+	}
+	if opts.Horizon > maxForecastPoints {
+		writeError(w, fmt.Sprintf("horizon of %s has more than %d points of %s", horizon, maxForecastPoints, step), http.StatusBadRequest)
+		return
+	}
+	if seasonality > 0 && opts.Season < 2 {
+		writeError(w, fmt.Sprintf("seasonality of %s has to be at least two steps of %s", seasonality, step), http.StatusBadRequest)
+		return
+	}
+
+	forecast, err := analysis.Forecast(points, opts)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jsonResp, _ := json.Marshal(forecast)
+	writeResponse(w, http.StatusOK, jsonResp)
+}
+
+// GetCorrelation aligns the series of the metrics to a common step and returns the correlation of every pair;
+// accepted query parameters, besides the ones of GetTimeline:
+// * metrics - the correlated metric types separated by commas, cpu_load,concurrency by default
+// * step - the step the series are aligned to, e.g. 5m; the typical time between the points by default
+// * maxLag - the number of steps the cross-correlation shifts the series by in both directions, 10 by default
+func (h *Handler) GetCorrelation(w http.ResponseWriter, r *http.Request) {
+	filter := buildQueryFilter(w, r)
+	if filter == nil {
+		return
+	}
+
+	query := r.URL.Query()
+	names := []string{model.MetricTypeCPULoad.String(), model.MetricTypeConcurrency.String()}
+	if s := query.Get("metrics"); s != "" {
+		names = strings.Split(s, ",")
+	}
+	var metricTypes []model.MetricType
+	seen := make(map[model.MetricType]bool)
+	for _, name := range names {
+		metricType, err := model.ParseMetricType(name)
+		if err != nil || metricType == model.MetricTypeNone || seen[metricType] {
+			writeError(w, fmt.Sprintf("metrics are not valid; expected distinct metric types separated by commas, but received %s", query.Get("metrics")), http.StatusBadRequest)
+			return
+		}
+		seen[metricType] = true
+		metricTypes = append(metricTypes, metricType)
+	}
+	if len(metricTypes) < 2 {
+		writeError(w, "at least two metrics are needed for a correlation", http.StatusBadRequest)
+		return
+	}
+
+	var step time.Duration
+	if s := query.Get("step"); s != "" {
+		var err error
+		if step, err = config.ParseDuration(s); err != nil || step <= 0 {
+			writeError(w, fmt.Sprintf("step is not valid; expected a positive duration like 5m, but received %s", s), http.StatusBadRequest)
+			return
+		}
+	}
+	maxLag, err := intParam(query, "maxLag", 10, 0)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	series, err := h.store.GetSeries(r.Context(), *filter)
+	switch {
+	case err != nil:
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	case len(series) == 0:
+		writeError(w, fmt.Sprintf("data for specified filter does not exist; filter: %v", *filter), http.StatusNotFound)
+		return
+	}
+
+	correlated := make([]analysis.Series, 0, len(metricTypes))
+	for _, metricType := range metricTypes {
+		correlated = append(correlated, analysis.Series{Name: metricType.String(), Points: analysis.Points(series, metricType)})
+	}
+	if step == 0 {
+		if step = analysis.InferStep(correlated[0].Points); step <= 0 {
+			writeError(w, "the series needs points at different times for a correlation", http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := analysis.Correlate(correlated, step, maxLag)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jsonResp, _ := json.Marshal(result)
+	writeResponse(w, http.StatusOK, jsonResp)
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sky/api/internal/analysis"
+	"sky/api/internal/config"
+	"sky/api/internal/model"
+)
+
+// parseOffset returns the function shifting a time back by the offset of a comparison, like 1w, 1d or 24h;
+// the months (M) and years (y) are calendar ones, so the buckets of a frequency stay aligned
+func parseOffset(s string) (func(time.Time) time.Time, error) {
+	if len(s) > 1 {
+		if n, err := strconv.Atoi(s[:len(s)-1]); err == nil && n > 0 {
+			switch s[len(s)-1] {
+			case 'y':
+				return func(t time.Time) time.Time { return t.AddDate(-n, 0, 0) }, nil
+			case 'M':
+				return func(t time.Time) time.Time { return t.AddDate(0, -n, 0) }, nil
+			}
+		}
+	}
+	d, err := config.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("compare is not valid; expected a positive offset like 1d, 1w, 1M or 1y, but received %s", s)
+	}
+	return func(t time.Time) time.Time { return t.Add(-d) }, nil
+}
+
+// comparedTypes are the metric types of a comparison: the type of the query, or all of them
+func comparedTypes(filter model.Query) []model.MetricType {
+	if filter.MetricType != model.MetricTypeNone {
+		return []model.MetricType{filter.MetricType}
+	}
+	return []model.MetricType{model.MetricTypeCPULoad, model.MetricTypeConcurrency}
+}
+
+// previousQuery returns the query shifted back by the offset
+func previousQuery(filter model.Query, shift func(time.Time) time.Time) model.Query {
+	previous := filter
+	previous.StartAt = shift(filter.StartAt)
+	previous.EndAt = shift(filter.EndAt)
+	return previous
+}
+
+// compareTimeline writes the series of the query aligned with the series of the range before it by the offset
+func (h *Handler) compareTimeline(w http.ResponseWriter, r *http.Request, filter model.Query, offset string) {
+	shift, err := parseOffset(offset)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	previousFilter := previousQuery(filter, shift)
+
+	current, err := h.store.GetSeries(r.Context(), filter)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	previous, err := h.store.GetSeries(r.Context(), previousFilter)
+	switch {
+	case err != nil:
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	case len(current) == 0:
+		writeError(w, fmt.Sprintf("data for specified filter does not exist; filter: %v", filter), http.StatusNotFound)
+		return
+	}
+
+	jsonResp, _ := json.Marshal(analysis.SeriesComparison{
+		Offset:   offset,
+		Current:  analysis.Range{Start: filter.StartAt, End: filter.EndAt},
+		Previous: analysis.Range{Start: previousFilter.StartAt, End: previousFilter.EndAt},
+		Points:   analysis.CompareSeries(current, previous, comparedTypes(filter), shift),
+	})
+	writeResponse(w, http.StatusOK, jsonResp)
+}
+
+// compareAverage writes the averages of the query and of the range before it by the offset
+func (h *Handler) compareAverage(w http.ResponseWriter, r *http.Request, filter model.Query, offset string) {
+	shift, err := parseOffset(offset)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	current, err := h.store.GetAverage(r.Context(), filter)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	previous, err := h.store.GetAverage(r.Context(), previousQuery(filter, shift))
+	switch {
+	case err != nil:
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	case current == nil:
+		writeError(w, fmt.Sprintf("data for specified filter does not exist; filter: %v", filter), http.StatusNotFound)
+		return
+	}
+
+	jsonResp, _ := json.Marshal(analysis.AverageComparison{
+		Offset:   offset,
+		Current:  current,
+		Previous: previous,
+		Values:   analysis.CompareAverages(current, previous, comparedTypes(filter)),
+	})
+	writeResponse(w, http.StatusOK, jsonResp)
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sky/api/internal/config"
+	"sky/api/internal/distribution"
+	"sky/api/internal/model"
+	"sky/api/internal/validation"
+
+	"github.com/gorilla/mux"
+)
+
+// DistributionStore is an interface for saving and reading the distributions, e.g. of the request latencies
+type DistributionStore interface {
+	InsertDistributions(ctx context.Context, dists []model.Distribution) error
+	GetDistributions(ctx context.Context, query model.SampleQuery) ([]model.Distribution, error)
+}
+
+// DistributionsHandler is responsible for the API requests for writing and returning the distributions
+type DistributionsHandler struct {
+	store     DistributionStore
+	validator *validation.Validator
+}
+
+// defaultQuantiles are the quantiles of the returned distributions, unless the query sets them
+var defaultQuantiles = []float64{0.5, 0.9, 0.99}
+
+// NewDistributionsHandler creates a handler with a storage for the distributions and a validator for the written ones
+func NewDistributionsHandler(store DistributionStore, validator *validation.Validator) *DistributionsHandler {
+	return &DistributionsHandler{store: store, validator: validator}
+}
+
+// PostDistributions saves the distributions sent as a json array in the request body; a batch with an invalid
+// distribution is rejected
+func (h *DistributionsHandler) PostDistributions(w http.ResponseWriter, r *http.Request) {
+	var dists []model.Distribution
+	if err := json.NewDecoder(r.Body).Decode(&dists); err != nil {
+		writeError(w, fmt.Sprintf("request body is not valid; expected a json array of distributions: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	if len(dists) == 0 {
+		writeError(w, "no distributions were sent", http.StatusBadRequest)
+		return
+	}
+	if _, err := h.validator.ValidDistributions(dists); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.store.InsertDistributions(r.Context(), dists); err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonResp, _ := json.Marshal(map[string]int{"accepted": len(dists)})
+	writeResponse(w, http.StatusOK, jsonResp)
+}
+
+// GetDistributions returns the distributions of the name in the url, in the start-end range, merged by series
+// within each step, with their means and quantiles; accepted query parameters, besides start and end:
+// * label - filters the distributions by a label, written as name=value
+// * step - the step the distributions are merged within, e.g. 1h; by default they are merged over the whole range
+// * quantiles - the estimated quantiles separated by commas, 0.5,0.9,0.99 by default
+func (h *DistributionsHandler) GetDistributions(w http.ResponseWriter, r *http.Request) {
+	filter := buildQueryFilter(w, r)
+	if filter == nil {
+		return
+	}
+
+	params := r.URL.Query()
+	labels, err := labelParams(params)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var step time.Duration
+	if s := params.Get("step"); s != "" {
+		if step, err = config.ParseDuration(s); err != nil || step <= 0 {
+			writeError(w, fmt.Sprintf("step is not valid; expected a positive duration like 1h, but received %s", s), http.StatusBadRequest)
+			return
+		}
+	}
+	quantiles := defaultQuantiles
+	if s := params.Get("quantiles"); s != "" {
+		quantiles = nil
+		for _, q := range strings.Split(s, ",") {
+			v, err := strconv.ParseFloat(q, 64)
+			if err != nil || v < 0 || v > 1 {
+				writeError(w, fmt.Sprintf("quantiles are not valid; expected probabilities separated by commas, but received %s", s), http.StatusBadRequest)
+				return
+			}
+			quantiles = append(quantiles, v)
+		}
+	}
+
+	query := model.SampleQuery{
+		Name:    mux.Vars(r)["name"],
+		StartAt: filter.StartAt,
+		EndAt:   filter.EndAt,
+		Labels:  labels,
+	}
+	dists, err := h.store.GetDistributions(r.Context(), query)
+	switch {
+	case err != nil:
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	case len(dists) == 0:
+		writeError(w, fmt.Sprintf("distributions of %s do not exist in the given time range", query.Name), http.StatusNotFound)
+		return
+	}
+
+	merged, err := distribution.Aggregate(dists, step)
+	switch {
+	case errors.Is(err, distribution.ErrIncompatible):
+		writeError(w, fmt.Sprintf("%s; query a range or a series where the buckets didn't change", err.Error()), http.StatusConflict)
+		return
+	case err != nil:
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	summaries := make([]distribution.Summary, 0, len(merged))
+	for _, d := range merged {
+		summaries = append(summaries, distribution.Summarize(d, quantiles))
+	}
+	jsonResp, _ := json.Marshal(summaries)
+	writeResponse(w, http.StatusOK, jsonResp)
+}
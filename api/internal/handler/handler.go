@@ -3,51 +3,80 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
 	"time"
 
 	"sky/api/internal/model"
+	"sky/api/internal/pipeline"
+	"sky/api/internal/storage/conflict"
+	"sky/api/internal/storage/late"
+	"sky/api/internal/validation"
 
 	"github.com/gorilla/mux"
 )
 
 // Handler is responsible for handling the API requests for returning the metrics
 type Handler struct {
-	store Store
+	store       Store
+	writer      Writer
+	validator   *validation.Validator
+	idempotency *idempotencyCache
 }
 
 // Store is an interface representing any timestories storage for metrics
 type Store interface {
 	GetSeries(ctx context.Context, filter model.Query) ([]model.Metric, error)
 	GetAverage(ctx context.Context, filter model.Query) (*model.MetricAverage, error)
+	GetHistogram(ctx context.Context, filter model.Query, spec model.HistogramSpec) (*model.Histogram, error)
+	GetTop(ctx context.Context, filter model.Query, k int, ascending bool) ([]model.Metric, error)
+	// CheckMetrics returns a *model.RejectedError for the written metrics that the write policies of the store
+	// would reject, so the write can be refused before it is queued
+	CheckMetrics(ctx context.Context, metrics []model.Metric) error
 }
 
-// NewMetricsHandler creates a handler with a storage
-func NewMetricsHandler(store Store) *Handler {
+// Writer is an interface for the write path of the metrics; it returns pipeline.ErrQueueFull when it can't keep up,
+// and pipeline.ErrTooLarge for more metrics than it can queue
+type Writer interface {
+	Write(metrics []model.Metric) error
+}
+
+// NewMetricsHandler creates a handler with a storage for the queries, and a writer and a validator for the written metrics
+func NewMetricsHandler(store Store, writer Writer, validator *validation.Validator) *Handler {
 	return &Handler{
-		store: store,
+		store:       store,
+		writer:      writer,
+		validator:   validator,
+		idempotency: newIdempotencyCache(),
 	}
 }
 
 // GetTimeline should return a series of metrics for the given url
 // type: can be cpu_load and concurrency;
 // accepted query parameters:
-// * start, end - being epoch time
+// * start, end - epoch time in seconds, milliseconds or nanoseconds, RFC3339, or relative like now-24h or now/d;
+// end is now by default
+// * period - instead of start and end, a calendar period like today, last_month or 2022-04
+// * tz - the timezone of the periods and the relative times, UTC by default
 // * frequency - possible values being "minutes", "hours", "days"
+// * compare - an offset like 1d, 1w or 1y; the series is returned aligned with the series of the range before it
 func (h *Handler) GetTimeline(w http.ResponseWriter, r *http.Request) {
 	filter := buildQueryFilter(w, r)
 	if filter == nil {
 		return
 	}
+	if offset := r.URL.Query().Get("compare"); offset != "" {
+		h.compareTimeline(w, r, *filter, offset)
+		return
+	}
 	series, err := h.store.GetSeries(context.Background(), *filter)
 	switch {
 	case err != nil:
 		writeError(w, err.Error(), http.StatusInternalServerError)
 		return
 	case len(series) == 0:
-		writeError(w, fmt.Sprintf("data for specified filter does not exist; filter: %v", *filter), http.StatusNotFound)
+		writeError(w, fmt.Sprintf("data for specified filter does not exist; filter: %v; GET /series lists the time ranges of the stored data", *filter), http.StatusNotFound)
 		return
 	default:
 		jsonResp, err := json.Marshal(series)
@@ -61,12 +90,17 @@ func (h *Handler) GetTimeline(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// GetAverage should return the stats for the given http params/filters
+// GetAverage should return the stats for the given http params/filters;
+// with compare, like GetTimeline, the averages are returned together with the ones of the range before it
 func (h *Handler) GetAverage(w http.ResponseWriter, r *http.Request) {
 	filter := buildQueryFilter(w, r)
 	if filter == nil {
 		return
 	}
+	if offset := r.URL.Query().Get("compare"); offset != "" {
+		h.compareAverage(w, r, *filter, offset)
+		return
+	}
 
 	data, err := h.store.GetAverage(context.Background(), *filter)
 	switch {
@@ -88,79 +122,130 @@ func (h *Handler) GetAverage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// PostMetrics queues the metrics sent as a json array in the request body for saving;
+// while the write queue is full, the request is rejected with 429 and can be retried. With the reject conflict
+// policy, a write of duplicated metrics is refused with 409 before it is queued, and with the reject late policy a
+// write of late metrics with 400.
+// A request with an Idempotency-Key header is only queued once; repeating it returns the first response.
+func (h *Handler) PostMetrics(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		status, body := h.writeMetrics(r)
+		writeResponse(w, status, body)
+		return
+	}
+
+	stored, ok := h.idempotency.reserve(key, time.Now())
+	switch {
+	case !ok:
+		writeError(w, "a request with the same idempotency key is being processed", http.StatusConflict)
+		return
+	case stored != nil:
+		w.Header().Set("Idempotency-Replayed", "true")
+		writeResponse(w, stored.status, stored.body)
+		return
+	}
+
+	status, body := h.writeMetrics(r)
+	if status == http.StatusTooManyRequests || status >= http.StatusInternalServerError {
+		h.idempotency.release(key)
+	} else {
+		h.idempotency.complete(key, status, body)
+	}
+	writeResponse(w, status, body)
+}
+
+// writeMetrics decodes and queues the metrics, returning the status and the json body of the response
+func (h *Handler) writeMetrics(r *http.Request) (int, []byte) {
+	metrics, err := validation.DecodeMetrics(r.Body)
+	switch {
+	case errors.Is(err, validation.ErrInvalid):
+		return errorResponse(err.Error(), http.StatusBadRequest)
+	case err != nil:
+		return errorResponse(fmt.Sprintf("request body is not valid; expected a json array of metrics: %s", err.Error()), http.StatusBadRequest)
+	case len(metrics) == 0:
+		return errorResponse("no metrics were sent", http.StatusBadRequest)
+	}
+	if err := h.validator.ValidateMetrics(metrics); err != nil {
+		return errorResponse(err.Error(), http.StatusBadRequest)
+	}
+	err = h.store.CheckMetrics(r.Context(), metrics)
+	switch {
+	case errors.Is(err, conflict.ErrDuplicate):
+		return errorResponse(err.Error(), http.StatusConflict)
+	case errors.Is(err, late.ErrLate):
+		return errorResponse(err.Error(), http.StatusBadRequest)
+	case err != nil:
+		return errorResponse(err.Error(), http.StatusInternalServerError)
+	}
+
+	err = h.writer.Write(metrics)
+	switch {
+	case errors.Is(err, pipeline.ErrQueueFull):
+		return errorResponse(err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, pipeline.ErrTooLarge):
+		// a retry can't succeed, unlike with a full queue
+		return errorResponse(err.Error(), http.StatusRequestEntityTooLarge)
+	case err != nil:
+		return errorResponse(err.Error(), http.StatusInternalServerError)
+	}
+
+	jsonResp, _ := json.Marshal(map[string]int{"accepted": len(metrics)})
+	return http.StatusAccepted, jsonResp
+}
+
 func buildQueryFilter(w http.ResponseWriter, r *http.Request) *model.Query {
 	// time range
 	query := r.URL.Query()
-	start := query.Get("start")
-	end := query.Get("end")
-	if start == "" || end == "" {
-		writeError(w, "timerange wasn't specified", http.StatusBadRequest)
-		return nil
-	}
-
-	var err error
-	var startInt, endInt int
-	startInt, err = strconv.Atoi(start)
+	tr, err := parseTimeRange(query, time.Now())
 	if err != nil {
-		writeError(w, fmt.Sprintf("start timestamp is not valid; expected to be epoch format, but received %s", start), http.StatusBadRequest)
+		writeError(w, err.Error(), http.StatusBadRequest)
 		return nil
 	}
-	endInt, err = strconv.Atoi(end)
-	if err != nil {
-		writeError(w, fmt.Sprintf("end timestamp is not valid; expected to be epoch format, but received %s", end), http.StatusBadRequest)
-		return nil
+	if tr.period {
+		w.Header().Set("Period-Start", tr.start.Format(time.RFC3339))
+		w.Header().Set("Period-End", tr.next.Format(time.RFC3339))
 	}
 
 	// frequency
-	var frequency model.Frequency
-	switch query.Get("frequency") {
-	case "seconds":
-		frequency = model.FrequencyBySeconds
-	case "minutes":
-		frequency = model.FrequencyByMinutes
-	case "hours":
-		frequency = model.FrequencyByHours
-	case "days":
-		frequency = model.FrequencyByDays
-	case "months":
-		frequency = model.FrequencyByMonths
-	case "years":
-		frequency = model.FrequencyByYears
-	case "":
-		frequency = model.FrequencyNone
-	default:
-		writeError(w, fmt.Sprintf("frequency value is not valid; received %s", query.Get("frequency")), http.StatusBadRequest)
+	frequency, err := model.ParseFrequency(query.Get("frequency"))
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
 		return nil
 	}
 
 	// type
-	vars := mux.Vars(r)
-	var mType model.MetricType
-	switch vars["type"] {
-	case "cpu_load":
-		mType = model.MetricTypeCPULoad
-	case "concurrency":
-		mType = model.MetricTypeConcurrency
-	case "":
-		mType = model.MetricTypeNone
-	default:
-		writeError(w, fmt.Sprintf("metric type is not valid; received %s", vars["type"]), http.StatusBadRequest)
+	mType, err := model.ParseMetricType(mux.Vars(r)["type"])
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
 		return nil
 	}
 
 	return &model.Query{
-		StartAt:    time.Unix(int64(startInt), 0),
-		EndAt:      time.Unix(int64(endInt), 0),
+		StartAt:    tr.start,
+		EndAt:      tr.end,
 		MetricType: mType,
 		Frequency:  frequency,
 	}
 }
 
 func writeError(w http.ResponseWriter, message string, httpStatusCode int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(httpStatusCode)
+	status, jsonResp := errorResponse(message, httpStatusCode)
+	writeResponse(w, status, jsonResp)
+}
+
+func errorResponse(message string, httpStatusCode int) (int, []byte) {
 	resp := make(map[string]string)
 	resp["message"] = message
 	jsonResp, _ := json.Marshal(resp)
+	return httpStatusCode, jsonResp
+}
+
+func writeResponse(w http.ResponseWriter, httpStatusCode int, jsonResp []byte) {
+	if httpStatusCode == http.StatusTooManyRequests {
+		w.Header().Set("Retry-After", "1")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatusCode)
 	w.Write(jsonResp)
 }
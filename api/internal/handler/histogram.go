@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"sky/api/internal/model"
+	"sky/api/internal/storage/histogram"
+)
+
+// GetHistogram returns the distribution of the values of the metric type in the time range, as the number of values
+// in each bucket; accepted query parameters, besides start and end:
+// * buckets - the number of buckets, 10 by default, or their boundaries separated by commas, e.g. 0,0.5,1,2
+// * scale - linear (default) for buckets of the same width, log for buckets growing by the same factor, or auto for
+// buckets of about the same number of values
+// * min, max - the range of the linear and log buckets; the range of the values by default
+// The values outside of the boundaries are counted as outside.
+func (h *Handler) GetHistogram(w http.ResponseWriter, r *http.Request) {
+	filter := buildQueryFilter(w, r)
+	if filter == nil {
+		return
+	}
+	if filter.MetricType == model.MetricTypeNone {
+		writeError(w, "metric type is required", http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	spec := model.HistogramSpec{Count: 10, Scale: model.HistogramScale(query.Get("scale"))}
+	if spec.Scale == "" {
+		spec.Scale = model.HistogramScaleLinear
+	}
+	if s := query.Get("buckets"); strings.Contains(s, ",") {
+		for _, b := range strings.Split(s, ",") {
+			v, err := strconv.ParseFloat(b, 64)
+			if err != nil {
+				writeError(w, fmt.Sprintf("buckets are not valid; expected a number or boundaries separated by commas, but received %s", s), http.StatusBadRequest)
+				return
+			}
+			spec.Boundaries = append(spec.Boundaries, v)
+		}
+	} else {
+		var err error
+		if spec.Count, err = intParam(query, "buckets", 10, 1); err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	for name, bound := range map[string]**float64{"min": &spec.Min, "max": &spec.Max} {
+		s := query.Get(name)
+		if s == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			writeError(w, fmt.Sprintf("%s is not valid; expected a number, but received %s", name, s), http.StatusBadRequest)
+			return
+		}
+		*bound = &v
+	}
+	if err := histogram.Validate(spec); err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hist, err := h.store.GetHistogram(r.Context(), *filter, spec)
+	switch {
+	case err != nil:
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	case hist == nil:
+		writeError(w, fmt.Sprintf("data for specified filter does not exist; filter: %v", *filter), http.StatusNotFound)
+		return
+	}
+	jsonResp, _ := json.Marshal(hist)
+	writeResponse(w, http.StatusOK, jsonResp)
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"sync"
+	"time"
+)
+
+// idempotencyTTL is how long the response of a write is replayed for a repeated Idempotency-Key
+const idempotencyTTL = 24 * time.Hour
+
+// idempotencyCache remembers the responses of the writes by their Idempotency-Key header,
+// so a retried write is answered with the first response instead of being queued again
+type idempotencyCache struct {
+	mu        sync.Mutex
+	entries   map[string]*idempotentResponse
+	lastSweep time.Time
+}
+
+type idempotentResponse struct {
+	created time.Time
+	// done is false while the first request with the key is being processed
+	done   bool
+	status int
+	body   []byte
+}
+
+func newIdempotencyCache() *idempotencyCache {
+	return &idempotencyCache{
+		entries: make(map[string]*idempotentResponse),
+	}
+}
+
+// reserve returns the stored response of the key, or reserves the key for the current request if it is new;
+// ok is false if another request with the same key is still being processed
+func (c *idempotencyCache) reserve(key string, now time.Time) (resp *idempotentResponse, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sweep(now)
+	if entry, exists := c.entries[key]; exists && now.Sub(entry.created) <= idempotencyTTL {
+		return entry, entry.done
+	}
+	c.entries[key] = &idempotentResponse{created: now}
+	return nil, true
+}
+
+// complete stores the response of a reserved key
+func (c *idempotencyCache) complete(key string, status int, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[key]; ok {
+		entry.done = true
+		entry.status = status
+		entry.body = body
+	}
+}
+
+// release forgets a reserved key, so the request can be retried with it, e.g. after it was rejected for a full queue
+func (c *idempotencyCache) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+// sweep removes the expired entries, at most once a minute
+func (c *idempotencyCache) sweep(now time.Time) {
+	if now.Sub(c.lastSweep) < time.Minute {
+		return
+	}
+	c.lastSweep = now
+	for key, entry := range c.entries {
+		if now.Sub(entry.created) > idempotencyTTL {
+			delete(c.entries, key)
+		}
+	}
+}
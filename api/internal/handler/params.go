@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// intParam returns the integer query parameter, or the default if it is not set; it has to be at least min
+func intParam(query url.Values, name string, def, min int) (int, error) {
+	s := query.Get(name)
+	if s == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < min {
+		return 0, fmt.Errorf("%s is not valid; expected an integer of at least %d, but received %s", name, min, s)
+	}
+	return v, nil
+}
+
+// floatParam returns the positive number query parameter, or the default if it is not set
+func floatParam(query url.Values, name string, def float64) (float64, error) {
+	s := query.Get(name)
+	if s == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("%s is not valid; expected a positive number, but received %s", name, s)
+	}
+	return v, nil
+}
+
+// labelParams returns the label query parameters, each written as name=value
+func labelParams(query url.Values) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, label := range query["label"] {
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("label is not valid; expected name=value, but received %s", label)
+		}
+		labels[parts[0]] = parts[1]
+	}
+	return labels, nil
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+
+	"sky/api/internal/model"
+)
+
+// RetentionStore is an interface for viewing and changing how long the stored resolutions are kept
+type RetentionStore interface {
+	GetRetention(ctx context.Context) (*model.Retention, error)
+	SetRetention(ctx context.Context, retention model.Retention) error
+}
+
+// RetentionHandler is responsible for the API requests of the retention of the metrics
+type RetentionHandler struct {
+	store RetentionStore
+}
+
+// NewRetentionHandler creates a handler for the retention of the store
+func NewRetentionHandler(store RetentionStore) *RetentionHandler {
+	return &RetentionHandler{store: store}
+}
+
+// GetRetention returns the retention of the raw, hourly and daily resolutions in seconds
+func (h *RetentionHandler) GetRetention(w http.ResponseWriter, r *http.Request) {
+	retention, err := h.store.GetRetention(r.Context())
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonResp, _ := json.Marshal(retention)
+	writeResponse(w, http.StatusOK, jsonResp)
+}
+
+// SetRetention changes the retention of the resolutions set in the json body, in seconds;
+// the resolutions missing from the body or set to 0 keep their retention
+func (h *RetentionHandler) SetRetention(w http.ResponseWriter, r *http.Request) {
+	var changed model.Retention
+	if err := json.NewDecoder(r.Body).Decode(&changed); err != nil {
+		writeError(w, fmt.Sprintf("request body is not valid; expected the retention in seconds, e.g. {\"raw\": 2592000}: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	if changed.Raw < 0 || changed.Hourly < 0 || changed.Daily < 0 {
+		writeError(w, "retention has to be a positive number of seconds", http.StatusBadRequest)
+		return
+	}
+	if changed.Hourly > math.MaxInt32 || changed.Daily > math.MaxInt32 {
+		writeError(w, fmt.Sprintf("retention of the rollups can be at most %d seconds", math.MaxInt32), http.StatusBadRequest)
+		return
+	}
+
+	retention, err := h.store.GetRetention(r.Context())
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if changed.Raw > 0 {
+		retention.Raw = changed.Raw
+	}
+	if changed.Hourly > 0 {
+		retention.Hourly = changed.Hourly
+	}
+	if changed.Daily > 0 {
+		retention.Daily = changed.Daily
+	}
+
+	if err := h.store.SetRetention(r.Context(), *retention); err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonResp, _ := json.Marshal(retention)
+	writeResponse(w, http.StatusOK, jsonResp)
+}
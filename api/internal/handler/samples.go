@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"sky/api/internal/model"
+
+	"github.com/gorilla/mux"
+)
+
+// SampleStore is an interface for reading the labelled samples, e.g. the series of the recording rules
+type SampleStore interface {
+	GetSamples(ctx context.Context, query model.SampleQuery) ([]model.Sample, error)
+}
+
+// SamplesHandler is responsible for the API requests for returning the samples of a named series
+type SamplesHandler struct {
+	store SampleStore
+}
+
+// NewSamplesHandler creates a handler with a storage for the sample queries
+func NewSamplesHandler(store SampleStore) *SamplesHandler {
+	return &SamplesHandler{store: store}
+}
+
+// GetSamples returns the samples of the name in the url, in the start-end range;
+// every label query parameter, written as name=value, filters the samples by a label
+func (h *SamplesHandler) GetSamples(w http.ResponseWriter, r *http.Request) {
+	filter := buildQueryFilter(w, r)
+	if filter == nil {
+		return
+	}
+
+	labels, err := labelParams(r.URL.Query())
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := model.SampleQuery{
+		Name:    mux.Vars(r)["name"],
+		StartAt: filter.StartAt,
+		EndAt:   filter.EndAt,
+		Labels:  labels,
+	}
+
+	samples, err := h.store.GetSamples(r.Context(), query)
+	switch {
+	case err != nil:
+		writeError(w, err.Error(), http.StatusInternalServerError)
+	case len(samples) == 0:
+		writeError(w, fmt.Sprintf("samples of %s do not exist in the given time range", query.Name), http.StatusNotFound)
+	default:
+		jsonResp, _ := json.Marshal(samples)
+		writeResponse(w, http.StatusOK, jsonResp)
+	}
+}
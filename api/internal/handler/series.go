@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"sky/api/internal/model"
+
+	"github.com/gorilla/mux"
+)
+
+// SeriesStore is an interface for discovering the stored series
+type SeriesStore interface {
+	GetSeriesInfo(ctx context.Context, metricType model.MetricType) (*model.SeriesInfo, error)
+	ListSeries(ctx context.Context) ([]model.SeriesInfo, error)
+}
+
+// SeriesHandler is responsible for the API requests describing the stored series, so the clients know where their
+// data is before querying it
+type SeriesHandler struct {
+	store SeriesStore
+}
+
+// NewSeriesHandler creates a handler with a storage for the series info
+func NewSeriesHandler(store SeriesStore) *SeriesHandler {
+	return &SeriesHandler{store: store}
+}
+
+// ListSeries returns the metric types, the samples and the distributions that have stored points, with their first
+// and last timestamps, counts and value ranges
+func (h *SeriesHandler) ListSeries(w http.ResponseWriter, r *http.Request) {
+	series, err := h.store.ListSeries(r.Context())
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonResp, _ := json.Marshal(series)
+	writeResponse(w, http.StatusOK, jsonResp)
+}
+
+// GetInfo returns the first and last timestamps, the count and the value range of the metric type in the url
+func (h *SeriesHandler) GetInfo(w http.ResponseWriter, r *http.Request) {
+	metricType, err := model.ParseMetricType(mux.Vars(r)["type"])
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	info, err := h.store.GetSeriesInfo(r.Context(), metricType)
+	switch {
+	case err != nil:
+		writeError(w, err.Error(), http.StatusInternalServerError)
+	case info == nil:
+		writeError(w, fmt.Sprintf("no %s metrics are stored", metricType), http.StatusNotFound)
+	default:
+		jsonResp, _ := json.Marshal(info)
+		writeResponse(w, http.StatusOK, jsonResp)
+	}
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"sky/api/internal/model"
+)
+
+// StatsStore is an interface for the storage usage of the collections
+type StatsStore interface {
+	GetCollectionStats(ctx context.Context) ([]model.CollectionStats, error)
+	GetDailyCounts(ctx context.Context, start, end time.Time, loc *time.Location) ([]model.DailyCount, error)
+}
+
+// StatsHandler is responsible for the admin API requests watching the growth of the stored data
+type StatsHandler struct {
+	store StatsStore
+}
+
+// NewStatsHandler creates a handler with a storage for the collection stats
+func NewStatsHandler(store StatsStore) *StatsHandler {
+	return &StatsHandler{store: store}
+}
+
+// GetStats returns the size, storage size, index size and compression ratio of the collections, and the buckets
+// of the timeseries collections
+func (h *StatsHandler) GetStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.store.GetCollectionStats(r.Context())
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonResp, _ := json.Marshal(stats)
+	writeResponse(w, http.StatusOK, jsonResp)
+}
+
+// GetDailyCounts returns the number of metrics stored for each day of the range;
+// the range is set like the range of the timeline: start and end, or period, and tz for the start of the days
+func (h *StatsHandler) GetDailyCounts(w http.ResponseWriter, r *http.Request) {
+	tr, err := parseTimeRange(r.URL.Query(), time.Now())
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	counts, err := h.store.GetDailyCounts(r.Context(), tr.start, tr.end, tr.loc)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonResp, _ := json.Marshal(counts)
+	writeResponse(w, http.StatusOK, jsonResp)
+}
@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"sky/api/internal/config"
+)
+
+// timeRange is the time range of a query
+type timeRange struct {
+	start time.Time
+	end   time.Time
+	// period is set for a calendar period, which ends right before next
+	period bool
+	next   time.Time
+	// loc is the timezone of the tz query parameter
+	loc *time.Location
+}
+
+// parseTimeRange returns the time range of the start and end, or of the period query parameter, in the timezone of
+// the tz query parameter
+func parseTimeRange(query url.Values, now time.Time) (*timeRange, error) {
+	loc := time.UTC
+	if tz := query.Get("tz"); tz != "" {
+		var err error
+		// Local is the timezone of the server, which the clients can't know
+		if loc, err = time.LoadLocation(tz); err != nil || loc == time.Local {
+			return nil, fmt.Errorf("tz is not valid; expected a timezone like Europe/Berlin, but received %s", tz)
+		}
+	}
+	now = now.In(loc)
+
+	start, end := query.Get("start"), query.Get("end")
+	if period := query.Get("period"); period != "" {
+		if start != "" || end != "" {
+			return nil, fmt.Errorf("period can't be combined with start and end")
+		}
+		startAt, next, err := parsePeriod(period, now)
+		if err != nil {
+			return nil, err
+		}
+		// the stored times have a precision of milliseconds, so the points at the start of the next period are left out
+		return &timeRange{start: startAt, end: next.Add(-time.Nanosecond), period: true, next: next, loc: loc}, nil
+	}
+
+	if start == "" {
+		return nil, fmt.Errorf("timerange wasn't specified")
+	}
+	if end == "" {
+		end = "now"
+	}
+	startAt, err := parseTime(start, now)
+	if err != nil {
+		return nil, fmt.Errorf("start timestamp is not valid; %w", err)
+	}
+	endAt, err := parseTime(end, now)
+	if err != nil {
+		return nil, fmt.Errorf("end timestamp is not valid; %w", err)
+	}
+	if endAt.Before(startAt) {
+		return nil, fmt.Errorf("timerange is not valid; start %s is after end %s", startAt.UTC(), endAt.UTC())
+	}
+	return &timeRange{start: startAt, end: endAt, loc: loc}, nil
+}
+
+// parsePeriod returns the start of the calendar period and the start of the one after it, in the location of now:
+// * today, yesterday
+// * this_week, last_week - the weeks start on Monday
+// * this_month, last_month, this_year, last_year
+// * a year, month or day like 2022, 2022-04 or 2022-04-25
+func parsePeriod(s string, now time.Time) (time.Time, time.Time, error) {
+	day, _ := roundDown(now, "d")
+	week, _ := roundDown(now, "w")
+	month, _ := roundDown(now, "M")
+	year, _ := roundDown(now, "y")
+	switch s {
+	case "today":
+		return day, day.AddDate(0, 0, 1), nil
+	case "yesterday":
+		return day.AddDate(0, 0, -1), day, nil
+	case "this_week":
+		return week, week.AddDate(0, 0, 7), nil
+	case "last_week":
+		return week.AddDate(0, 0, -7), week, nil
+	case "this_month":
+		return month, month.AddDate(0, 1, 0), nil
+	case "last_month":
+		return month.AddDate(0, -1, 0), month, nil
+	case "this_year":
+		return year, year.AddDate(1, 0, 0), nil
+	case "last_year":
+		return year.AddDate(-1, 0, 0), year, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, now.Location()); err == nil {
+		return t, t.AddDate(0, 0, 1), nil
+	}
+	if t, err := time.ParseInLocation("2006-01", s, now.Location()); err == nil {
+		return t, t.AddDate(0, 1, 0), nil
+	}
+	if t, err := time.ParseInLocation("2006", s, now.Location()); err == nil {
+		return t, t.AddDate(1, 0, 0), nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("period is not valid; expected today, yesterday, this_week, last_week, this_month, last_month, this_year, last_year or a date like 2022-04, but received %s", s)
+}
+
+// parseTime parses a start or end query parameter, which is one of:
+// * an epoch in seconds, milliseconds, microseconds or nanoseconds, told apart by its magnitude
+// * an RFC3339 timestamp like 2022-04-25T10:00:00Z, or a date like 2022-04-25 for its midnight in the location of now
+// * a time relative to now, like now-24h or now/d: the offsets (+ or - a duration, also in d, w, y or M for
+// calendar months) and roundings (/ and a unit of s, m, h, d, w, M or y, rounding down to its start) apply from left
+// to right, e.g. now-1d/d is the start of yesterday; the days, weeks, months and years are the ones of the location
+// of now
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if strings.HasPrefix(s, "now") {
+		return parseRelativeTime(s, now)
+	}
+	if epoch, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return parseEpoch(epoch), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, now.Location()); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("expected an epoch, an RFC3339 timestamp or a relative time like now-24h, but received %s", s)
+}
+
+// parseEpoch returns the time of an epoch; the epochs up to 10^11 are seconds (until the year 5138), up to 10^14
+// milliseconds, up to 10^17 microseconds, and the larger ones nanoseconds
+func parseEpoch(epoch int64) time.Time {
+	abs := epoch
+	if abs < 0 {
+		abs = -abs
+	}
+	switch {
+	case abs < 1e11:
+		return time.Unix(epoch, 0)
+	case abs < 1e14:
+		return time.UnixMilli(epoch)
+	case abs < 1e17:
+		return time.UnixMicro(epoch)
+	default:
+		return time.Unix(0, epoch)
+	}
+}
+
+func parseRelativeTime(s string, now time.Time) (time.Time, error) {
+	t := now
+	// a + that was not escaped in the url is decoded as a space
+	rest := strings.ReplaceAll(strings.TrimPrefix(s, "now"), " ", "+")
+	for rest != "" {
+		op := rest[0]
+		end := strings.IndexAny(rest[1:], "+-/")
+		if end < 0 {
+			end = len(rest) - 1
+		}
+		arg := rest[1 : end+1]
+		rest = rest[end+1:]
+
+		var err error
+		switch op {
+		case '+', '-':
+			t, err = addOffset(t, arg, op == '-')
+		case '/':
+			t, err = roundDown(t, arg)
+		default:
+			err = fmt.Errorf("unexpected %c", op)
+		}
+		if err != nil {
+			return time.Time{}, fmt.Errorf("relative time %s is not valid: %w", s, err)
+		}
+	}
+	return t, nil
+}
+
+// addOffset adds the duration to the time, or subtracts it; months are calendar months
+func addOffset(t time.Time, offset string, subtract bool) (time.Time, error) {
+	sign := 1
+	if subtract {
+		sign = -1
+	}
+	if strings.HasSuffix(offset, "M") {
+		months, err := strconv.Atoi(strings.TrimSuffix(offset, "M"))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("offset %s is not a number of months", offset)
+		}
+		return t.AddDate(0, sign*months, 0), nil
+	}
+	d, err := config.ParseDuration(offset)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.Add(time.Duration(sign) * d), nil
+}
+
+// roundDown returns the start of the unit the time is in; weeks start on Monday
+func roundDown(t time.Time, unit string) (time.Time, error) {
+	year, month, day := t.Date()
+	switch unit {
+	case "s":
+		return t.Truncate(time.Second), nil
+	case "m":
+		return time.Date(year, month, day, t.Hour(), t.Minute(), 0, 0, t.Location()), nil
+	case "h":
+		return time.Date(year, month, day, t.Hour(), 0, 0, 0, t.Location()), nil
+	case "d":
+		return time.Date(year, month, day, 0, 0, 0, 0, t.Location()), nil
+	case "w":
+		weekday := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-weekday, 0, 0, 0, 0, t.Location()), nil
+	case "M":
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location()), nil
+	case "y":
+		return time.Date(year, 1, 1, 0, 0, 0, 0, t.Location()), nil
+	default:
+		return time.Time{}, fmt.Errorf("rounding unit %s is not one of s, m, h, d, w, M or y", unit)
+	}
+}
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2022, 4, 27, 15, 4, 5, 0, time.UTC)
+	cases := []struct {
+		value    string
+		expected time.Time
+	}{
+		{"1650843741", time.Unix(1650843741, 0)},
+		{"1650843741123", time.Unix(1650843741, 123000000)},
+		{"1650843741123456", time.Unix(1650843741, 123456000)},
+		{"1650843741123456789", time.Unix(1650843741, 123456789)},
+		{"0", time.Unix(0, 0)},
+		{"2022-04-25T10:00:00Z", time.Date(2022, 4, 25, 10, 0, 0, 0, time.UTC)},
+		{"2022-04-25T12:00:00.5+02:00", time.Date(2022, 4, 25, 10, 0, 0, 500000000, time.UTC)},
+		{"2022-04-25", time.Date(2022, 4, 25, 0, 0, 0, 0, time.UTC)},
+		{"now", now},
+		{"now-24h", now.Add(-24 * time.Hour)},
+		{"now+90m", now.Add(90 * time.Minute)},
+		{"now 1h", now.Add(time.Hour)},
+		{"now-7d", now.AddDate(0, 0, -7)},
+		{"now/d", time.Date(2022, 4, 27, 0, 0, 0, 0, time.UTC)},
+		{"now-1d/d", time.Date(2022, 4, 26, 0, 0, 0, 0, time.UTC)},
+		{"now/d+8h", time.Date(2022, 4, 27, 8, 0, 0, 0, time.UTC)},
+		{"now/w", time.Date(2022, 4, 25, 0, 0, 0, 0, time.UTC)},
+		{"now-1M/M", time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"now/y", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"now/h", time.Date(2022, 4, 27, 15, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		parsed, err := parseTime(c.value, now)
+		assert.Nil(t, err, c.value)
+		assert.True(t, c.expected.Equal(parsed), "%s: expected %s, but parsed %s", c.value, c.expected, parsed)
+	}
+
+	// the rounding follows the location of now
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if assert.Nil(t, err) {
+		parsed, err := parseTime("now/d", now.In(berlin))
+		assert.Nil(t, err)
+		assert.Equal(t, time.Date(2022, 4, 26, 22, 0, 0, 0, time.UTC), parsed.UTC())
+	}
+
+	for _, invalid := range []string{"", "yesterday", "2022-04-25 10:00", "now-", "now/q", "now*2", "now-xM", "1.5"} {
+		_, err := parseTime(invalid, now)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestParsePeriod(t *testing.T) {
+	// a Wednesday
+	now := time.Date(2022, 4, 27, 15, 4, 5, 0, time.UTC)
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+	}
+	cases := []struct {
+		period string
+		start  time.Time
+		next   time.Time
+	}{
+		{"today", day(2022, 4, 27), day(2022, 4, 28)},
+		{"yesterday", day(2022, 4, 26), day(2022, 4, 27)},
+		{"this_week", day(2022, 4, 25), day(2022, 5, 2)},
+		{"last_week", day(2022, 4, 18), day(2022, 4, 25)},
+		{"this_month", day(2022, 4, 1), day(2022, 5, 1)},
+		{"last_month", day(2022, 3, 1), day(2022, 4, 1)},
+		{"this_year", day(2022, 1, 1), day(2023, 1, 1)},
+		{"last_year", day(2021, 1, 1), day(2022, 1, 1)},
+		{"2021-12", day(2021, 12, 1), day(2022, 1, 1)},
+		{"2022-02-28", day(2022, 2, 28), day(2022, 3, 1)},
+		{"2020", day(2020, 1, 1), day(2021, 1, 1)},
+	}
+	for _, c := range cases {
+		start, next, err := parsePeriod(c.period, now)
+		assert.Nil(t, err, c.period)
+		assert.Equal(t, c.start, start, c.period)
+		assert.Equal(t, c.next, next, c.period)
+	}
+
+	for _, invalid := range []string{"tomorrow", "2022-13", "22-04", "last_decade"} {
+		_, _, err := parsePeriod(invalid, now)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	now := time.Date(2022, 4, 27, 23, 30, 0, 0, time.UTC)
+
+	// it is already the 28th in Berlin
+	tr, err := parseTimeRange(url.Values{"period": {"today"}, "tz": {"Europe/Berlin"}}, now)
+	if assert.Nil(t, err) {
+		assert.True(t, tr.period)
+		assert.Equal(t, time.Date(2022, 4, 27, 22, 0, 0, 0, time.UTC), tr.start.UTC())
+		assert.Equal(t, time.Date(2022, 4, 28, 22, 0, 0, 0, time.UTC), tr.next.UTC())
+		assert.Equal(t, tr.next.Add(-time.Nanosecond), tr.end)
+	}
+
+	tr, err = parseTimeRange(url.Values{"start": {"now/d"}, "tz": {"America/New_York"}}, now)
+	if assert.Nil(t, err) {
+		assert.False(t, tr.period)
+		assert.Equal(t, time.Date(2022, 4, 27, 4, 0, 0, 0, time.UTC), tr.start.UTC())
+		assert.True(t, now.Equal(tr.end))
+	}
+
+	invalid := []url.Values{
+		{},
+		{"end": {"now"}},
+		{"start": {"now"}, "end": {"now-1h"}},
+		{"period": {"today"}, "start": {"now-1h"}},
+		{"period": {"today"}, "tz": {"Mars/Olympus"}},
+	}
+	for _, query := range invalid {
+		_, err := parseTimeRange(query, now)
+		assert.NotNil(t, err, "%v", query)
+	}
+}
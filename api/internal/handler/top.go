@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"sky/api/internal/model"
+)
+
+// maxTopPoints limits the number of points of the top and bottom queries
+const maxTopPoints = 1000
+
+// GetTop returns the points of the metric type with the highest values in the time range, from the highest down;
+// accepted query parameters, besides the ones of GetTimeline:
+// * k - the number of points, 10 by default
+// With a frequency, the points are the averages of its buckets, e.g. the busiest hours with frequency=hours.
+func (h *Handler) GetTop(w http.ResponseWriter, r *http.Request) {
+	h.getRanked(w, r, false)
+}
+
+// GetBottom returns the points of the metric type with the lowest values in the time range, from the lowest up;
+// it accepts the query parameters of GetTop
+func (h *Handler) GetBottom(w http.ResponseWriter, r *http.Request) {
+	h.getRanked(w, r, true)
+}
+
+func (h *Handler) getRanked(w http.ResponseWriter, r *http.Request, ascending bool) {
+	filter := buildQueryFilter(w, r)
+	if filter == nil {
+		return
+	}
+	if filter.MetricType == model.MetricTypeNone {
+		writeError(w, "metric type is required", http.StatusBadRequest)
+		return
+	}
+	k, err := intParam(r.URL.Query(), "k", 10, 1)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if k > maxTopPoints {
+		writeError(w, fmt.Sprintf("k is not valid; expected at most %d, but received %d", maxTopPoints, k), http.StatusBadRequest)
+		return
+	}
+
+	points, err := h.store.GetTop(r.Context(), *filter, k, ascending)
+	switch {
+	case err != nil:
+		writeError(w, err.Error(), http.StatusInternalServerError)
+		return
+	case len(points) == 0:
+		writeError(w, fmt.Sprintf("data for specified filter does not exist; filter: %v", *filter), http.StatusNotFound)
+		return
+	}
+	jsonResp, _ := json.Marshal(points)
+	writeResponse(w, http.StatusOK, jsonResp)
+}
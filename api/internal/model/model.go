@@ -1,6 +1,10 @@
 package model
 
 import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -24,6 +28,32 @@ const (
 	MetricTypeConcurrency MetricType = 2
 )
 
+// String returns the name used for the metric type in urls and documents
+func (t MetricType) String() string {
+	switch t {
+	case MetricTypeCPULoad:
+		return "cpu_load"
+	case MetricTypeConcurrency:
+		return "concurrency"
+	default:
+		return ""
+	}
+}
+
+// ParseMetricType returns the metric type for its name; an empty name is MetricTypeNone
+func ParseMetricType(name string) (MetricType, error) {
+	switch name {
+	case "cpu_load":
+		return MetricTypeCPULoad, nil
+	case "concurrency":
+		return MetricTypeConcurrency, nil
+	case "":
+		return MetricTypeNone, nil
+	default:
+		return MetricTypeNone, fmt.Errorf("metric type is not valid; received %s", name)
+	}
+}
+
 // Frequency determines in what ranged should the metrics be aggregated
 type Frequency int32
 
@@ -44,6 +74,48 @@ const (
 	FrequencyByYears Frequency = 6
 )
 
+// String returns the name used for the frequency in urls
+func (f Frequency) String() string {
+	switch f {
+	case FrequencyBySeconds:
+		return "seconds"
+	case FrequencyByMinutes:
+		return "minutes"
+	case FrequencyByHours:
+		return "hours"
+	case FrequencyByDays:
+		return "days"
+	case FrequencyByMonths:
+		return "months"
+	case FrequencyByYears:
+		return "years"
+	default:
+		return ""
+	}
+}
+
+// ParseFrequency returns the frequency for its name; an empty name is FrequencyNone
+func ParseFrequency(name string) (Frequency, error) {
+	switch name {
+	case "seconds":
+		return FrequencyBySeconds, nil
+	case "minutes":
+		return FrequencyByMinutes, nil
+	case "hours":
+		return FrequencyByHours, nil
+	case "days":
+		return FrequencyByDays, nil
+	case "months":
+		return FrequencyByMonths, nil
+	case "years":
+		return FrequencyByYears, nil
+	case "":
+		return FrequencyNone, nil
+	default:
+		return FrequencyNone, fmt.Errorf("frequency value is not valid; received %s", name)
+	}
+}
+
 // Metric is the data structure with all the metric types saved in the store
 type Metric struct {
 	Timestamp   time.Time `bson:"timestamp" json:"timestamp"`
@@ -58,3 +130,240 @@ type MetricAverage struct {
 	CPULoad     float64   `bson:"cpu_load,omitempty" json:"cpu_load,omitempty"`
 	Concurrency float64   `bson:"concurrency,omitempty" json:"concurrency,omitempty"`
 }
+
+// Sample is a single value of a named series; the series is identified by its name and labels
+type Sample struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Name      string            `json:"name"`
+	Type      string            `json:"type,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Value     float64           `json:"value"`
+}
+
+const (
+	// SampleTypeGauge is a value that can go up and down
+	SampleTypeGauge = "gauge"
+	// SampleTypeCounter is a cumulative value that only increases (or resets)
+	SampleTypeCounter = "counter"
+	// SampleTypeUntyped is a value without type information
+	SampleTypeUntyped = "untyped"
+)
+
+// SeriesID identifies the series of the sample by its name and sorted labels, e.g. requests{code="200",method="get"}
+func (s Sample) SeriesID() string {
+	return seriesID(s.Name, s.Labels)
+}
+
+func seriesID(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", k, labels[k])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// Distribution is the distribution of the values a series observed over an interval, e.g. the latencies of the
+// requests, as the number of values in each bucket with their sum and count. Unlike averages, the distributions
+// of the same buckets can be merged, so they can be aggregated over longer intervals.
+type Distribution struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Name      string            `json:"name"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	// Bounds are the increasing upper boundaries of the buckets; a value equal to a boundary is in its bucket, and
+	// the last bucket holds the values above the last boundary
+	Bounds []float64 `json:"bounds"`
+	// Counts are the numbers of values in the buckets, one more than the boundaries
+	Counts []int64 `json:"counts"`
+	Sum    float64 `json:"sum"`
+	Count  int64   `json:"count"`
+}
+
+// SeriesID identifies the series of the distribution by its name and sorted labels, like Sample.SeriesID
+func (d Distribution) SeriesID() string {
+	return seriesID(d.Name, d.Labels)
+}
+
+// Retention is how long the points of each resolution are kept, in seconds
+type Retention struct {
+	// Raw is the retention of the written metrics and samples
+	Raw    int64 `json:"raw"`
+	Hourly int64 `json:"hourly"`
+	Daily  int64 `json:"daily"`
+}
+
+// SampleQuery selects the samples of a name in a time range; the samples have to carry all the labels of the query
+type SampleQuery struct {
+	Name    string
+	Labels  map[string]string
+	StartAt time.Time
+	EndAt   time.Time
+}
+
+// HistogramScale is how the boundaries of the buckets of a histogram are spread
+type HistogramScale string
+
+const (
+	// HistogramScaleLinear splits the range into buckets of the same width
+	HistogramScaleLinear HistogramScale = "linear"
+	// HistogramScaleLog splits the range into buckets growing by the same factor; the range has to be positive
+	HistogramScaleLog HistogramScale = "log"
+	// HistogramScaleAuto chooses the boundaries so the buckets hold about the same number of values
+	HistogramScaleAuto HistogramScale = "auto"
+)
+
+// HistogramSpec describes the buckets of a histogram: either the explicit boundaries, or the number of buckets
+// spread by the scale over the range from Min to Max, which default to the smallest and largest values
+type HistogramSpec struct {
+	Boundaries []float64
+	Count      int
+	Scale      HistogramScale
+	Min        *float64
+	Max        *float64
+}
+
+// HistogramBucket is the number of values from Lower (inclusive) to Upper (exclusive, except for the last bucket of
+// a range that was not given explicitly)
+type HistogramBucket struct {
+	Lower float64 `json:"lower"`
+	Upper float64 `json:"upper"`
+	Count int64   `json:"count"`
+}
+
+// Histogram is the distribution of the values of a metric
+type Histogram struct {
+	Buckets []HistogramBucket `json:"buckets"`
+	// Outside is the number of values outside the boundaries
+	Outside int64 `json:"outside"`
+}
+
+// SeriesKind is where the points of a series are stored
+type SeriesKind string
+
+const (
+	// SeriesKindMetric is a field of the metrics, e.g. cpu_load
+	SeriesKindMetric SeriesKind = "metric"
+	// SeriesKindSample is a series of labelled samples
+	SeriesKindSample SeriesKind = "sample"
+	// SeriesKindDistribution is a series of distributions
+	SeriesKindDistribution SeriesKind = "distribution"
+)
+
+// SeriesInfo describes the stored points of a series: when they start and end, how many there are and their range
+type SeriesInfo struct {
+	Name   string            `json:"name"`
+	Kind   SeriesKind        `json:"kind"`
+	Type   string            `json:"type,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	First  time.Time         `json:"first"`
+	Last   time.Time         `json:"last"`
+	Count  int64             `json:"count"`
+	// Min and Max are the range of the values; the distributions don't have them
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+}
+
+// CollectionStats is the storage usage of a collection, in bytes
+type CollectionStats struct {
+	Name string `json:"name"`
+	// Size is the size of the uncompressed data, and StorageSize the size of the compressed data on disk
+	Size        int64 `json:"size"`
+	StorageSize int64 `json:"storageSize"`
+	IndexSize   int64 `json:"indexSize"`
+	// CompressionRatio is the size divided by the storage size; it is missing while nothing is stored
+	CompressionRatio *float64 `json:"compressionRatio,omitempty"`
+	// Documents is missing for the timeseries collections, whose documents are counted by day
+	Documents *int64 `json:"documents,omitempty"`
+	// Buckets is the number of buckets of a timeseries collection, and AvgBucketSize their average size
+	Buckets       *int64 `json:"buckets,omitempty"`
+	AvgBucketSize *int64 `json:"avgBucketSize,omitempty"`
+}
+
+// DailyCount is the number of documents stored for a day
+type DailyCount struct {
+	Day   time.Time `json:"day"`
+	Count int64     `json:"count"`
+}
+
+// ErrUnavailable is wrapped by the errors of a store that can pass, like a lost connection or a timeout,
+// so the write can be retried
+var ErrUnavailable = errors.New("store is unavailable")
+
+// RejectedError is returned by the writes that didn't save some of their points, e.g. the invalid or the duplicated
+// ones; the rest of the points are saved. Rejected is the number of the points that were not saved.
+type RejectedError struct {
+	Rejected int
+	Err      error
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("%d points were rejected: %s", e.Rejected, e.Err.Error())
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Err
+}
+
+// MergeRejected returns the rejections of two writes as one error; an error that is not a RejectedError
+// takes precedence, as the write failed. The causes of both rejections can be matched with errors.Is.
+func MergeRejected(first, second error) error {
+	if first == nil {
+		return second
+	}
+	if second == nil {
+		return first
+	}
+	var a, b *RejectedError
+	if !errors.As(first, &a) {
+		return first
+	}
+	if !errors.As(second, &b) {
+		return second
+	}
+	return &RejectedError{Rejected: a.Rejected + b.Rejected, Err: rejectionCauses{a.Err, b.Err}}
+}
+
+// rejectionCauses are the causes of merged rejections
+type rejectionCauses []error
+
+func (c rejectionCauses) Error() string {
+	messages := make([]string, 0, len(c))
+	for _, err := range c {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Is reports whether any of the causes matches the target
+func (c rejectionCauses) Is(target error) bool {
+	for _, err := range c {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first cause matching the target
+func (c rejectionCauses) As(target interface{}) bool {
+	for _, err := range c {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
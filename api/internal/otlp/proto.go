@@ -0,0 +1,330 @@
+package otlp
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// field is a decoded protobuf field; varint and fixed values are in num, length delimited values in bytes
+type field struct {
+	number protowire.Number
+	typ    protowire.Type
+	num    uint64
+	bytes  []byte
+}
+
+// forEachField decodes the fields of a protobuf message; unknown fields are passed to fn as well, so it can skip them
+func forEachField(b []byte, fn func(f field) error) error {
+	for len(b) > 0 {
+		number, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		f := field{number: number, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.num, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			f.num, n = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var v uint32
+			v, n = protowire.ConsumeFixed32(b)
+			f.num = uint64(v)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(number, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeRequest(b []byte) (*exportRequest, error) {
+	req := &exportRequest{}
+	err := forEachField(b, func(f field) error {
+		if f.number == 1 && f.typ == protowire.BytesType {
+			rm, err := decodeResourceMetrics(f.bytes)
+			if err != nil {
+				return err
+			}
+			req.ResourceMetrics = append(req.ResourceMetrics, rm)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("protobuf request is not valid: %w", err)
+	}
+	return req, nil
+}
+
+func decodeResourceMetrics(b []byte) (resourceMetrics, error) {
+	var rm resourceMetrics
+	err := forEachField(b, func(f field) error {
+		if f.typ != protowire.BytesType {
+			return nil
+		}
+		switch f.number {
+		case 1:
+			return forEachField(f.bytes, func(f field) error {
+				if f.number == 1 && f.typ == protowire.BytesType {
+					kv, err := decodeKeyValue(f.bytes)
+					if err != nil {
+						return err
+					}
+					rm.Resource.Attributes = append(rm.Resource.Attributes, kv)
+				}
+				return nil
+			})
+		case 2, 1000:
+			sm, err := decodeScopeMetrics(f.bytes)
+			if err != nil {
+				return err
+			}
+			rm.ScopeMetrics = append(rm.ScopeMetrics, sm)
+		}
+		return nil
+	})
+	return rm, err
+}
+
+func decodeScopeMetrics(b []byte) (scopeMetrics, error) {
+	var sm scopeMetrics
+	err := forEachField(b, func(f field) error {
+		if f.number == 2 && f.typ == protowire.BytesType {
+			m, err := decodeMetric(f.bytes)
+			if err != nil {
+				return err
+			}
+			sm.Metrics = append(sm.Metrics, m)
+		}
+		return nil
+	})
+	return sm, err
+}
+
+func decodeMetric(b []byte) (metric, error) {
+	var m metric
+	err := forEachField(b, func(f field) error {
+		if f.typ != protowire.BytesType {
+			return nil
+		}
+		switch f.number {
+		case 1:
+			m.Name = string(f.bytes)
+		case 5:
+			points, _, err := decodeDataPoints(f.bytes)
+			if err != nil {
+				return err
+			}
+			m.Gauge = &gauge{DataPoints: points}
+		case 7:
+			points, monotonic, err := decodeDataPoints(f.bytes)
+			if err != nil {
+				return err
+			}
+			m.Sum = &sum{DataPoints: points, IsMonotonic: monotonic}
+		case 9:
+			h, err := decodeHistogram(f.bytes)
+			if err != nil {
+				return err
+			}
+			m.Histogram = &h
+		}
+		return nil
+	})
+	return m, err
+}
+
+// decodeDataPoints decodes a Gauge or a Sum message; is_monotonic is only set for sums
+func decodeDataPoints(b []byte) ([]numberDataPoint, bool, error) {
+	var points []numberDataPoint
+	var monotonic bool
+	err := forEachField(b, func(f field) error {
+		switch {
+		case f.number == 1 && f.typ == protowire.BytesType:
+			p, err := decodeNumberDataPoint(f.bytes)
+			if err != nil {
+				return err
+			}
+			points = append(points, p)
+		case f.number == 3 && f.typ == protowire.VarintType:
+			monotonic = f.num != 0
+		}
+		return nil
+	})
+	return points, monotonic, err
+}
+
+func decodeNumberDataPoint(b []byte) (numberDataPoint, error) {
+	var p numberDataPoint
+	err := forEachField(b, func(f field) error {
+		switch {
+		case f.number == 7 && f.typ == protowire.BytesType:
+			kv, err := decodeKeyValue(f.bytes)
+			if err != nil {
+				return err
+			}
+			p.Attributes = append(p.Attributes, kv)
+		case f.number == 3 && f.typ == protowire.Fixed64Type:
+			p.TimeUnixNano = jsonInt(f.num)
+		case f.number == 4 && f.typ == protowire.Fixed64Type:
+			v := math.Float64frombits(f.num)
+			p.AsDouble = &v
+		case f.number == 6 && f.typ == protowire.Fixed64Type:
+			v := jsonInt(int64(f.num))
+			p.AsInt = &v
+		}
+		return nil
+	})
+	return p, err
+}
+
+func decodeHistogram(b []byte) (histogram, error) {
+	var h histogram
+	err := forEachField(b, func(f field) error {
+		switch {
+		case f.number == 1 && f.typ == protowire.BytesType:
+			p, err := decodeHistogramDataPoint(f.bytes)
+			if err != nil {
+				return err
+			}
+			h.DataPoints = append(h.DataPoints, p)
+		case f.number == 2 && f.typ == protowire.VarintType:
+			h.AggregationTemporality = temporality(f.num)
+		}
+		return nil
+	})
+	return h, err
+}
+
+func decodeHistogramDataPoint(b []byte) (histogramDataPoint, error) {
+	var p histogramDataPoint
+	err := forEachField(b, func(f field) error {
+		switch {
+		case f.number == 9 && f.typ == protowire.BytesType:
+			kv, err := decodeKeyValue(f.bytes)
+			if err != nil {
+				return err
+			}
+			p.Attributes = append(p.Attributes, kv)
+		case f.number == 3 && f.typ == protowire.Fixed64Type:
+			p.TimeUnixNano = jsonInt(f.num)
+		case f.number == 4 && f.typ == protowire.Fixed64Type:
+			p.Count = jsonInt(f.num)
+		case f.number == 5 && f.typ == protowire.Fixed64Type:
+			p.Sum = math.Float64frombits(f.num)
+		case f.number == 6:
+			return forEachFixed64(f, func(v uint64) {
+				p.BucketCounts = append(p.BucketCounts, jsonInt(v))
+			})
+		case f.number == 7:
+			return forEachFixed64(f, func(v uint64) {
+				p.ExplicitBounds = append(p.ExplicitBounds, math.Float64frombits(v))
+			})
+		}
+		return nil
+	})
+	return p, err
+}
+
+// forEachFixed64 calls fn with the values of a repeated fixed64 or double field, which are packed by default,
+// but can also be written one by one
+func forEachFixed64(f field, fn func(v uint64)) error {
+	switch f.typ {
+	case protowire.Fixed64Type:
+		fn(f.num)
+	case protowire.BytesType:
+		b := f.bytes
+		for len(b) > 0 {
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			fn(v)
+			b = b[n:]
+		}
+	}
+	return nil
+}
+
+func decodeKeyValue(b []byte) (keyValue, error) {
+	var kv keyValue
+	err := forEachField(b, func(f field) error {
+		if f.typ != protowire.BytesType {
+			return nil
+		}
+		switch f.number {
+		case 1:
+			kv.Key = string(f.bytes)
+		case 2:
+			v, err := decodeAnyValue(f.bytes)
+			if err != nil {
+				return err
+			}
+			kv.Value = v
+		}
+		return nil
+	})
+	return kv, err
+}
+
+func decodeAnyValue(b []byte) (anyValue, error) {
+	var v anyValue
+	err := forEachField(b, func(f field) error {
+		switch {
+		case f.number == 1 && f.typ == protowire.BytesType:
+			s := string(f.bytes)
+			v.StringValue = &s
+		case f.number == 2 && f.typ == protowire.VarintType:
+			bv := f.num != 0
+			v.BoolValue = &bv
+		case f.number == 3 && f.typ == protowire.VarintType:
+			iv := jsonInt(int64(f.num))
+			v.IntValue = &iv
+		case f.number == 4 && f.typ == protowire.Fixed64Type:
+			dv := math.Float64frombits(f.num)
+			v.DoubleValue = &dv
+		case (f.number == 5 || f.number == 6) && f.typ == protowire.BytesType:
+			// arrays and key-value lists are values of field 1 in their message
+			var values []anyValue
+			var kvs []keyValue
+			err := forEachField(f.bytes, func(inner field) error {
+				if inner.number != 1 || inner.typ != protowire.BytesType {
+					return nil
+				}
+				if f.number == 5 {
+					item, err := decodeAnyValue(inner.bytes)
+					values = append(values, item)
+					return err
+				}
+				item, err := decodeKeyValue(inner.bytes)
+				kvs = append(kvs, item)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			if f.number == 5 {
+				v.ArrayValue = &arrayValue{Values: values}
+			} else {
+				v.KvlistValue = &keyValueList{Values: kvs}
+			}
+		case f.number == 7 && f.typ == protowire.BytesType:
+			v.BytesValue = f.bytes
+		}
+		return nil
+	})
+	return v, err
+}
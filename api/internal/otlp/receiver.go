@@ -0,0 +1,280 @@
+package otlp
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sky/api/internal/model"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// maxBodySize limits the size of an uncompressed export request
+const maxBodySize = 16 << 20
+
+// Writer is an interface for saving the received samples and distributions
+type Writer interface {
+	InsertSamples(ctx context.Context, samples []model.Sample) error
+	InsertDistributions(ctx context.Context, dists []model.Distribution) error
+}
+
+// Receiver is the OTLP/HTTP metrics endpoint (POST /v1/metrics), accepting protobuf and json encoded export requests
+type Receiver struct {
+	writer Writer
+}
+
+// NewReceiver creates a receiver saving the gauge and sum data points, and the delta histograms with the writer
+func NewReceiver(writer Writer) *Receiver {
+	return &Receiver{
+		writer: writer,
+	}
+}
+
+// ServeHTTP decodes the export request by its content type and saves its data points; the points rejected by the
+// writer are reported in the partial_success of the response, like the distributions that failed after some of the
+// samples were saved. Only an unavailable store is worth a retry (503), the other errors would fail again (400).
+func (rcv *Receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != "application/x-protobuf" && contentType != "application/json" {
+		writeError(w, fmt.Sprintf("content type is not supported; expected application/x-protobuf or application/json, but received %s", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var req *exportRequest
+	if contentType == "application/json" {
+		req = &exportRequest{}
+		if err := json.Unmarshal(body, req); err != nil {
+			writeError(w, fmt.Sprintf("json request is not valid: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+	} else {
+		req, err = decodeRequest(body)
+		if err != nil {
+			writeError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	samples, dists := convert(req)
+	err = rcv.writer.InsertSamples(r.Context(), samples)
+	var rejected *model.RejectedError
+	if len(dists) > 0 && (err == nil || errors.As(err, &rejected)) {
+		saved := len(samples)
+		if rejected != nil {
+			saved -= rejected.Rejected
+		}
+		distErr := rcv.writer.InsertDistributions(r.Context(), dists)
+		if distErr != nil && saved > 0 && !errors.As(distErr, new(*model.RejectedError)) {
+			// a retry would write the saved samples again, so the distributions are reported as rejected instead
+			distErr = &model.RejectedError{Rejected: len(dists), Err: distErr}
+		}
+		err = model.MergeRejected(err, distErr)
+	}
+	switch {
+	case errors.As(err, &rejected) && rejected.Rejected < len(samples)+len(dists):
+		writeResponse(w, contentType, rejected)
+	case errors.Is(err, model.ErrUnavailable):
+		writeError(w, err.Error(), http.StatusServiceUnavailable)
+	case err != nil:
+		// retrying a rejected request doesn't help, so it is not a server error
+		writeError(w, err.Error(), http.StatusBadRequest)
+	default:
+		writeResponse(w, contentType, nil)
+	}
+}
+
+// writeResponse writes the ExportMetricsServiceResponse in the encoding of the request; without rejected points it
+// is empty, which is a full success, otherwise its partial_success has the number of the rejected points and why
+func writeResponse(w http.ResponseWriter, contentType string, rejected *model.RejectedError) {
+	var body []byte
+	if contentType == "application/json" {
+		body = []byte("{}")
+		if rejected != nil {
+			body, _ = json.Marshal(map[string]interface{}{
+				"partialSuccess": map[string]string{
+					"rejectedDataPoints": strconv.Itoa(rejected.Rejected),
+					"errorMessage":       rejected.Err.Error(),
+				},
+			})
+		}
+	} else if rejected != nil {
+		partial := protowire.AppendTag(nil, 1, protowire.VarintType)
+		partial = protowire.AppendVarint(partial, uint64(rejected.Rejected))
+		partial = protowire.AppendTag(partial, 2, protowire.BytesType)
+		partial = protowire.AppendString(partial, rejected.Err.Error())
+		body = protowire.AppendTag(nil, 1, protowire.BytesType)
+		body = protowire.AppendBytes(body, partial)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("gzip body is not valid: %w", err)
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	b, err := io.ReadAll(io.LimitReader(body, maxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+	if len(b) > maxBodySize {
+		return nil, fmt.Errorf("body is larger than %d bytes", maxBodySize)
+	}
+	return b, nil
+}
+
+// convert turns the gauge and sum data points into samples, and the histogram data points into distributions;
+// the labels are the resource attributes, overridden by the attributes of the data point. Monotonic sums are
+// counters, the rest are gauges.
+func convert(req *exportRequest) ([]model.Sample, []model.Distribution) {
+	var samples []model.Sample
+	var dists []model.Distribution
+	for _, rm := range req.ResourceMetrics {
+		resourceLabels := labels(rm.Resource.Attributes, nil)
+		scopes := append(rm.ScopeMetrics, rm.InstrumentationLibraryMetrics...)
+		for _, sm := range scopes {
+			for _, m := range sm.Metrics {
+				var points []numberDataPoint
+				typ := model.SampleTypeGauge
+				switch {
+				case m.Gauge != nil:
+					points = m.Gauge.DataPoints
+				case m.Sum != nil:
+					points = m.Sum.DataPoints
+					if m.Sum.IsMonotonic {
+						typ = model.SampleTypeCounter
+					}
+				case m.Histogram != nil:
+					// only the delta histograms are the values of their interval, which can be merged;
+					// the cumulative ones are not stored, neither are the summaries
+					if m.Histogram.AggregationTemporality == temporalityDelta {
+						dists = append(dists, distributions(m.Name, m.Histogram.DataPoints, resourceLabels)...)
+					}
+					continue
+				default:
+					continue
+				}
+
+				for _, p := range points {
+					var value float64
+					switch {
+					case p.AsDouble != nil:
+						value = *p.AsDouble
+					case p.AsInt != nil:
+						value = float64(*p.AsInt)
+					default:
+						continue
+					}
+					samples = append(samples, model.Sample{
+						Timestamp: time.Unix(0, int64(p.TimeUnixNano)).UTC(),
+						Name:      m.Name,
+						Type:      typ,
+						Labels:    labels(p.Attributes, resourceLabels),
+						Value:     value,
+					})
+				}
+			}
+		}
+	}
+	return samples, dists
+}
+
+func distributions(name string, points []histogramDataPoint, resourceLabels map[string]string) []model.Distribution {
+	dists := make([]model.Distribution, 0, len(points))
+	for _, p := range points {
+		counts := make([]int64, 0, len(p.BucketCounts))
+		for _, c := range p.BucketCounts {
+			counts = append(counts, int64(c))
+		}
+		if len(counts) == 0 {
+			// a histogram without buckets only has its count and sum
+			counts = []int64{int64(p.Count)}
+		}
+		dists = append(dists, model.Distribution{
+			Timestamp: time.Unix(0, int64(p.TimeUnixNano)).UTC(),
+			Name:      name,
+			Labels:    labels(p.Attributes, resourceLabels),
+			Bounds:    p.ExplicitBounds,
+			Counts:    counts,
+			Sum:       p.Sum,
+			Count:     int64(p.Count),
+		})
+	}
+	return dists
+}
+
+func labels(attributes []keyValue, base map[string]string) map[string]string {
+	if len(attributes) == 0 && len(base) == 0 {
+		return nil
+	}
+	l := make(map[string]string, len(attributes)+len(base))
+	for k, v := range base {
+		l[k] = v
+	}
+	for _, kv := range attributes {
+		l[kv.Key] = kv.Value.String()
+	}
+	return l
+}
+
+// String formats the value as a label value; arrays and lists are written as json
+func (v anyValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	case v.IntValue != nil:
+		return strconv.FormatInt(int64(*v.IntValue), 10)
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'g', -1, 64)
+	case v.ArrayValue != nil:
+		values := make([]string, 0, len(v.ArrayValue.Values))
+		for _, item := range v.ArrayValue.Values {
+			values = append(values, item.String())
+		}
+		b, _ := json.Marshal(values)
+		return string(b)
+	case v.KvlistValue != nil:
+		b, _ := json.Marshal(labels(v.KvlistValue.Values, nil))
+		return string(b)
+	case v.BytesValue != nil:
+		return base64.StdEncoding.EncodeToString(v.BytesValue)
+	default:
+		return ""
+	}
+}
+
+// writeError writes the error message as json for both encodings; the protobuf clients only rely on the status code
+func writeError(w http.ResponseWriter, message string, httpStatusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatusCode)
+	resp := make(map[string]string)
+	resp["message"] = message
+	jsonResp, _ := json.Marshal(resp)
+	w.Write(jsonResp)
+}
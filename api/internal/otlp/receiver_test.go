@@ -0,0 +1,237 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+	"sky/api/internal/model"
+	"sky/api/internal/storage/conflict"
+)
+
+const jsonRequest = `{
+  "resourceMetrics": [{
+    "resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "shop"}}]},
+    "scopeMetrics": [{
+      "metrics": [
+        {"name": "queue.depth", "gauge": {"dataPoints": [{"timeUnixNano": "1650843741000000000", "asDouble": 12.5}]}},
+        {"name": "requests", "sum": {"isMonotonic": true, "dataPoints": [
+          {"timeUnixNano": "1650843741000000000", "asInt": "42", "attributes": [{"key": "code", "value": {"intValue": "200"}}]}
+        ]}},
+        {"name": "latency", "histogram": {"aggregationTemporality": 1, "dataPoints": [
+          {"timeUnixNano": "1650843741000000000", "count": "3", "sum": 0.9, "bucketCounts": ["1", "2", "0"], "explicitBounds": [0.1, 0.5]}
+        ]}},
+        {"name": "total.latency", "histogram": {"aggregationTemporality": 2, "dataPoints": [{"count": "3"}]}}
+      ]
+    }]
+  }]
+}`
+
+func TestReceiveJSON(t *testing.T) {
+	writer := &mockWriter{}
+	rr := export(NewReceiver(writer), "application/json", []byte(jsonRequest))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "{}", rr.Body.String())
+
+	assert.Equal(t, 2, len(writer.samples))
+	assert.Equal(t, model.Sample{
+		Timestamp: time.Unix(1650843741, 0).UTC(),
+		Name:      "queue.depth",
+		Type:      model.SampleTypeGauge,
+		Labels:    map[string]string{"service.name": "shop"},
+		Value:     12.5,
+	}, writer.samples[0])
+	assert.Equal(t, model.SampleTypeCounter, writer.samples[1].Type)
+	assert.Equal(t, 42.0, writer.samples[1].Value)
+	assert.Equal(t, map[string]string{"service.name": "shop", "code": "200"}, writer.samples[1].Labels)
+
+	// the cumulative histogram is not stored
+	assert.Equal(t, []model.Distribution{{
+		Timestamp: time.Unix(1650843741, 0).UTC(),
+		Name:      "latency",
+		Labels:    map[string]string{"service.name": "shop"},
+		Bounds:    []float64{0.1, 0.5},
+		Counts:    []int64{1, 2, 0},
+		Sum:       0.9,
+		Count:     3,
+	}}, writer.dists)
+}
+
+func TestReceiveProtobuf(t *testing.T) {
+	attr := message(
+		stringField(1, "host"),
+		bytesField(2, stringField(1, "web01")),
+	)
+	point := message(
+		bytesField(7, attr),
+		fixed64Field(3, uint64(time.Unix(1650843741, 0).UnixNano())),
+		fixed64Field(4, math.Float64bits(0.75)),
+	)
+	metric := message(
+		stringField(1, "cpu.utilization"),
+		bytesField(5, bytesField(1, point)),
+	)
+	req := bytesField(1, bytesField(2, bytesField(2, metric)))
+
+	writer := &mockWriter{}
+	rr := export(NewReceiver(writer), "application/x-protobuf", req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	assert.Equal(t, 1, len(writer.samples))
+	assert.Equal(t, "cpu.utilization", writer.samples[0].Name)
+	assert.Equal(t, 0.75, writer.samples[0].Value)
+	assert.Equal(t, map[string]string{"host": "web01"}, writer.samples[0].Labels)
+	assert.Equal(t, time.Unix(1650843741, 0).UTC(), writer.samples[0].Timestamp)
+}
+
+func TestReceiveProtobufHistogram(t *testing.T) {
+	var counts, bounds []byte
+	for _, c := range []uint64{4, 1} {
+		counts = protowire.AppendFixed64(counts, c)
+	}
+	bounds = protowire.AppendFixed64(bounds, math.Float64bits(0.25))
+	point := message(
+		fixed64Field(3, uint64(time.Unix(1650843741, 0).UnixNano())),
+		fixed64Field(4, 5),
+		fixed64Field(5, math.Float64bits(1.5)),
+		bytesField(6, counts),
+		bytesField(7, bounds),
+	)
+	temporality := protowire.AppendVarint(protowire.AppendTag(nil, 2, protowire.VarintType), 1)
+	metric := message(
+		stringField(1, "latency"),
+		bytesField(9, message(bytesField(1, point), temporality)),
+	)
+	req := bytesField(1, bytesField(2, bytesField(2, metric)))
+
+	writer := &mockWriter{}
+	rr := export(NewReceiver(writer), "application/x-protobuf", req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	assert.Empty(t, writer.samples)
+	if assert.Equal(t, 1, len(writer.dists)) {
+		assert.Equal(t, []float64{0.25}, writer.dists[0].Bounds)
+		assert.Equal(t, []int64{4, 1}, writer.dists[0].Counts)
+		assert.Equal(t, 1.5, writer.dists[0].Sum)
+		assert.Equal(t, int64(5), writer.dists[0].Count)
+	}
+}
+
+func TestReceiveErrors(t *testing.T) {
+	rcv := NewReceiver(&mockWriter{})
+	assert.Equal(t, http.StatusUnsupportedMediaType, export(rcv, "text/plain", []byte("x")).Code)
+	assert.Equal(t, http.StatusBadRequest, export(rcv, "application/json", []byte("{")).Code)
+	assert.Equal(t, http.StatusBadRequest, export(rcv, "application/x-protobuf", []byte{0x0a, 0xff}).Code)
+}
+
+func TestReceiveRejected(t *testing.T) {
+	invalid := errors.New("invalid point: sample queue.depth: value is out of range")
+	cases := []struct {
+		description        string
+		err                error
+		expectedRespStatus int
+		expectedBody       string
+	}{
+		{"partial success", &model.RejectedError{Rejected: 1, Err: invalid}, http.StatusOK,
+			`{"partialSuccess":{"errorMessage":"invalid point: sample queue.depth: value is out of range","rejectedDataPoints":"1"}}`},
+		{"all rejected", &model.RejectedError{Rejected: 3, Err: invalid}, http.StatusBadRequest, ""},
+		{"duplicates", &model.RejectedError{Rejected: 1, Err: fmt.Errorf("%w: sample queue.depth at 2022-04-24 23:42:21 +0000 UTC is already stored", conflict.ErrDuplicate)}, http.StatusOK,
+			`{"partialSuccess":{"errorMessage":"duplicate point: sample queue.depth at 2022-04-24 23:42:21 +0000 UTC is already stored","rejectedDataPoints":"1"}}`},
+		{"unavailable store", fmt.Errorf("error while inserting samples: %w", model.ErrUnavailable), http.StatusServiceUnavailable, ""},
+		{"permanent error", errors.New("document is too large"), http.StatusBadRequest, ""},
+	}
+	for _, c := range cases {
+		rr := export(NewReceiver(&mockWriter{err: c.err}), "application/json", []byte(jsonRequest))
+		assert.Equal(t, c.expectedRespStatus, rr.Code, c.description)
+		if c.expectedBody != "" {
+			assert.Equal(t, c.expectedBody, rr.Body.String(), c.description)
+		}
+	}
+
+	// the protobuf response has the partial_success message in field 1
+	point := message(
+		fixed64Field(3, uint64(time.Unix(1650843741, 0).UnixNano())),
+		fixed64Field(4, math.Float64bits(0.75)),
+	)
+	metric := message(
+		stringField(1, "queue.depth"),
+		bytesField(5, message(bytesField(1, point), bytesField(1, point))),
+	)
+	req := bytesField(1, bytesField(2, bytesField(2, metric)))
+	rr := export(NewReceiver(&mockWriter{err: &model.RejectedError{Rejected: 1, Err: invalid}}), "application/x-protobuf", req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	partial := message(
+		protowire.AppendVarint(protowire.AppendTag(nil, 1, protowire.VarintType), 1),
+		stringField(2, invalid.Error()),
+	)
+	assert.Equal(t, bytesField(1, partial), rr.Body.Bytes())
+}
+
+func TestReceiveDistributionsUnavailable(t *testing.T) {
+	unavailable := fmt.Errorf("error while inserting distributions: %w", model.ErrUnavailable)
+
+	// the samples are saved, so the distributions are reported as rejected instead of failing the request
+	writer := &mockWriter{distErr: unavailable}
+	rr := export(NewReceiver(writer), "application/json", []byte(jsonRequest))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, writer.samples, 2)
+	assert.Equal(t, `{"partialSuccess":{"errorMessage":"error while inserting distributions: store is unavailable","rejectedDataPoints":"1"}}`, rr.Body.String())
+
+	// without saved samples the request can be retried
+	rr = export(NewReceiver(&mockWriter{distErr: unavailable}), "application/json", []byte(`{"resourceMetrics": [{"scopeMetrics": [{"metrics": [
+	  {"name": "latency", "histogram": {"aggregationTemporality": 1, "dataPoints": [{"timeUnixNano": "1650843741000000000", "count": "1", "sum": 0.2, "bucketCounts": ["1", "0"], "explicitBounds": [0.5]}]}}
+	]}]}]}`))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
+
+func export(rcv *Receiver, contentType string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rr := httptest.NewRecorder()
+	rcv.ServeHTTP(rr, req)
+	return rr
+}
+
+func message(fields ...[]byte) []byte {
+	return bytes.Join(fields, nil)
+}
+
+func bytesField(num protowire.Number, v []byte) []byte {
+	b := protowire.AppendTag(nil, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func stringField(num protowire.Number, v string) []byte {
+	return bytesField(num, []byte(v))
+}
+
+func fixed64Field(num protowire.Number, v uint64) []byte {
+	b := protowire.AppendTag(nil, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, v)
+}
+
+type mockWriter struct {
+	samples []model.Sample
+	dists   []model.Distribution
+	// err is returned by InsertSamples, and distErr by InsertDistributions
+	err     error
+	distErr error
+}
+
+func (m *mockWriter) InsertSamples(ctx context.Context, samples []model.Sample) error {
+	m.samples = append(m.samples, samples...)
+	return m.err
+}
+
+func (m *mockWriter) InsertDistributions(ctx context.Context, dists []model.Distribution) error {
+	m.dists = append(m.dists, dists...)
+	return m.distErr
+}
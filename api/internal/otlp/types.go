@@ -0,0 +1,138 @@
+package otlp
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// The types are the subset of the OTLP metrics protocol (opentelemetry/proto/metrics/v1) that is stored:
+// gauges, sums and explicit bucket histograms with their resource and data point attributes. The json tags follow the OTLP/JSON mapping.
+
+type exportRequest struct {
+	ResourceMetrics []resourceMetrics `json:"resourceMetrics"`
+}
+
+type resourceMetrics struct {
+	Resource     resource       `json:"resource"`
+	ScopeMetrics []scopeMetrics `json:"scopeMetrics"`
+	// InstrumentationLibraryMetrics is the name of ScopeMetrics before OTLP 0.15
+	InstrumentationLibraryMetrics []scopeMetrics `json:"instrumentationLibraryMetrics"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeMetrics struct {
+	Metrics []metric `json:"metrics"`
+}
+
+type metric struct {
+	Name      string     `json:"name"`
+	Gauge     *gauge     `json:"gauge"`
+	Sum       *sum       `json:"sum"`
+	Histogram *histogram `json:"histogram"`
+}
+
+type gauge struct {
+	DataPoints []numberDataPoint `json:"dataPoints"`
+}
+
+type sum struct {
+	DataPoints  []numberDataPoint `json:"dataPoints"`
+	IsMonotonic bool              `json:"isMonotonic"`
+}
+
+type numberDataPoint struct {
+	Attributes   []keyValue `json:"attributes"`
+	TimeUnixNano jsonInt    `json:"timeUnixNano"`
+	AsDouble     *float64   `json:"asDouble"`
+	AsInt        *jsonInt   `json:"asInt"`
+}
+
+type histogram struct {
+	DataPoints             []histogramDataPoint `json:"dataPoints"`
+	AggregationTemporality temporality          `json:"aggregationTemporality"`
+}
+
+type histogramDataPoint struct {
+	Attributes     []keyValue `json:"attributes"`
+	TimeUnixNano   jsonInt    `json:"timeUnixNano"`
+	Count          jsonInt    `json:"count"`
+	Sum            float64    `json:"sum"`
+	BucketCounts   []jsonInt  `json:"bucketCounts"`
+	ExplicitBounds []float64  `json:"explicitBounds"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string       `json:"stringValue"`
+	BoolValue   *bool         `json:"boolValue"`
+	IntValue    *jsonInt      `json:"intValue"`
+	DoubleValue *float64      `json:"doubleValue"`
+	ArrayValue  *arrayValue   `json:"arrayValue"`
+	KvlistValue *keyValueList `json:"kvlistValue"`
+	BytesValue  []byte        `json:"bytesValue"`
+}
+
+type arrayValue struct {
+	Values []anyValue `json:"values"`
+}
+
+type keyValueList struct {
+	Values []keyValue `json:"values"`
+}
+
+// jsonInt is a 64 bit integer, that OTLP/JSON writes as a string, but is also accepted as a number
+type jsonInt int64
+
+func (i *jsonInt) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		s = string(b)
+	}
+	// timestamps are unsigned 64 bit values
+	if v, err := strconv.ParseUint(s, 10, 64); err == nil {
+		*i = jsonInt(v)
+		return nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*i = jsonInt(v)
+	return nil
+}
+
+// temporality is the AggregationTemporality enum; OTLP/JSON writes it as a number, but its name is accepted as well
+type temporality int
+
+const (
+	temporalityDelta      temporality = 1
+	temporalityCumulative temporality = 2
+)
+
+func (t *temporality) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		var v int
+		if err := json.Unmarshal(b, &v); err != nil {
+			return err
+		}
+		*t = temporality(v)
+		return nil
+	}
+	switch name {
+	case "AGGREGATION_TEMPORALITY_DELTA":
+		*t = temporalityDelta
+	case "AGGREGATION_TEMPORALITY_CUMULATIVE":
+		*t = temporalityCumulative
+	default:
+		*t = 0
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"sky/api/internal/config"
+	"sky/api/internal/model"
+)
+
+// ErrQueueFull is returned when the metrics don't fit in the queue; the write can be retried later
+var ErrQueueFull = errors.New("write queue is full")
+
+// ErrTooLarge is returned when the metrics are more than the queue holds; unlike ErrQueueFull, the write can't
+// succeed later
+var ErrTooLarge = errors.New("write is larger than the queue")
+
+// maxRetryBackoff limits the wait before a batch that failed on an unavailable store is saved again
+const maxRetryBackoff = time.Minute
+
+// Writer is an interface for saving a batch of metrics
+type Writer interface {
+	InsertMetrics(ctx context.Context, metrics []model.Metric) error
+}
+
+// Pipeline buffers the written metrics in a bounded queue and saves them in batches,
+// when a batch is full or when the flush interval passes
+type Pipeline struct {
+	writer        Writer
+	queueSize     int
+	batchSize     int
+	flushInterval time.Duration
+
+	mu    sync.Mutex
+	queue []model.Metric
+	// inflight is the number of metrics taken from the queue, but not saved yet; they still count against the
+	// queue size, so a slow store pushes back on the writes
+	inflight int
+	// full signals the flushing loop that a batch is ready
+	full chan struct{}
+	// backoff is the wait after a flush that failed on an unavailable store, doubling with each failed flush, and
+	// retryAt is when the flushing loop saves the queue again
+	backoff time.Duration
+	retryAt time.Time
+}
+
+// NewPipeline creates a pipeline saving the metrics with the writer
+func NewPipeline(writer Writer, cfg config.PipelineConfig) *Pipeline {
+	return &Pipeline{
+		writer:        writer,
+		queueSize:     cfg.QueueSize,
+		batchSize:     cfg.BatchSize,
+		flushInterval: time.Duration(cfg.FlushInterval),
+		full:          make(chan struct{}, 1),
+	}
+}
+
+// Write queues the metrics; either all of them are queued, or none and ErrQueueFull is returned, or ErrTooLarge for
+// more metrics than the queue size
+func (p *Pipeline) Write(metrics []model.Metric) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(metrics) > p.queueSize {
+		return ErrTooLarge
+	}
+	if len(p.queue)+p.inflight+len(metrics) > p.queueSize {
+		return ErrQueueFull
+	}
+	p.queue = append(p.queue, metrics...)
+
+	if len(p.queue) >= p.batchSize {
+		select {
+		case p.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Len returns the number of queued metrics, including the ones being saved
+func (p *Pipeline) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.queue) + p.inflight
+}
+
+// Run saves the queued metrics until the context is cancelled; the queue is flushed before returning
+func (p *Pipeline) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// the context of the caller is done, the last flush gets a context of its own
+			flushCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			p.Flush(flushCtx)
+			cancel()
+			return
+		case <-p.full:
+			p.flushUnlessBackingOff(ctx)
+		case <-ticker.C:
+			p.flushUnlessBackingOff(ctx)
+		}
+	}
+}
+
+func (p *Pipeline) flushUnlessBackingOff(ctx context.Context) {
+	p.mu.Lock()
+	retryAt := p.retryAt
+	p.mu.Unlock()
+	if time.Now().Before(retryAt) {
+		return
+	}
+	p.Flush(ctx)
+}
+
+// Flush saves all the queued metrics in batches of the batch size. When a batch fails on an unavailable store, the
+// batch and the rest of the metrics go back to the front of the queue, and the flushing loop waits for a backoff
+// before saving them again. A batch failing for another reason is logged and dropped, as it would fail again, and
+// so are the metrics of a batch rejected by the store.
+func (p *Pipeline) Flush(ctx context.Context) {
+	p.mu.Lock()
+	metrics := p.queue
+	p.queue = nil
+	p.inflight += len(metrics)
+	p.mu.Unlock()
+
+	for start := 0; start < len(metrics); start += p.batchSize {
+		end := start + p.batchSize
+		if end > len(metrics) {
+			end = len(metrics)
+		}
+		err := p.writer.InsertMetrics(ctx, metrics[start:end])
+		var rejected *model.RejectedError
+		switch {
+		case errors.Is(err, model.ErrUnavailable):
+			p.requeue(metrics[start:], err)
+			return
+		case errors.As(err, &rejected):
+			log.Printf("pipeline: saved %d metrics, %s", end-start-rejected.Rejected, err.Error())
+		case err != nil:
+			log.Printf("pipeline: failed to save %d metrics, dropping them: %s", end-start, err.Error())
+		}
+
+		p.mu.Lock()
+		p.inflight -= end - start
+		p.backoff = 0
+		p.mu.Unlock()
+	}
+}
+
+// requeue puts the metrics that were not saved back to the front of the queue, before the metrics written during the
+// flush, and backs off the flushing loop
+func (p *Pipeline) requeue(metrics []model.Metric, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.queue = append(append(make([]model.Metric, 0, len(metrics)+len(p.queue)), metrics...), p.queue...)
+	p.inflight -= len(metrics)
+	p.backoff *= 2
+	if p.backoff == 0 {
+		p.backoff = p.flushInterval
+	}
+	if p.backoff > maxRetryBackoff {
+		p.backoff = maxRetryBackoff
+	}
+	p.retryAt = time.Now().Add(p.backoff)
+	log.Printf("pipeline: failed to save %d metrics, retrying in %s: %s", len(metrics), p.backoff, err.Error())
+}
@@ -0,0 +1,117 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sky/api/internal/config"
+	"sky/api/internal/model"
+)
+
+func TestBatching(t *testing.T) {
+	writer := &mockWriter{}
+	p := NewPipeline(writer, config.PipelineConfig{QueueSize: 10, BatchSize: 4, FlushInterval: config.Duration(time.Hour)})
+
+	assert.Nil(t, p.Write(metrics(6)))
+	assert.Nil(t, p.Write(metrics(4)))
+	assert.Equal(t, ErrQueueFull, p.Write(metrics(1)))
+	assert.Equal(t, 10, p.Len())
+	// a write that never fits is told apart from a full queue
+	assert.Equal(t, ErrTooLarge, p.Write(metrics(11)))
+
+	p.Flush(context.Background())
+	assert.Equal(t, []int{4, 4, 2}, writer.batchSizes())
+	assert.Equal(t, 0, p.Len())
+	assert.Nil(t, p.Write(metrics(1)))
+}
+
+func TestRunFlushesFullBatchesAndOnShutdown(t *testing.T) {
+	writer := &mockWriter{}
+	p := NewPipeline(writer, config.PipelineConfig{QueueSize: 100, BatchSize: 5, FlushInterval: config.Duration(time.Hour)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+
+	// a full batch is saved without waiting for the flush interval
+	assert.Nil(t, p.Write(metrics(5)))
+	assert.Eventually(t, func() bool { return len(writer.batchSizes()) == 1 }, time.Second, 10*time.Millisecond)
+
+	// the rest is saved on shutdown
+	assert.Nil(t, p.Write(metrics(2)))
+	cancel()
+	<-done
+	assert.Equal(t, []int{5, 2}, writer.batchSizes())
+}
+
+func TestFailedBatchesAreDropped(t *testing.T) {
+	writer := &mockWriter{err: errors.New("db is down")}
+	p := NewPipeline(writer, config.PipelineConfig{QueueSize: 10, BatchSize: 10, FlushInterval: config.Duration(time.Hour)})
+
+	assert.Nil(t, p.Write(metrics(10)))
+	p.Flush(context.Background())
+	assert.Equal(t, 0, p.Len())
+}
+
+func TestUnavailableBatchesAreRetried(t *testing.T) {
+	writer := &mockWriter{failures: 1}
+	p := NewPipeline(writer, config.PipelineConfig{QueueSize: 10, BatchSize: 4, FlushInterval: config.Duration(time.Hour)})
+
+	assert.Nil(t, p.Write(metrics(6)))
+	p.Flush(context.Background())
+	// the failed batch and the rest of the queue are kept, and still count against the queue size
+	assert.Equal(t, []int{4}, writer.batchSizes())
+	assert.Equal(t, 6, p.Len())
+	assert.Equal(t, ErrQueueFull, p.Write(metrics(5)))
+	assert.Nil(t, p.Write(metrics(1)))
+
+	// the flushing loop backs off
+	p.flushUnlessBackingOff(context.Background())
+	assert.Equal(t, []int{4}, writer.batchSizes())
+
+	p.Flush(context.Background())
+	assert.Equal(t, []int{4, 4, 3}, writer.batchSizes())
+	assert.Equal(t, 0, p.Len())
+	assert.Equal(t, time.Duration(0), p.backoff)
+}
+
+func metrics(n int) []model.Metric {
+	m := make([]model.Metric, n)
+	for i := range m {
+		m[i] = model.Metric{Timestamp: time.Now(), CPULoad: float64(i)}
+	}
+	return m
+}
+
+type mockWriter struct {
+	mu      sync.Mutex
+	batches []int
+	err     error
+	// failures is the number of writes failing on an unavailable store before err is returned
+	failures int
+}
+
+func (m *mockWriter) InsertMetrics(ctx context.Context, metrics []model.Metric) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batches = append(m.batches, len(metrics))
+	if m.failures > 0 {
+		m.failures--
+		return fmt.Errorf("error while inserting data: %w", model.ErrUnavailable)
+	}
+	return m.err
+}
+
+func (m *mockWriter) batchSizes() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]int(nil), m.batches...)
+}
@@ -0,0 +1,59 @@
+package rules
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Aggregation reduces the values of a window to the recorded value
+type Aggregation func(values []float64) float64
+
+// ParseAggregation returns the aggregation for its name: avg, min, max, sum, count, or a percentile like p95 or p99.9
+func ParseAggregation(name string) (Aggregation, error) {
+	switch name {
+	case "avg":
+		return func(values []float64) float64 { return sum(values) / float64(len(values)) }, nil
+	case "min":
+		return func(values []float64) float64 { return sorted(values)[0] }, nil
+	case "max":
+		return func(values []float64) float64 { return sorted(values)[len(values)-1] }, nil
+	case "sum":
+		return sum, nil
+	case "count":
+		return func(values []float64) float64 { return float64(len(values)) }, nil
+	}
+
+	if strings.HasPrefix(name, "p") {
+		p, err := strconv.ParseFloat(name[1:], 64)
+		if err == nil && p > 0 && p <= 100 {
+			return func(values []float64) float64 { return percentile(sorted(values), p) }, nil
+		}
+	}
+	return nil, fmt.Errorf("aggregation is not valid; expected avg, min, max, sum, count or a percentile like p95, but received %s", name)
+}
+
+func sum(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+func sorted(values []float64) []float64 {
+	s := append([]float64(nil), values...)
+	sort.Float64s(s)
+	return s
+}
+
+// percentile returns the nearest rank percentile of the sorted values
+func percentile(values []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(values))))
+	if rank < 1 {
+		rank = 1
+	}
+	return values[rank-1]
+}
@@ -0,0 +1,144 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"sky/api/internal/config"
+	"sky/api/internal/model"
+)
+
+// Reader is an interface for reading the raw metrics of a window, and the newest recorded sample of a rule
+type Reader interface {
+	GetSeries(ctx context.Context, filter model.Query) ([]model.Metric, error)
+	LastSampleTime(ctx context.Context, name string, labels map[string]string) (time.Time, error)
+}
+
+// Writer is an interface for saving the recorded samples
+type Writer interface {
+	InsertSamples(ctx context.Context, samples []model.Sample) error
+}
+
+// rule is a parsed recording rule with the end of the next window it evaluates
+type rule struct {
+	config.RecordingRule
+	metricType  model.MetricType
+	aggregation Aggregation
+	next        time.Time
+}
+
+// Scheduler evaluates the recording rules over their completed windows and saves the results as samples
+type Scheduler struct {
+	reader   Reader
+	writer   Writer
+	interval time.Duration
+	delay    time.Duration
+	rules    []*rule
+}
+
+// NewScheduler creates a scheduler for the recording rules of the configuration
+func NewScheduler(reader Reader, writer Writer, cfg config.RulesConfig) (*Scheduler, error) {
+	s := &Scheduler{
+		reader:   reader,
+		writer:   writer,
+		interval: time.Duration(cfg.Interval),
+		delay:    time.Duration(cfg.Delay),
+	}
+	for _, r := range cfg.Recording {
+		metricType, err := model.ParseMetricType(r.Metric)
+		if err != nil {
+			return nil, fmt.Errorf("recording rule %s: %w", r.Name, err)
+		}
+		aggregation, err := ParseAggregation(r.Aggregation)
+		if err != nil {
+			return nil, fmt.Errorf("recording rule %s: %w", r.Name, err)
+		}
+		s.rules = append(s.rules, &rule{RecordingRule: r, metricType: metricType, aggregation: aggregation})
+	}
+	return s, nil
+}
+
+// Run evaluates the rules every interval until the context is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.Evaluate(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Evaluate records the windows of the rules that were completed at least the delay before now; the first evaluation
+// of a rule continues after its newest recorded window, or records its last completed window if it has none.
+// A window that failed to be recorded is retried by the next evaluation, unless its sample was rejected.
+func (s *Scheduler) Evaluate(ctx context.Context, now time.Time) {
+	for _, r := range s.rules {
+		step := time.Duration(r.Step)
+		if r.next.IsZero() {
+			last, err := s.reader.LastSampleTime(ctx, r.Name, r.Labels)
+			if err != nil {
+				log.Printf("failed to read the last window of recording rule %s: %s", r.Name, err.Error())
+				continue
+			}
+			if last.IsZero() {
+				r.next = now.Add(-s.delay).Truncate(step)
+			} else {
+				// the sample is at the start of the recorded window, the next window ends a step after it
+				r.next = last.Add(2 * step)
+			}
+		}
+		for !r.next.After(now.Add(-s.delay)) {
+			if err := s.record(ctx, r, r.next.Add(-step), r.next); err != nil {
+				var rejected *model.RejectedError
+				if !errors.As(err, &rejected) {
+					log.Printf("failed to evaluate recording rule %s: %s", r.Name, err.Error())
+					break
+				}
+				// a rejected sample would be rejected again, so the window is not retried
+				log.Printf("recording rule %s: %s", r.Name, err.Error())
+			}
+			r.next = r.next.Add(step)
+		}
+	}
+}
+
+// record saves the aggregation of the [start, end) window as a gauge sample at the start of the window;
+// an empty window is not recorded
+func (s *Scheduler) record(ctx context.Context, r *rule, start, end time.Time) error {
+	metrics, err := s.reader.GetSeries(ctx, model.Query{
+		StartAt:    start,
+		EndAt:      end.Add(-time.Millisecond),
+		MetricType: r.metricType,
+	})
+	if err != nil {
+		return err
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	values := make([]float64, 0, len(metrics))
+	for _, m := range metrics {
+		if r.metricType == model.MetricTypeCPULoad {
+			values = append(values, m.CPULoad)
+		} else {
+			values = append(values, float64(m.Concurrency))
+		}
+	}
+
+	return s.writer.InsertSamples(ctx, []model.Sample{{
+		Timestamp: start,
+		Name:      r.Name,
+		Type:      model.SampleTypeGauge,
+		Labels:    r.Labels,
+		Value:     r.aggregation(values),
+	}})
+}
@@ -0,0 +1,140 @@
+package rules
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sky/api/internal/config"
+	"sky/api/internal/model"
+)
+
+func TestParseAggregation(t *testing.T) {
+	values := []float64{5, 1, 4, 2, 3, 10, 6, 7, 9, 8}
+
+	cases := []struct {
+		name     string
+		expected float64
+	}{
+		{"avg", 5.5},
+		{"min", 1},
+		{"max", 10},
+		{"sum", 55},
+		{"count", 10},
+		{"p50", 5},
+		{"p95", 10},
+		{"p10", 1},
+	}
+	for _, c := range cases {
+		aggregation, err := ParseAggregation(c.name)
+		assert.Nil(t, err, c.name)
+		assert.Equal(t, c.expected, aggregation(values), c.name)
+	}
+
+	for _, name := range []string{"", "median", "p0", "p101", "pxx"} {
+		_, err := ParseAggregation(name)
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	hour := time.Date(2022, 4, 25, 10, 0, 0, 0, time.UTC)
+	reader := &mockReader{}
+	for i := 0; i < 3*60; i++ {
+		reader.metrics = append(reader.metrics, model.Metric{
+			Timestamp:   hour.Add(-time.Hour + time.Duration(i)*time.Minute),
+			CPULoad:     float64(i % 60),
+			Concurrency: int32(i + 1),
+		})
+	}
+	writer := &mockWriter{}
+
+	scheduler, err := NewScheduler(reader, writer, config.RulesConfig{
+		Delay: config.Duration(time.Minute),
+		Recording: []config.RecordingRule{
+			{Name: "cpu_load:max:1h", Metric: "cpu_load", Aggregation: "max", Step: config.Duration(time.Hour)},
+			{Name: "concurrency:count:1h", Metric: "concurrency", Aggregation: "count", Step: config.Duration(time.Hour), Labels: map[string]string{"source": "rules"}},
+		},
+	})
+	assert.Nil(t, err)
+
+	// the first evaluation records the last completed window, 08:00-09:00, which has no metrics; the window that
+	// ended at 10:00 is not evaluated before the delay passes
+	scheduler.Evaluate(context.Background(), hour.Add(30*time.Second))
+	assert.Empty(t, writer.samples)
+
+	scheduler.Evaluate(context.Background(), hour.Add(2*time.Hour+time.Minute))
+	assert.Equal(t, []model.Sample{
+		{Timestamp: hour.Add(-time.Hour), Name: "cpu_load:max:1h", Type: model.SampleTypeGauge, Value: 59},
+		{Timestamp: hour, Name: "cpu_load:max:1h", Type: model.SampleTypeGauge, Value: 59},
+		{Timestamp: hour.Add(time.Hour), Name: "cpu_load:max:1h", Type: model.SampleTypeGauge, Value: 59},
+		{Timestamp: hour.Add(-time.Hour), Name: "concurrency:count:1h", Type: model.SampleTypeGauge, Labels: map[string]string{"source": "rules"}, Value: 60},
+		{Timestamp: hour, Name: "concurrency:count:1h", Type: model.SampleTypeGauge, Labels: map[string]string{"source": "rules"}, Value: 60},
+		{Timestamp: hour.Add(time.Hour), Name: "concurrency:count:1h", Type: model.SampleTypeGauge, Labels: map[string]string{"source": "rules"}, Value: 60},
+	}, writer.samples)
+}
+
+func TestEvaluateResumes(t *testing.T) {
+	hour := time.Date(2022, 4, 25, 10, 0, 0, 0, time.UTC)
+	reader := &mockReader{last: map[string]time.Time{"cpu_load:max:1h": hour.Add(-2 * time.Hour)}}
+	for i := 0; i < 3*60; i++ {
+		reader.metrics = append(reader.metrics, model.Metric{Timestamp: hour.Add(-time.Hour + time.Duration(i)*time.Minute), CPULoad: float64(i%60 + 1)})
+	}
+	// a metric without cpu load is not read as 0
+	reader.metrics = append(reader.metrics, model.Metric{Timestamp: hour.Add(time.Hour), Concurrency: 10})
+	writer := &mockWriter{}
+
+	scheduler, err := NewScheduler(reader, writer, config.RulesConfig{
+		Delay: config.Duration(time.Minute),
+		Recording: []config.RecordingRule{
+			{Name: "cpu_load:min:1h", Metric: "cpu_load", Aggregation: "min", Step: config.Duration(time.Hour)},
+			{Name: "cpu_load:max:1h", Metric: "cpu_load", Aggregation: "max", Step: config.Duration(time.Hour)},
+		},
+	})
+	assert.Nil(t, err)
+
+	// the rule with a recorded window continues after it, the windows missed while stopped included; the new rule
+	// records its last completed window
+	scheduler.Evaluate(context.Background(), hour.Add(2*time.Hour+time.Minute))
+	assert.Equal(t, []model.Sample{
+		{Timestamp: hour.Add(time.Hour), Name: "cpu_load:min:1h", Type: model.SampleTypeGauge, Value: 1},
+		{Timestamp: hour.Add(-time.Hour), Name: "cpu_load:max:1h", Type: model.SampleTypeGauge, Value: 60},
+		{Timestamp: hour, Name: "cpu_load:max:1h", Type: model.SampleTypeGauge, Value: 60},
+		{Timestamp: hour.Add(time.Hour), Name: "cpu_load:max:1h", Type: model.SampleTypeGauge, Value: 60},
+	}, writer.samples)
+}
+
+type mockReader struct {
+	metrics []model.Metric
+	// last is the newest recorded sample of each rule
+	last map[string]time.Time
+}
+
+func (m *mockReader) GetSeries(ctx context.Context, filter model.Query) ([]model.Metric, error) {
+	var series []model.Metric
+	for _, metric := range m.metrics {
+		if metric.Timestamp.Before(filter.StartAt) || metric.Timestamp.After(filter.EndAt) {
+			continue
+		}
+		// the zero values are not stored, like the fields missing from the stored metrics
+		if (filter.MetricType == model.MetricTypeCPULoad && metric.CPULoad == 0) || (filter.MetricType == model.MetricTypeConcurrency && metric.Concurrency == 0) {
+			continue
+		}
+		series = append(series, metric)
+	}
+	return series, nil
+}
+
+func (m *mockReader) LastSampleTime(ctx context.Context, name string, labels map[string]string) (time.Time, error) {
+	return m.last[name], nil
+}
+
+type mockWriter struct {
+	samples []model.Sample
+}
+
+func (m *mockWriter) InsertSamples(ctx context.Context, samples []model.Sample) error {
+	m.samples = append(m.samples, samples...)
+	return nil
+}
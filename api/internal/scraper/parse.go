@@ -0,0 +1,147 @@
+package scraper
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"sky/api/internal/model"
+)
+
+// Parse reads the prometheus text exposition format; samples without a timestamp get the given one
+func Parse(r io.Reader, now time.Time) ([]model.Sample, error) {
+	types := make(map[string]string)
+	var samples []model.Sample
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+
+		sample, err := parseSample(line, now)
+		if err != nil {
+			return nil, fmt.Errorf("line %d is not valid: %w", lineNo, err)
+		}
+		sample.Type = sampleType(types, sample.Name)
+		samples = append(samples, sample)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// sampleType looks up the declared type of a sample; histograms and summaries are declared with the base name
+func sampleType(types map[string]string, name string) string {
+	if t, ok := types[name]; ok {
+		return t
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if t, ok := types[strings.TrimSuffix(name, suffix)]; ok && strings.HasSuffix(name, suffix) {
+			return t
+		}
+	}
+	return model.SampleTypeUntyped
+}
+
+// parseSample parses a line of the form: name{label="value",...} value [timestamp in ms]
+func parseSample(line string, now time.Time) (model.Sample, error) {
+	sample := model.Sample{Timestamp: now}
+
+	nameEnd := strings.IndexAny(line, "{ \t")
+	if nameEnd <= 0 {
+		return sample, fmt.Errorf("metric name or value is missing")
+	}
+	sample.Name = line[:nameEnd]
+	rest := line[nameEnd:]
+
+	if rest[0] == '{' {
+		labels, n, err := parseLabels(rest)
+		if err != nil {
+			return sample, err
+		}
+		sample.Labels = labels
+		rest = rest[n:]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return sample, fmt.Errorf("expected a value and an optional timestamp, received %q", rest)
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return sample, fmt.Errorf("value is not valid: %w", err)
+	}
+	sample.Value = value
+
+	if len(fields) == 2 {
+		ms, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return sample, fmt.Errorf("timestamp is not valid: %w", err)
+		}
+		sample.Timestamp = time.Unix(0, ms*int64(time.Millisecond))
+	}
+	return sample, nil
+}
+
+// parseLabels parses the label set starting with '{' and returns the number of bytes consumed
+func parseLabels(s string) (map[string]string, int, error) {
+	labels := make(map[string]string)
+	i := 1
+	for {
+		for i < len(s) && (s[i] == ' ' || s[i] == ',') {
+			i++
+		}
+		if i >= len(s) {
+			return nil, 0, fmt.Errorf("label set is not closed")
+		}
+		if s[i] == '}' {
+			return labels, i + 1, nil
+		}
+
+		eq := strings.IndexByte(s[i:], '=')
+		if eq <= 0 {
+			return nil, 0, fmt.Errorf("label name is not valid")
+		}
+		name := strings.TrimSpace(s[i : i+eq])
+		i += eq + 1
+		if i >= len(s) || s[i] != '"' {
+			return nil, 0, fmt.Errorf("value of label %s is not quoted", name)
+		}
+		i++
+
+		var value strings.Builder
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(s[i])
+				}
+				continue
+			}
+			value.WriteByte(s[i])
+		}
+		if i >= len(s) {
+			return nil, 0, fmt.Errorf("value of label %s is not closed", name)
+		}
+		i++
+		labels[name] = value.String()
+	}
+}
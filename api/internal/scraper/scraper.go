@@ -0,0 +1,108 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"sky/api/internal/config"
+	"sky/api/internal/model"
+)
+
+// Writer is an interface for saving the scraped samples
+type Writer interface {
+	InsertSamples(ctx context.Context, samples []model.Sample) error
+}
+
+// Scraper periodically fetches the prometheus endpoints of the targets and saves their samples
+type Scraper struct {
+	writer   Writer
+	client   *http.Client
+	interval time.Duration
+	targets  []config.ScrapeTarget
+}
+
+// NewScraper creates a scraper for the targets of the configuration
+func NewScraper(writer Writer, cfg config.ScrapeConfig) *Scraper {
+	return &Scraper{
+		writer:   writer,
+		client:   &http.Client{Timeout: time.Duration(cfg.Timeout)},
+		interval: time.Duration(cfg.Interval),
+		targets:  cfg.Targets,
+	}
+}
+
+// Run scrapes all the targets every interval until the context is cancelled
+func (s *Scraper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.scrapeAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scraper) scrapeAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, target := range s.targets {
+		wg.Add(1)
+		go func(target config.ScrapeTarget) {
+			defer wg.Done()
+			if err := s.Scrape(ctx, target); err != nil {
+				log.Printf("failed to scrape %s: %s", target.URL, err.Error())
+			}
+		}(target)
+	}
+	wg.Wait()
+}
+
+// Scrape fetches and saves the samples of a single target; the samples are labelled with the
+// instance (host of the url) and the labels of the target
+func (s *Scraper) Scrape(ctx context.Context, target config.ScrapeTarget) error {
+	u, err := url.Parse(target.URL)
+	if err != nil {
+		return fmt.Errorf("target url is not valid: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/plain;version=0.0.4")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("target responded with status %d", resp.StatusCode)
+	}
+
+	samples, err := Parse(resp.Body, time.Now())
+	if err != nil {
+		return err
+	}
+	for i := range samples {
+		if samples[i].Labels == nil {
+			samples[i].Labels = make(map[string]string)
+		}
+		if _, ok := samples[i].Labels["instance"]; !ok {
+			samples[i].Labels["instance"] = u.Host
+		}
+		for k, v := range target.Labels {
+			samples[i].Labels[k] = v
+		}
+	}
+
+	return s.writer.InsertSamples(ctx, samples)
+}
@@ -0,0 +1,87 @@
+package scraper
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sky/api/internal/config"
+	"sky/api/internal/model"
+)
+
+const exposition = `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="400"}    3 1395066363000
+
+# TYPE queue_depth gauge
+queue_depth 12.5
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{le="0.5"} 24054
+request_duration_seconds_sum 53423
+escaped{path="C:\\dir\\",msg="say \"hi\""} +Inf
+`
+
+func TestParse(t *testing.T) {
+	now := time.Now()
+	samples, err := Parse(strings.NewReader(exposition), now)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(samples))
+
+	assert.Equal(t, "http_requests_total", samples[0].Name)
+	assert.Equal(t, model.SampleTypeCounter, samples[0].Type)
+	assert.Equal(t, map[string]string{"method": "post", "code": "200"}, samples[0].Labels)
+	assert.Equal(t, 1027.0, samples[0].Value)
+	assert.Equal(t, time.Unix(1395066363, 0), samples[0].Timestamp)
+
+	assert.Equal(t, model.SampleTypeGauge, samples[2].Type)
+	assert.Equal(t, now, samples[2].Timestamp)
+	assert.Equal(t, "histogram", samples[3].Type)
+	assert.Equal(t, "histogram", samples[4].Type)
+	assert.Equal(t, model.SampleTypeUntyped, samples[5].Type)
+	assert.Equal(t, `C:\dir\`, samples[5].Labels["path"])
+	assert.Equal(t, `say "hi"`, samples[5].Labels["msg"])
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, line := range []string{
+		`metric`,
+		`metric{label="x"`,
+		`metric{label=x} 1`,
+		`metric abc`,
+		`metric 1 2 3`,
+	} {
+		_, err := Parse(strings.NewReader(line), time.Now())
+		assert.NotNil(t, err, line)
+	}
+}
+
+func TestScrape(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(exposition))
+	}))
+	defer target.Close()
+
+	writer := &mockWriter{}
+	s := NewScraper(writer, config.ScrapeConfig{Interval: config.Duration(time.Minute), Timeout: config.Duration(time.Second)})
+	err := s.Scrape(context.Background(), config.ScrapeTarget{URL: target.URL + "/metrics", Labels: map[string]string{"job": "test"}})
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(writer.samples))
+	for _, sample := range writer.samples {
+		assert.Equal(t, "test", sample.Labels["job"])
+		assert.Equal(t, strings.TrimPrefix(target.URL, "http://"), sample.Labels["instance"])
+	}
+}
+
+type mockWriter struct {
+	samples []model.Sample
+}
+
+func (m *mockWriter) InsertSamples(ctx context.Context, samples []model.Sample) error {
+	m.samples = append(m.samples, samples...)
+	return nil
+}
@@ -0,0 +1,174 @@
+package statsd
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"sky/api/internal/model"
+)
+
+// aggregator collects the received metrics between two flushes
+type aggregator struct {
+	mu          sync.Mutex
+	percentiles []float64
+
+	counters map[string]*counter
+	gauges   map[string]*gauge
+	timers   map[string]*timer
+	sets     map[string]*set
+}
+
+type series struct {
+	name string
+	tags map[string]string
+}
+
+type counter struct {
+	series
+	value float64
+}
+
+type gauge struct {
+	series
+	value float64
+	// updated is set when the gauge received a value since the last flush
+	updated bool
+}
+
+type timer struct {
+	series
+	values []float64
+	// count is the number of measurements corrected by the sample rates
+	count float64
+}
+
+type set struct {
+	series
+	values map[string]struct{}
+}
+
+func newAggregator(percentiles []float64) *aggregator {
+	return &aggregator{
+		percentiles: percentiles,
+		counters:    make(map[string]*counter),
+		gauges:      make(map[string]*gauge),
+		timers:      make(map[string]*timer),
+		sets:        make(map[string]*set),
+	}
+}
+
+func (a *aggregator) add(m metric) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := model.Sample{Name: m.name, Labels: m.tags}.SeriesID()
+	s := series{name: m.name, tags: m.tags}
+	switch m.typ {
+	case typeCounter:
+		c, ok := a.counters[key]
+		if !ok {
+			c = &counter{series: s}
+			a.counters[key] = c
+		}
+		c.value += m.value / m.rate
+	case typeGauge:
+		g, ok := a.gauges[key]
+		if !ok {
+			g = &gauge{series: s}
+			a.gauges[key] = g
+		}
+		if m.relative {
+			g.value += m.value
+		} else {
+			g.value = m.value
+		}
+		g.updated = true
+	case typeTimer, typeHisto:
+		t, ok := a.timers[key]
+		if !ok {
+			t = &timer{series: s}
+			a.timers[key] = t
+		}
+		t.values = append(t.values, m.value)
+		t.count += 1 / m.rate
+	case typeSet:
+		st, ok := a.sets[key]
+		if !ok {
+			st = &set{series: s, values: make(map[string]struct{})}
+			a.sets[key] = st
+		}
+		st.values[m.raw] = struct{}{}
+	}
+}
+
+// flush returns the aggregated samples and resets the aggregation;
+// gauges keep their value, so relative changes apply to it after the flush. The counts start over at every flush,
+// so they are saved as gauges rather than as cumulative counters.
+func (a *aggregator) flush(now time.Time) []model.Sample {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var samples []model.Sample
+	for _, c := range a.counters {
+		samples = append(samples, newSample(now, c.series, "", model.SampleTypeGauge, c.value))
+	}
+	for _, g := range a.gauges {
+		if g.updated {
+			samples = append(samples, newSample(now, g.series, "", model.SampleTypeGauge, g.value))
+			g.updated = false
+		}
+	}
+	for _, st := range a.sets {
+		samples = append(samples, newSample(now, st.series, "", model.SampleTypeGauge, float64(len(st.values))))
+	}
+	for _, t := range a.timers {
+		samples = append(samples, a.timerSamples(now, t)...)
+	}
+
+	a.counters = make(map[string]*counter)
+	a.timers = make(map[string]*timer)
+	a.sets = make(map[string]*set)
+	return samples
+}
+
+// timerSamples summarises the measurements of a timer into count, sum, mean, lower, upper and upper_<percentile>
+func (a *aggregator) timerSamples(now time.Time, t *timer) []model.Sample {
+	values := t.values
+	sort.Float64s(values)
+
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	samples := []model.Sample{
+		newSample(now, t.series, ".count", model.SampleTypeGauge, t.count),
+		newSample(now, t.series, ".sum", model.SampleTypeGauge, sum),
+		newSample(now, t.series, ".mean", model.SampleTypeGauge, sum/float64(len(values))),
+		newSample(now, t.series, ".lower", model.SampleTypeGauge, values[0]),
+		newSample(now, t.series, ".upper", model.SampleTypeGauge, values[len(values)-1]),
+	}
+	for _, p := range a.percentiles {
+		// nearest rank percentile
+		rank := int(math.Ceil(p / 100 * float64(len(values))))
+		if rank < 1 {
+			rank = 1
+		}
+		suffix := fmt.Sprintf(".upper_%s", strings.Replace(fmt.Sprint(p), ".", "_", 1))
+		samples = append(samples, newSample(now, t.series, suffix, model.SampleTypeGauge, values[rank-1]))
+	}
+	return samples
+}
+
+func newSample(now time.Time, s series, suffix, typ string, value float64) model.Sample {
+	return model.Sample{
+		Timestamp: now,
+		Name:      s.name + suffix,
+		Type:      typ,
+		Labels:    s.tags,
+		Value:     value,
+	}
+}
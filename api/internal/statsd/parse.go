@@ -0,0 +1,114 @@
+package statsd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	typeCounter = "c"
+	typeGauge   = "g"
+	typeTimer   = "ms"
+	typeHisto   = "h"
+	typeSet     = "s"
+)
+
+// metric is a single statsd line: <name>:<value>|<type>[|@<sample rate>][|#<tag>,<tag>:<value>]
+type metric struct {
+	name  string
+	value float64
+	// raw is the unparsed value; sets count the distinct raw values
+	raw  string
+	typ  string
+	rate float64
+	// relative gauges (+/-) change the previous value instead of setting it
+	relative bool
+	tags     map[string]string
+}
+
+// parsePacket parses all the newline separated lines of a packet; invalid lines are returned as errors
+// without stopping the parsing of the rest of the packet
+func parsePacket(packet string) ([]metric, []error) {
+	var metrics []metric
+	var errs []error
+	for _, line := range strings.Split(packet, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		m, err := parseLine(line)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %q is not valid: %w", line, err))
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, errs
+}
+
+func parseLine(line string) (metric, error) {
+	m := metric{rate: 1}
+
+	pipe := strings.IndexByte(line, '|')
+	if pipe < 0 {
+		return m, fmt.Errorf("type is missing")
+	}
+	colon := strings.LastIndexByte(line[:pipe], ':')
+	if colon <= 0 {
+		return m, fmt.Errorf("name or value is missing")
+	}
+	m.name = line[:colon]
+
+	parts := strings.Split(line[colon+1:], "|")
+	m.raw = parts[0]
+	m.typ = parts[1]
+
+	switch m.typ {
+	case typeCounter, typeGauge, typeTimer, typeHisto:
+		if m.typ == typeGauge && (strings.HasPrefix(m.raw, "+") || strings.HasPrefix(m.raw, "-")) {
+			m.relative = true
+		}
+		v, err := strconv.ParseFloat(m.raw, 64)
+		if err != nil {
+			return m, fmt.Errorf("value is not a number: %w", err)
+		}
+		m.value = v
+	case typeSet:
+	default:
+		return m, fmt.Errorf("type %s is not supported", m.typ)
+	}
+
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return m, fmt.Errorf("sample rate %s is not valid", part[1:])
+			}
+			m.rate = rate
+		case strings.HasPrefix(part, "#"):
+			m.tags = parseTags(part[1:])
+		default:
+			return m, fmt.Errorf("section %s is not supported", part)
+		}
+	}
+	return m, nil
+}
+
+// parseTags parses dogstatsd style tags; a tag without a value gets an empty value
+func parseTags(s string) map[string]string {
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(s, ",") {
+		if tag == "" {
+			continue
+		}
+		kv := strings.SplitN(tag, ":", 2)
+		if len(kv) == 2 {
+			tags[kv[0]] = kv[1]
+		} else {
+			tags[kv[0]] = ""
+		}
+	}
+	return tags
+}
@@ -0,0 +1,106 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"sky/api/internal/config"
+	"sky/api/internal/model"
+)
+
+// maxPacketSize is the largest udp payload that is read
+const maxPacketSize = 65535
+
+// Writer is an interface for saving the aggregated samples
+type Writer interface {
+	InsertSamples(ctx context.Context, samples []model.Sample) error
+}
+
+// Listener receives statsd metrics over udp and saves their aggregation every flush interval
+type Listener struct {
+	writer        Writer
+	conn          net.PacketConn
+	flushInterval time.Duration
+	aggregator    *aggregator
+}
+
+// NewListener opens the udp socket of the configured address
+func NewListener(writer Writer, cfg config.StatsDConfig) (*Listener, error) {
+	conn, err := net.ListenPacket("udp", cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{
+		writer:        writer,
+		conn:          conn,
+		flushInterval: time.Duration(cfg.FlushInterval),
+		aggregator:    newAggregator(cfg.Percentiles),
+	}, nil
+}
+
+// Addr returns the address the listener receives the metrics on
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// Run receives and flushes the metrics until the context is cancelled; the pending aggregation is flushed before returning
+func (l *Listener) Run(ctx context.Context) {
+	go l.receive()
+
+	ticker := time.NewTicker(l.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			l.conn.Close()
+			// the context of the caller is done, the last flush gets a context of its own
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			l.flush(flushCtx, time.Now())
+			cancel()
+			return
+		case now := <-ticker.C:
+			l.flush(ctx, now)
+		}
+	}
+}
+
+func (l *Listener) receive() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := l.conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			// the connection is closed on shutdown
+			return
+		}
+		if err != nil {
+			log.Printf("statsd: failed to read packet: %s", err.Error())
+			continue
+		}
+
+		metrics, errs := parsePacket(string(buf[:n]))
+		for _, err := range errs {
+			log.Printf("statsd: %s", err.Error())
+		}
+		for _, m := range metrics {
+			l.aggregator.add(m)
+		}
+	}
+}
+
+func (l *Listener) flush(ctx context.Context, now time.Time) {
+	samples := l.aggregator.flush(now)
+	if len(samples) == 0 {
+		return
+	}
+	err := l.writer.InsertSamples(ctx, samples)
+	var rejected *model.RejectedError
+	switch {
+	case errors.As(err, &rejected):
+		log.Printf("statsd: saved %d samples, %s", len(samples)-rejected.Rejected, err.Error())
+	case err != nil:
+		log.Printf("statsd: failed to save %d samples: %s", len(samples), err.Error())
+	}
+}
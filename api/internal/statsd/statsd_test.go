@@ -0,0 +1,108 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sky/api/internal/config"
+	"sky/api/internal/model"
+)
+
+func TestParsePacket(t *testing.T) {
+	metrics, errs := parsePacket("requests:1|c|@0.5|#env:prod,canary\nqueue:-3|g\nlatency:320|ms\nusers:alice|s\nbroken|c\nbad:1|x")
+	assert.Equal(t, 2, len(errs))
+	assert.Equal(t, 4, len(metrics))
+
+	assert.Equal(t, "requests", metrics[0].name)
+	assert.Equal(t, 0.5, metrics[0].rate)
+	assert.Equal(t, map[string]string{"env": "prod", "canary": ""}, metrics[0].tags)
+	assert.True(t, metrics[1].relative)
+	assert.Equal(t, -3.0, metrics[1].value)
+	assert.Equal(t, "alice", metrics[3].raw)
+}
+
+func TestAggregatorFlush(t *testing.T) {
+	a := newAggregator([]float64{90})
+	metrics, errs := parsePacket("hits:2|c\nhits:1|c|@0.1\ntemp:20|g\ntemp:+5|g\nusers:a|s\nusers:b|s\nusers:a|s")
+	assert.Empty(t, errs)
+	for i := 1; i <= 10; i++ {
+		metrics = append(metrics, metric{name: "latency", typ: typeTimer, value: float64(i), rate: 1})
+	}
+	for _, m := range metrics {
+		a.add(m)
+	}
+
+	samples := byName(a.flush(time.Now()))
+	assert.Equal(t, 12.0, samples["hits"].Value)
+	assert.Equal(t, model.SampleTypeGauge, samples["hits"].Type)
+	assert.Equal(t, 25.0, samples["temp"].Value)
+	assert.Equal(t, 2.0, samples["users"].Value)
+	assert.Equal(t, 10.0, samples["latency.count"].Value)
+	assert.Equal(t, 5.5, samples["latency.mean"].Value)
+	assert.Equal(t, 1.0, samples["latency.lower"].Value)
+	assert.Equal(t, 10.0, samples["latency.upper"].Value)
+	assert.Equal(t, 9.0, samples["latency.upper_90"].Value)
+
+	// gauges keep their value for relative changes, counters start over
+	a.add(metric{name: "temp", typ: typeGauge, value: -5, relative: true, rate: 1})
+	samples = byName(a.flush(time.Now()))
+	assert.Equal(t, 1, len(samples))
+	assert.Equal(t, 20.0, samples["temp"].Value)
+}
+
+func TestListener(t *testing.T) {
+	writer := &mockWriter{}
+	l, err := NewListener(writer, config.StatsDConfig{Address: "127.0.0.1:0", FlushInterval: config.Duration(time.Hour)})
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		l.Run(ctx)
+		close(done)
+	}()
+
+	conn, err := net.Dial("udp", l.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hits:3|c"))
+	assert.Nil(t, err)
+
+	// shutting down flushes the pending aggregation
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	<-done
+
+	samples := byName(writer.get())
+	assert.Equal(t, 3.0, samples["hits"].Value)
+}
+
+func byName(samples []model.Sample) map[string]model.Sample {
+	m := make(map[string]model.Sample)
+	for _, s := range samples {
+		m[s.Name] = s
+	}
+	return m
+}
+
+type mockWriter struct {
+	mu      sync.Mutex
+	samples []model.Sample
+}
+
+func (m *mockWriter) InsertSamples(ctx context.Context, samples []model.Sample) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.samples = append(m.samples, samples...)
+	return nil
+}
+
+func (m *mockWriter) get() []model.Sample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.samples
+}
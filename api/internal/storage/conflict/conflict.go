@@ -0,0 +1,187 @@
+package conflict
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"sky/api/internal/model"
+)
+
+// Policy decides what happens when a written point has the same timestamp and series as a stored or another
+// written point
+type Policy string
+
+const (
+	// PolicyAppend saves every point, duplicates included; this is the default
+	PolicyAppend Policy = "append"
+	// PolicyReject drops the duplicated points and reports them; the rest of the write is saved
+	PolicyReject Policy = "reject"
+	// PolicyOverwrite replaces the stored point with the written one
+	PolicyOverwrite Policy = "overwrite"
+	// PolicyKeepFirst keeps the stored point and drops the written one
+	PolicyKeepFirst Policy = "keep-first"
+	// PolicyMerge combines the two points; the set fields of the written point replace the fields of the stored one
+	PolicyMerge Policy = "merge"
+)
+
+// ErrDuplicate is wrapped by the model.RejectedError of PolicyReject for the duplicated points
+var ErrDuplicate = errors.New("duplicate point")
+
+// ParsePolicy returns the policy for its name; an empty name is PolicyAppend
+func ParsePolicy(name string) (Policy, error) {
+	switch p := Policy(name); p {
+	case "":
+		return PolicyAppend, nil
+	case PolicyAppend, PolicyReject, PolicyOverwrite, PolicyKeepFirst, PolicyMerge:
+		return p, nil
+	default:
+		return "", fmt.Errorf("conflict policy is not valid; expected append, reject, overwrite, keep-first or merge, but received %s", name)
+	}
+}
+
+// timeKey is the timestamp as it is stored; mongo keeps millisecond precision
+func timeKey(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// ResolveMetrics returns the metrics of the batch to insert, and the timestamps of the stored metrics that have
+// to be removed before the insert. The stored metrics are the ones with the same timestamps as the batch.
+// With PolicyReject the duplicates are left out of the insert and returned in a *model.RejectedError.
+func ResolveMetrics(policy Policy, batch, stored []model.Metric) ([]model.Metric, []time.Time, error) {
+	if policy == PolicyAppend {
+		return batch, nil, nil
+	}
+
+	// duplicates within the batch are resolved first, keeping the order of the batch
+	var unique []model.Metric
+	var duplicates []string
+	index := make(map[int64]int)
+	for _, m := range batch {
+		key := timeKey(m.Timestamp)
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(unique)
+			unique = append(unique, m)
+			continue
+		}
+		switch policy {
+		case PolicyReject:
+			duplicates = append(duplicates, fmt.Sprintf("metric at %s is written twice", m.Timestamp.UTC()))
+		case PolicyOverwrite:
+			unique[i] = m
+		case PolicyMerge:
+			unique[i] = mergeMetric(unique[i], m)
+		}
+	}
+
+	storedByKey := make(map[int64]model.Metric, len(stored))
+	for _, m := range stored {
+		storedByKey[timeKey(m.Timestamp)] = m
+	}
+
+	var insert []model.Metric
+	var remove []time.Time
+	for _, m := range unique {
+		s, ok := storedByKey[timeKey(m.Timestamp)]
+		if !ok {
+			insert = append(insert, m)
+			continue
+		}
+		switch policy {
+		case PolicyReject:
+			duplicates = append(duplicates, fmt.Sprintf("metric at %s is already stored", m.Timestamp.UTC()))
+		case PolicyKeepFirst:
+		case PolicyOverwrite:
+			insert = append(insert, m)
+			remove = append(remove, s.Timestamp)
+		case PolicyMerge:
+			insert = append(insert, mergeMetric(s, m))
+			remove = append(remove, s.Timestamp)
+		}
+	}
+	return insert, remove, rejected(duplicates)
+}
+
+// mergeMetric returns the first metric with the set fields of the second
+func mergeMetric(first, second model.Metric) model.Metric {
+	merged := first
+	merged.Timestamp = second.Timestamp
+	if second.CPULoad != 0 {
+		merged.CPULoad = second.CPULoad
+	}
+	if second.Concurrency != 0 {
+		merged.Concurrency = second.Concurrency
+	}
+	return merged
+}
+
+// ResolveSamples returns the samples of the batch to insert, and the stored samples that have to be removed before
+// the insert. A sample has a single value, so merging it is the same as overwriting it. Like with ResolveMetrics,
+// the duplicates rejected by PolicyReject are returned in a *model.RejectedError.
+func ResolveSamples(policy Policy, batch, stored []model.Sample) ([]model.Sample, []model.Sample, error) {
+	if policy == PolicyAppend {
+		return batch, nil, nil
+	}
+
+	type key struct {
+		series string
+		time   int64
+	}
+
+	var unique []model.Sample
+	var duplicates []string
+	index := make(map[key]int)
+	for _, s := range batch {
+		k := key{s.SeriesID(), timeKey(s.Timestamp)}
+		i, ok := index[k]
+		if !ok {
+			index[k] = len(unique)
+			unique = append(unique, s)
+			continue
+		}
+		switch policy {
+		case PolicyReject:
+			duplicates = append(duplicates, fmt.Sprintf("sample %s at %s is written twice", k.series, s.Timestamp.UTC()))
+		case PolicyOverwrite, PolicyMerge:
+			unique[i] = s
+		}
+	}
+
+	storedByKey := make(map[key]model.Sample, len(stored))
+	for _, s := range stored {
+		storedByKey[key{s.SeriesID(), timeKey(s.Timestamp)}] = s
+	}
+
+	var insert, remove []model.Sample
+	for _, s := range unique {
+		k := key{s.SeriesID(), timeKey(s.Timestamp)}
+		existing, ok := storedByKey[k]
+		if !ok {
+			insert = append(insert, s)
+			continue
+		}
+		switch policy {
+		case PolicyReject:
+			duplicates = append(duplicates, fmt.Sprintf("sample %s at %s is already stored", k.series, s.Timestamp.UTC()))
+		case PolicyKeepFirst:
+		case PolicyOverwrite, PolicyMerge:
+			insert = append(insert, s)
+			remove = append(remove, existing)
+		}
+	}
+	return insert, remove, rejected(duplicates)
+}
+
+// rejected returns the duplicates rejected by PolicyReject as a *model.RejectedError naming the first of them,
+// or nil if there are none
+func rejected(duplicates []string) error {
+	if len(duplicates) == 0 {
+		return nil
+	}
+	msg := duplicates[0]
+	if len(duplicates) > 1 {
+		msg += fmt.Sprintf(" and %d more", len(duplicates)-1)
+	}
+	return &model.RejectedError{Rejected: len(duplicates), Err: fmt.Errorf("%w: %s", ErrDuplicate, msg)}
+}
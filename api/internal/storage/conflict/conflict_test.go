@@ -0,0 +1,94 @@
+package conflict
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sky/api/internal/model"
+)
+
+func TestResolveMetrics(t *testing.T) {
+	t0 := time.Unix(1650843700, 0)
+	t1 := t0.Add(time.Minute)
+	t2 := t0.Add(2 * time.Minute)
+	stored := []model.Metric{{Timestamp: t0, CPULoad: 10, Concurrency: 100}}
+	batch := []model.Metric{
+		{Timestamp: t0, CPULoad: 20},
+		{Timestamp: t1, CPULoad: 30},
+		{Timestamp: t1, Concurrency: 300},
+		{Timestamp: t2, CPULoad: 40},
+	}
+
+	cases := []struct {
+		policy         Policy
+		expectedInsert []model.Metric
+		expectedRemove []time.Time
+		expectedErr    error
+	}{
+		{PolicyAppend, batch, nil, nil},
+		// the duplicates are rejected, the first point of t1 is kept
+		{PolicyReject, []model.Metric{{Timestamp: t1, CPULoad: 30}, {Timestamp: t2, CPULoad: 40}}, nil, ErrDuplicate},
+		{
+			PolicyKeepFirst,
+			[]model.Metric{{Timestamp: t1, CPULoad: 30}, {Timestamp: t2, CPULoad: 40}},
+			nil, nil,
+		},
+		{
+			PolicyOverwrite,
+			[]model.Metric{{Timestamp: t0, CPULoad: 20}, {Timestamp: t1, Concurrency: 300}, {Timestamp: t2, CPULoad: 40}},
+			[]time.Time{t0}, nil,
+		},
+		{
+			PolicyMerge,
+			[]model.Metric{{Timestamp: t0, CPULoad: 20, Concurrency: 100}, {Timestamp: t1, CPULoad: 30, Concurrency: 300}, {Timestamp: t2, CPULoad: 40}},
+			[]time.Time{t0}, nil,
+		},
+	}
+
+	for _, c := range cases {
+		insert, remove, err := ResolveMetrics(c.policy, batch, stored)
+		assert.True(t, errors.Is(err, c.expectedErr), string(c.policy))
+		assert.Equal(t, c.expectedInsert, insert, string(c.policy))
+		assert.Equal(t, c.expectedRemove, remove, string(c.policy))
+	}
+}
+
+func TestResolveSamples(t *testing.T) {
+	t0 := time.Unix(1650843700, 0)
+	stored := []model.Sample{{Timestamp: t0, Name: "requests", Labels: map[string]string{"code": "200"}, Value: 1}}
+	batch := []model.Sample{
+		// the same series, the timestamp only differs below the stored millisecond precision
+		{Timestamp: t0.Add(time.Microsecond), Name: "requests", Labels: map[string]string{"code": "200"}, Value: 2},
+		{Timestamp: t0, Name: "requests", Labels: map[string]string{"code": "500"}, Value: 3},
+	}
+
+	insert, remove, err := ResolveSamples(PolicyKeepFirst, batch, stored)
+	assert.Nil(t, err)
+	assert.Equal(t, batch[1:], insert)
+	assert.Empty(t, remove)
+
+	insert, remove, err = ResolveSamples(PolicyOverwrite, batch, stored)
+	assert.Nil(t, err)
+	assert.Equal(t, batch, insert)
+	assert.Equal(t, stored, remove)
+
+	insert, remove, err = ResolveSamples(PolicyReject, batch, stored)
+	assert.True(t, errors.Is(err, ErrDuplicate))
+	var rejected *model.RejectedError
+	if assert.ErrorAs(t, err, &rejected) {
+		assert.Equal(t, 1, rejected.Rejected)
+	}
+	assert.Equal(t, batch[1:], insert)
+	assert.Empty(t, remove)
+}
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy("")
+	assert.Nil(t, err)
+	assert.Equal(t, PolicyAppend, p)
+
+	_, err = ParsePolicy("ignore")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,167 @@
+package histogram
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"sky/api/internal/model"
+)
+
+// maxBuckets limits the number of buckets of a histogram
+const maxBuckets = 1000
+
+// Validate checks the spec of a histogram before the values are read
+func Validate(spec model.HistogramSpec) error {
+	if len(spec.Boundaries) > 0 {
+		if len(spec.Boundaries) < 2 || len(spec.Boundaries) > maxBuckets+1 {
+			return fmt.Errorf("boundaries have to describe 1 to %d buckets", maxBuckets)
+		}
+		for i := 1; i < len(spec.Boundaries); i++ {
+			if spec.Boundaries[i] <= spec.Boundaries[i-1] {
+				return fmt.Errorf("boundaries have to be increasing")
+			}
+		}
+		return nil
+	}
+
+	if spec.Count < 1 || spec.Count > maxBuckets {
+		return fmt.Errorf("number of buckets has to be from 1 to %d", maxBuckets)
+	}
+	switch spec.Scale {
+	case model.HistogramScaleLinear, model.HistogramScaleLog:
+	case model.HistogramScaleAuto:
+		if spec.Min != nil || spec.Max != nil {
+			return fmt.Errorf("the auto scale chooses the range of the buckets, it can't be set")
+		}
+	default:
+		return fmt.Errorf("scale is not valid; expected linear, log or auto, but received %s", spec.Scale)
+	}
+	if spec.Min != nil && spec.Max != nil && *spec.Min >= *spec.Max {
+		return fmt.Errorf("min has to be smaller than max")
+	}
+	if spec.Scale == model.HistogramScaleLog && spec.Min != nil && *spec.Min <= 0 {
+		return fmt.Errorf("the log scale needs a positive min")
+	}
+	return nil
+}
+
+// NeedsRange reports whether the boundaries of the spec depend on the smallest or largest value
+func NeedsRange(spec model.HistogramSpec) bool {
+	return len(spec.Boundaries) == 0 && spec.Scale != model.HistogramScaleAuto && (spec.Min == nil || spec.Max == nil)
+}
+
+// Boundaries returns the boundaries of the buckets of a linear or log spec; the range that is not set in the spec
+// is the range of the values, from min to max. The last boundary of a range taken from the values is raised just
+// above the largest value, so the value falls into the last bucket; raised reports it.
+func Boundaries(spec model.HistogramSpec, min, max float64) (bounds []float64, raised bool, err error) {
+	if len(spec.Boundaries) > 0 {
+		return spec.Boundaries, false, nil
+	}
+	if spec.Min != nil {
+		min = *spec.Min
+	}
+	raised = spec.Max == nil
+	if !raised {
+		max = *spec.Max
+	}
+	if spec.Scale == model.HistogramScaleLog && min <= 0 {
+		return nil, false, fmt.Errorf("the log scale needs positive values, but the smallest is %v", min)
+	}
+
+	count := spec.Count
+	if max <= min {
+		// all the values are the same
+		count = 1
+		max = min
+		raised = true
+	}
+	bounds = make([]float64, count+1)
+	for i := range bounds {
+		f := float64(i) / float64(count)
+		if spec.Scale == model.HistogramScaleLog {
+			bounds[i] = min * math.Pow(max/min, f)
+		} else {
+			bounds[i] = min + (max-min)*f
+		}
+	}
+	bounds[0], bounds[count] = min, max
+	if raised {
+		bounds[count] = math.Nextafter(max, math.Inf(1))
+	}
+	return bounds, raised, nil
+}
+
+// Build returns the histogram of the counts of the buckets between the boundaries; a raised last boundary is
+// reported as the largest value it was raised from
+func Build(bounds []float64, raised bool, counts []int64, outside int64) *model.Histogram {
+	h := &model.Histogram{Buckets: make([]model.HistogramBucket, 0, len(bounds)-1), Outside: outside}
+	for i := 0; i+1 < len(bounds); i++ {
+		h.Buckets = append(h.Buckets, model.HistogramBucket{Lower: bounds[i], Upper: bounds[i+1], Count: counts[i]})
+	}
+	if raised {
+		last := &h.Buckets[len(h.Buckets)-1]
+		last.Upper = math.Nextafter(last.Upper, math.Inf(-1))
+	}
+	return h
+}
+
+// Compute returns the histogram of the values; it is the equivalent of the $bucket and $bucketAuto aggregations
+func Compute(values []float64, spec model.HistogramSpec) (*model.Histogram, error) {
+	if err := Validate(spec); err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return &model.Histogram{Buckets: make([]model.HistogramBucket, 0)}, nil
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	if len(spec.Boundaries) == 0 && spec.Scale == model.HistogramScaleAuto {
+		return autoBuckets(sorted, spec.Count), nil
+	}
+
+	bounds, raised, err := Boundaries(spec, sorted[0], sorted[len(sorted)-1])
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]int64, len(bounds)-1)
+	var outside int64
+	for _, v := range sorted {
+		i := sort.SearchFloat64s(bounds, v)
+		// the bucket starting at the value, or the one before the first larger boundary
+		if i == len(bounds) || bounds[i] != v {
+			i--
+		}
+		if i < 0 || i >= len(counts) {
+			outside++
+			continue
+		}
+		counts[i]++
+	}
+	return Build(bounds, raised, counts, outside), nil
+}
+
+// autoBuckets splits the sorted values into the number of buckets of about the same size, keeping the equal values
+// in the same bucket like $bucketAuto does; the upper boundary of a bucket is the lower boundary of the next one,
+// and the largest value for the last bucket
+func autoBuckets(sorted []float64, count int) *model.Histogram {
+	size := (len(sorted) + count - 1) / count
+	h := &model.Histogram{Buckets: make([]model.HistogramBucket, 0, count)}
+	for start := 0; start < len(sorted); {
+		end := start + size
+		if end > len(sorted) {
+			end = len(sorted)
+		}
+		for end < len(sorted) && sorted[end] == sorted[end-1] {
+			end++
+		}
+		upper := sorted[len(sorted)-1]
+		if end < len(sorted) {
+			upper = sorted[end]
+		}
+		h.Buckets = append(h.Buckets, model.HistogramBucket{Lower: sorted[start], Upper: upper, Count: int64(end - start)})
+		start = end
+	}
+	return h
+}
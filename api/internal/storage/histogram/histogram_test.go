@@ -0,0 +1,97 @@
+package histogram
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sky/api/internal/model"
+)
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestCompute(t *testing.T) {
+	values := []float64{1, 2, 2, 3, 5, 8, 13, 21, 34, 55, 89, 100}
+
+	cases := []struct {
+		description string
+		spec        model.HistogramSpec
+		expected    *model.Histogram
+	}{
+		{
+			"linear over the range of the values",
+			model.HistogramSpec{Count: 3, Scale: model.HistogramScaleLinear},
+			&model.Histogram{Buckets: []model.HistogramBucket{
+				{Lower: 1, Upper: 34, Count: 8},
+				{Lower: 34, Upper: 67, Count: 2},
+				{Lower: 67, Upper: 100, Count: 2},
+			}},
+		},
+		{
+			"linear over a given range",
+			model.HistogramSpec{Count: 2, Scale: model.HistogramScaleLinear, Min: float(0), Max: float(50)},
+			&model.Histogram{Buckets: []model.HistogramBucket{
+				{Lower: 0, Upper: 25, Count: 8},
+				{Lower: 25, Upper: 50, Count: 1},
+			}, Outside: 3},
+		},
+		{
+			"log",
+			model.HistogramSpec{Count: 2, Scale: model.HistogramScaleLog, Min: float(1), Max: float(100)},
+			&model.Histogram{Buckets: []model.HistogramBucket{
+				{Lower: 1, Upper: 10, Count: 6},
+				{Lower: 10, Upper: 100, Count: 5},
+			}, Outside: 1},
+		},
+		{
+			"explicit boundaries",
+			model.HistogramSpec{Boundaries: []float64{0, 10, 50}},
+			&model.Histogram{Buckets: []model.HistogramBucket{
+				{Lower: 0, Upper: 10, Count: 6},
+				{Lower: 10, Upper: 50, Count: 3},
+			}, Outside: 3},
+		},
+		{
+			"auto",
+			model.HistogramSpec{Count: 4, Scale: model.HistogramScaleAuto},
+			&model.Histogram{Buckets: []model.HistogramBucket{
+				// the equal values stay in the same bucket
+				{Lower: 1, Upper: 3, Count: 3},
+				{Lower: 3, Upper: 13, Count: 3},
+				{Lower: 13, Upper: 55, Count: 3},
+				{Lower: 55, Upper: 100, Count: 3},
+			}},
+		},
+	}
+
+	for _, c := range cases {
+		h, err := Compute(values, c.spec)
+		assert.Nil(t, err, c.description)
+		assert.Equal(t, c.expected, h, c.description)
+	}
+}
+
+func TestComputeConstantValues(t *testing.T) {
+	h, err := Compute([]float64{7, 7, 7}, model.HistogramSpec{Count: 5, Scale: model.HistogramScaleLinear})
+	assert.Nil(t, err)
+	assert.Equal(t, &model.Histogram{Buckets: []model.HistogramBucket{{Lower: 7, Upper: 7, Count: 3}}}, h)
+}
+
+func TestValidate(t *testing.T) {
+	invalid := []model.HistogramSpec{
+		{Boundaries: []float64{1}},
+		{Boundaries: []float64{1, 5, 3}},
+		{Count: 0, Scale: model.HistogramScaleLinear},
+		{Count: 5, Scale: "square"},
+		{Count: 5, Scale: model.HistogramScaleLinear, Min: float(10), Max: float(1)},
+		{Count: 5, Scale: model.HistogramScaleLog, Min: float(0)},
+		{Count: 5, Scale: model.HistogramScaleAuto, Max: float(10)},
+	}
+	for _, spec := range invalid {
+		assert.NotNil(t, Validate(spec), "%+v", spec)
+	}
+
+	_, err := Compute([]float64{0, 1}, model.HistogramSpec{Count: 2, Scale: model.HistogramScaleLog})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,175 @@
+package late
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"sky/api/internal/model"
+)
+
+// Policy decides what happens to a point that is older than the newest point of its series by more than the tolerance
+type Policy string
+
+const (
+	// PolicyAccept saves the late points like any other point; this is the default
+	PolicyAccept Policy = "accept"
+	// PolicyRoute saves the late points separately, in the late data collections
+	PolicyRoute Policy = "route"
+	// PolicyReject rejects the late points and saves the rest of the write
+	PolicyReject Policy = "reject"
+)
+
+// ErrLate is returned by PolicyReject for late points
+var ErrLate = errors.New("late point")
+
+// ParsePolicy returns the policy for its name; an empty name is PolicyAccept
+func ParsePolicy(name string) (Policy, error) {
+	switch p := Policy(name); p {
+	case "":
+		return PolicyAccept, nil
+	case PolicyAccept, PolicyRoute, PolicyReject:
+		return p, nil
+	default:
+		return "", fmt.Errorf("late policy is not valid; expected accept, route or reject, but received %s", name)
+	}
+}
+
+// Point is the series and timestamp of a written point
+type Point struct {
+	Series    string
+	Timestamp time.Time
+}
+
+// minIdle is the least time a series is kept without writes, so the series written more often aren't evicted and
+// seeded again on every write
+const minIdle = time.Minute
+
+// Tracker keeps the newest timestamp of every written series, to tell the late points apart; the series that are
+// not written within the tolerance are evicted, and seeded again from the store when they are written
+type Tracker struct {
+	policy    Policy
+	tolerance time.Duration
+	now       func() time.Time
+
+	mu     sync.Mutex
+	newest map[string]time.Time
+	// seen is when each series was last seeded or written, and swept when the idle series were last evicted
+	seen  map[string]time.Time
+	swept time.Time
+}
+
+// NewTracker creates a tracker; a point is late if it is older than the newest point of its series minus the tolerance
+func NewTracker(policy Policy, tolerance time.Duration) *Tracker {
+	return &Tracker{
+		policy:    policy,
+		tolerance: tolerance,
+		now:       time.Now,
+		newest:    make(map[string]time.Time),
+		seen:      make(map[string]time.Time),
+	}
+}
+
+// Policy returns the policy of the tracker
+func (t *Tracker) Policy() Policy {
+	return t.policy
+}
+
+// Unknown returns the series of the points whose newest timestamp is not tracked yet; they have to be seeded
+// from the store before splitting the points
+func (t *Tracker) Unknown(points []Point) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var unknown []string
+	seen := make(map[string]bool)
+	for _, p := range points {
+		if _, ok := t.newest[p.Series]; !ok && !seen[p.Series] {
+			seen[p.Series] = true
+			unknown = append(unknown, p.Series)
+		}
+	}
+	return unknown
+}
+
+// Seed sets the newest stored timestamp of a series; a zero time means the series has no stored points
+func (t *Tracker) Seed(series string, newest time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if current, ok := t.newest[series]; !ok || newest.After(current) {
+		t.newest[series] = newest
+	}
+	t.seen[series] = t.now()
+}
+
+// Split returns the indexes of the on time and of the late points, without moving the tracked timestamps; they are
+// moved by Advance once the points are saved. With PolicyAccept every point is on time; with PolicyReject the late
+// points are in neither of the indexes, but in the returned *model.RejectedError wrapping ErrLate.
+func (t *Tracker) Split(points []Point) (onTime []int, late []int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var rejected []string
+	newest := make(map[string]time.Time)
+	for i, p := range points {
+		latest, ok := newest[p.Series]
+		if !ok {
+			latest = t.newest[p.Series]
+		}
+		if t.policy != PolicyAccept && !latest.IsZero() && p.Timestamp.Before(latest.Add(-t.tolerance)) {
+			if t.policy == PolicyReject {
+				rejected = append(rejected, fmt.Sprintf("%s at %s is older than the accepted %s, %s before its newest point at %s",
+					p.Series, p.Timestamp.UTC(), latest.Add(-t.tolerance).UTC(), t.tolerance, latest.UTC()))
+				continue
+			}
+			late = append(late, i)
+			continue
+		}
+		onTime = append(onTime, i)
+		if p.Timestamp.After(latest) {
+			newest[p.Series] = p.Timestamp
+		} else {
+			newest[p.Series] = latest
+		}
+	}
+
+	if len(rejected) > 0 {
+		msg := rejected[0]
+		if len(rejected) > 1 {
+			msg += fmt.Sprintf(" and %d more", len(rejected)-1)
+		}
+		err = &model.RejectedError{Rejected: len(rejected), Err: fmt.Errorf("%w: %s", ErrLate, msg)}
+	}
+	return onTime, late, err
+}
+
+// Advance moves the newest timestamps forward with the saved points, and evicts the idle series
+func (t *Tracker) Advance(points []Point) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	for _, p := range points {
+		if latest, ok := t.newest[p.Series]; !ok || p.Timestamp.After(latest) {
+			t.newest[p.Series] = p.Timestamp
+		}
+		t.seen[p.Series] = now
+	}
+
+	idle := t.tolerance
+	if idle < minIdle {
+		idle = minIdle
+	}
+	if now.Sub(t.swept) < idle {
+		return
+	}
+	for series, seen := range t.seen {
+		if now.Sub(seen) > idle {
+			delete(t.newest, series)
+			delete(t.seen, series)
+		}
+	}
+	t.swept = now
+}
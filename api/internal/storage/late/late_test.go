@@ -0,0 +1,101 @@
+package late
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sky/api/internal/model"
+)
+
+func TestSplit(t *testing.T) {
+	newest := time.Unix(1650843741, 0)
+	points := []Point{
+		{"cpu", newest.Add(-30 * time.Minute)},
+		{"cpu", newest.Add(-3 * time.Hour)},
+		{"cpu", newest.Add(time.Hour)},
+		// late compared to the point before it in the same write
+		{"cpu", newest.Add(-time.Minute)},
+		{"new", newest.Add(-24 * time.Hour)},
+	}
+
+	cases := []struct {
+		policy         Policy
+		expectedOnTime []int
+		expectedLate   []int
+		expectedErr    error
+	}{
+		{PolicyAccept, []int{0, 1, 2, 3, 4}, nil, nil},
+		{PolicyRoute, []int{0, 2, 4}, []int{1, 3}, nil},
+		{PolicyReject, []int{0, 2, 4}, nil, ErrLate},
+	}
+
+	for _, c := range cases {
+		tracker := NewTracker(c.policy, time.Hour)
+		assert.Equal(t, []string{"cpu", "new"}, tracker.Unknown(points))
+		tracker.Seed("cpu", newest)
+		tracker.Seed("new", time.Time{})
+		assert.Empty(t, tracker.Unknown(points))
+
+		onTime, late, err := tracker.Split(points)
+		assert.True(t, errors.Is(err, c.expectedErr), string(c.policy))
+		assert.Equal(t, c.expectedOnTime, onTime, string(c.policy))
+		assert.Equal(t, c.expectedLate, late, string(c.policy))
+	}
+}
+
+func TestRejectedPoints(t *testing.T) {
+	newest := time.Unix(1650843741, 0)
+	tracker := NewTracker(PolicyReject, time.Minute)
+	tracker.Seed("cpu", newest)
+
+	onTime, _, err := tracker.Split([]Point{{"cpu", newest.Add(time.Hour)}, {"cpu", newest.Add(-time.Hour)}, {"cpu", newest.Add(-2 * time.Hour)}})
+	var rejected *model.RejectedError
+	if assert.ErrorAs(t, err, &rejected) {
+		assert.Equal(t, 2, rejected.Rejected)
+	}
+	assert.Equal(t, []int{0}, onTime)
+}
+
+func TestAdvance(t *testing.T) {
+	newest := time.Unix(1650843741, 0)
+	tracker := NewTracker(PolicyRoute, time.Minute)
+	tracker.Seed("cpu", newest)
+
+	// the split doesn't move the newest point forward, as the points may fail to be saved
+	points := []Point{{"cpu", newest.Add(time.Hour)}}
+	_, _, err := tracker.Split(points)
+	assert.Nil(t, err)
+	onTime, late, _ := tracker.Split([]Point{{"cpu", newest}})
+	assert.Equal(t, []int{0}, onTime)
+	assert.Empty(t, late)
+
+	tracker.Advance(points)
+	onTime, late, _ = tracker.Split([]Point{{"cpu", newest}})
+	assert.Empty(t, onTime)
+	assert.Equal(t, []int{0}, late)
+
+	// an older point doesn't move it back
+	tracker.Advance([]Point{{"cpu", newest}})
+	_, late, _ = tracker.Split([]Point{{"cpu", newest.Add(30 * time.Minute)}})
+	assert.Equal(t, []int{0}, late)
+}
+
+func TestEvict(t *testing.T) {
+	newest := time.Unix(1650843741, 0)
+	now := newest
+	tracker := NewTracker(PolicyRoute, time.Hour)
+	tracker.now = func() time.Time { return now }
+	tracker.Seed("cpu", newest)
+	tracker.Seed("idle", newest)
+
+	now = now.Add(30 * time.Minute)
+	tracker.Advance([]Point{{"cpu", newest.Add(time.Minute)}})
+	assert.Empty(t, tracker.Unknown([]Point{{"cpu", newest}, {"idle", newest}}))
+
+	// the series not written within the tolerance are evicted, and have to be seeded again
+	now = now.Add(70 * time.Minute)
+	tracker.Advance([]Point{{"cpu", newest.Add(2 * time.Minute)}})
+	assert.Equal(t, []string{"idle"}, tracker.Unknown([]Point{{"cpu", newest}, {"idle", newest}}))
+}
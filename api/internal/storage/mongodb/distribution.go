@@ -0,0 +1,89 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"sky/api/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// distributionsSuffix is appended to the collection name for the collection of the distributions
+const distributionsSuffix = "_distributions"
+
+// distributionDocument is how a model.Distribution is saved; like the samples, the name and labels are the meta field
+type distributionDocument struct {
+	Timestamp time.Time  `bson:"timestamp"`
+	Meta      sampleMeta `bson:"meta"`
+	Bounds    []float64  `bson:"bounds"`
+	Counts    []int64    `bson:"counts"`
+	Sum       float64    `bson:"sum"`
+	Count     int64      `bson:"count"`
+}
+
+// InsertDistributions saves the given distributions in the distributions collection; unlike the samples, they are
+// saved as they are sent, without the conflict and the late policies
+func (m *MongoStorage) InsertDistributions(ctx context.Context, dists []model.Distribution) error {
+	if len(dists) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, 0, len(dists))
+	for _, d := range dists {
+		docs = append(docs, distributionDocument{
+			Timestamp: d.Timestamp,
+			Meta: sampleMeta{
+				Series: d.SeriesID(),
+				Name:   d.Name,
+				Labels: d.Labels,
+			},
+			Bounds: d.Bounds,
+			Counts: d.Counts,
+			Sum:    d.Sum,
+			Count:  d.Count,
+		})
+	}
+	if _, err := m.client.Database(m.database).Collection(m.collection+distributionsSuffix).InsertMany(ctx, docs); err != nil {
+		return fmt.Errorf("error while inserting distributions: %w", classify(err))
+	}
+	return nil
+}
+
+// GetDistributions returns the distributions of the query ordered by time
+func (m *MongoStorage) GetDistributions(ctx context.Context, query model.SampleQuery) ([]model.Distribution, error) {
+	filter := bson.D{
+		primitive.E{Key: "meta.name", Value: query.Name},
+		primitive.E{Key: "timestamp", Value: primitive.M{"$lte": query.EndAt, "$gte": query.StartAt}},
+	}
+	for name, value := range query.Labels {
+		filter = append(filter, primitive.E{Key: "meta.labels." + name, Value: value})
+	}
+	findOptions := options.Find().SetSort(bson.D{primitive.E{Key: "timestamp", Value: 1}})
+
+	cursor, err := m.client.Database(m.database).Collection(m.collection+distributionsSuffix).Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving distributions: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var docs []distributionDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	dists := make([]model.Distribution, 0, len(docs))
+	for _, doc := range docs {
+		dists = append(dists, model.Distribution{
+			Timestamp: doc.Timestamp,
+			Name:      doc.Meta.Name,
+			Labels:    doc.Meta.Labels,
+			Bounds:    doc.Bounds,
+			Counts:    doc.Counts,
+			Sum:       doc.Sum,
+			Count:     doc.Count,
+		})
+	}
+	return dists, nil
+}
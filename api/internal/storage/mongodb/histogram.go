@@ -0,0 +1,134 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"sky/api/internal/model"
+	"sky/api/internal/storage/histogram"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// outsideBucket is the id of the $bucket for the values outside of the boundaries
+const outsideBucket = "outside"
+
+// bucketCount is a bucket of $bucket, or of $bucketAuto when its id is a document
+type bucketCount struct {
+	ID    interface{} `bson:"_id"`
+	Count int64       `bson:"count"`
+}
+
+// GetHistogram returns the distribution of the values of the metric type in the time range by the spec, using $bucket
+// for the set boundaries and $bucketAuto for the auto scale; it returns nil if there are no values
+func (m *MongoStorage) GetHistogram(ctx context.Context, config model.Query, spec model.HistogramSpec) (*model.Histogram, error) {
+	if err := histogram.Validate(spec); err != nil {
+		return nil, err
+	}
+	field := config.MetricType.String()
+	matchStage := bson.D{
+		primitive.E{Key: "$match", Value: bson.D{
+			primitive.E{Key: "timestamp", Value: primitive.M{"$lte": config.EndAt, "$gte": config.StartAt}},
+			primitive.E{Key: field, Value: primitive.M{"$exists": true}},
+		}},
+	}
+	coll := m.client.Database(m.database).Collection(m.collection)
+	opts := options.Aggregate().SetMaxTime(2 * time.Second)
+
+	if len(spec.Boundaries) == 0 && spec.Scale == model.HistogramScaleAuto {
+		autoStage := bson.D{primitive.E{Key: "$bucketAuto", Value: bson.D{
+			primitive.E{Key: "groupBy", Value: "$" + field},
+			primitive.E{Key: "buckets", Value: spec.Count},
+		}}}
+		var results []struct {
+			ID struct {
+				Min float64 `bson:"min"`
+				Max float64 `bson:"max"`
+			} `bson:"_id"`
+			Count int64 `bson:"count"`
+		}
+		if err := aggregate(ctx, coll, mongo.Pipeline{matchStage, autoStage}, opts, &results); err != nil {
+			return nil, err
+		}
+		if len(results) == 0 {
+			return nil, nil
+		}
+		h := &model.Histogram{Buckets: make([]model.HistogramBucket, 0, len(results))}
+		for _, b := range results {
+			h.Buckets = append(h.Buckets, model.HistogramBucket{Lower: b.ID.Min, Upper: b.ID.Max, Count: b.Count})
+		}
+		return h, nil
+	}
+
+	var min, max float64
+	if histogram.NeedsRange(spec) {
+		rangeStage := bson.D{primitive.E{Key: "$group", Value: bson.D{
+			primitive.E{Key: "_id", Value: ""},
+			primitive.E{Key: "min", Value: bson.D{primitive.E{Key: "$min", Value: "$" + field}}},
+			primitive.E{Key: "max", Value: bson.D{primitive.E{Key: "$max", Value: "$" + field}}},
+		}}}
+		var results []struct {
+			Min float64 `bson:"min"`
+			Max float64 `bson:"max"`
+		}
+		if err := aggregate(ctx, coll, mongo.Pipeline{matchStage, rangeStage}, opts, &results); err != nil {
+			return nil, err
+		}
+		if len(results) == 0 {
+			return nil, nil
+		}
+		min, max = results[0].Min, results[0].Max
+	}
+
+	bounds, raised, err := histogram.Boundaries(spec, min, max)
+	if err != nil {
+		return nil, err
+	}
+	bucketStage := bson.D{primitive.E{Key: "$bucket", Value: bson.D{
+		primitive.E{Key: "groupBy", Value: "$" + field},
+		primitive.E{Key: "boundaries", Value: bounds},
+		primitive.E{Key: "default", Value: outsideBucket},
+	}}}
+	var results []bucketCount
+	if err := aggregate(ctx, coll, mongo.Pipeline{matchStage, bucketStage}, opts, &results); err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, nil
+	}
+
+	// $bucket leaves out the empty buckets, its ids are their lower boundaries
+	index := make(map[float64]int, len(bounds))
+	for i, b := range bounds {
+		index[b] = i
+	}
+	counts := make([]int64, len(bounds)-1)
+	var outside int64
+	for _, b := range results {
+		lower, ok := b.ID.(float64)
+		if !ok {
+			outside += b.Count
+			continue
+		}
+		i, ok := index[lower]
+		if !ok || i >= len(counts) {
+			return nil, fmt.Errorf("unexpected bucket %v", b.ID)
+		}
+		counts[i] = b.Count
+	}
+	return histogram.Build(bounds, raised, counts, outside), nil
+}
+
+// aggregate runs the pipeline on the collection and decodes all the results
+func aggregate(ctx context.Context, coll *mongo.Collection, pipeline mongo.Pipeline, opts *options.AggregateOptions, results interface{}) error {
+	cursor, err := coll.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return fmt.Errorf("error while retrieving data: %w", err)
+	}
+	defer cursor.Close(ctx)
+	return cursor.All(ctx, results)
+}
@@ -0,0 +1,141 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"sky/api/internal/model"
+	"sky/api/internal/storage/late"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// lateSuffix is appended to the collection names for the collections of the late points
+const lateSuffix = "_late"
+
+// the series of the late tracker are prefixed by their kind, so a sample named like the metrics collection has a
+// newest point of its own
+const (
+	metricSeriesPrefix = "metric:"
+	sampleSeriesPrefix = "sample:"
+)
+
+// SetLatePolicy sets how the inserts treat the points older than the newest point of their series by more than
+// the tolerance
+func (m *MongoStorage) SetLatePolicy(policy late.Policy, tolerance time.Duration) {
+	m.late = late.NewTracker(policy, tolerance)
+}
+
+// splitLateMetrics returns the on time and the late metrics; the metrics collection holds a single series. The late
+// metrics rejected by the policy are left out of both and returned in a *model.RejectedError.
+func (m *MongoStorage) splitLateMetrics(ctx context.Context, metrics []model.Metric) ([]model.Metric, []model.Metric, error) {
+	if m.late.Policy() == late.PolicyAccept {
+		return metrics, nil, nil
+	}
+
+	points := m.metricPoints(metrics)
+	if len(m.late.Unknown(points)) > 0 {
+		newest, err := m.newestTimestamp(ctx, m.collection, bson.D{})
+		if err != nil {
+			return nil, nil, err
+		}
+		m.late.Seed(metricSeriesPrefix+m.collection, newest)
+	}
+
+	onTimeIdx, lateIdx, rejected := m.late.Split(points)
+	onTime := make([]model.Metric, 0, len(onTimeIdx))
+	for _, i := range onTimeIdx {
+		onTime = append(onTime, metrics[i])
+	}
+	lateMetrics := make([]model.Metric, 0, len(lateIdx))
+	for _, i := range lateIdx {
+		lateMetrics = append(lateMetrics, metrics[i])
+	}
+	return onTime, lateMetrics, rejected
+}
+
+// splitLateSamples returns the on time and the late samples, by the newest point of each series; like with
+// splitLateMetrics, the rejected samples are returned in a *model.RejectedError
+func (m *MongoStorage) splitLateSamples(ctx context.Context, samples []model.Sample) ([]model.Sample, []model.Sample, error) {
+	if m.late.Policy() == late.PolicyAccept {
+		return samples, nil, nil
+	}
+
+	points := samplePoints(samples)
+	for _, series := range m.late.Unknown(points) {
+		filter := bson.D{primitive.E{Key: "meta.series", Value: strings.TrimPrefix(series, sampleSeriesPrefix)}}
+		newest, err := m.newestTimestamp(ctx, m.collection+samplesSuffix, filter)
+		if err != nil {
+			return nil, nil, err
+		}
+		m.late.Seed(series, newest)
+	}
+
+	onTimeIdx, lateIdx, rejected := m.late.Split(points)
+	onTime := make([]model.Sample, 0, len(onTimeIdx))
+	for _, i := range onTimeIdx {
+		onTime = append(onTime, samples[i])
+	}
+	lateSamples := make([]model.Sample, 0, len(lateIdx))
+	for _, i := range lateIdx {
+		lateSamples = append(lateSamples, samples[i])
+	}
+	return onTime, lateSamples, rejected
+}
+
+// advanceLate moves the newest timestamps of the series forward with the saved on time points
+func (m *MongoStorage) advanceLate(points []late.Point) {
+	if m.late.Policy() != late.PolicyAccept {
+		m.late.Advance(points)
+	}
+}
+
+func (m *MongoStorage) metricPoints(metrics []model.Metric) []late.Point {
+	points := make([]late.Point, 0, len(metrics))
+	for _, metric := range metrics {
+		points = append(points, late.Point{Series: metricSeriesPrefix + m.collection, Timestamp: metric.Timestamp})
+	}
+	return points
+}
+
+func samplePoints(samples []model.Sample) []late.Point {
+	points := make([]late.Point, 0, len(samples))
+	for _, sample := range samples {
+		points = append(points, late.Point{Series: sampleSeriesPrefix + sample.SeriesID(), Timestamp: sample.Timestamp})
+	}
+	return points
+}
+
+// newestTimestamp returns the timestamp of the newest document matching the filter, or zero time if there is none
+func (m *MongoStorage) newestTimestamp(ctx context.Context, collection string, filter bson.D) (time.Time, error) {
+	return m.edgeTimestamp(ctx, collection, filter, -1)
+}
+
+// edgeTimestamp returns the timestamp of the oldest document matching the filter for the direction 1, or of the
+// newest for -1; it is zero time if there is none
+func (m *MongoStorage) edgeTimestamp(ctx context.Context, collection string, filter bson.D, direction int) (time.Time, error) {
+	opts := options.FindOne().
+		SetSort(bson.D{primitive.E{Key: "timestamp", Value: direction}}).
+		SetProjection(bson.D{primitive.E{Key: "timestamp", Value: 1}})
+
+	var doc struct {
+		Timestamp time.Time `bson:"timestamp"`
+	}
+	err := m.client.Database(m.database).Collection(collection).FindOne(ctx, filter, opts).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		edge := "newest"
+		if direction > 0 {
+			edge = "oldest"
+		}
+		return time.Time{}, fmt.Errorf("error while retrieving the %s point: %w", edge, classify(err))
+	}
+	return doc.Timestamp, nil
+}
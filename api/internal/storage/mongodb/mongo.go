@@ -2,10 +2,15 @@ package mongodb
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"sky/api/internal/model"
+	"sky/api/internal/storage/conflict"
+	"sky/api/internal/storage/late"
+	"sky/api/internal/storage/rollup"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -13,50 +18,144 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
 )
 
 // NamespaceExistsErrCode if the collections is created, a namespace exists error code is returned
 var NamespaceExistsErrCode int32 = 48
 
+// samplesSuffix is appended to the collection name for the collection of the labelled samples
+const samplesSuffix = "_samples"
+
 // MongoStorage is an implementation of a timeseries store in Mongo
 type MongoStorage struct {
 	client     *mongo.Client
 	database   string
 	collection string
+
+	conflictPolicy conflict.Policy
+	late           *late.Tracker
+
+	// rollups is until when the buckets of each rollup tier are complete, by the name of the tier;
+	// the queries use a tier only after it was rolled up by this process
+	rollupMu sync.RWMutex
+	rollups  map[string]time.Time
+}
+
+// sampleDocument is how a model.Sample is saved; the name, type and labels are the meta field of the collection,
+// so the samples of a series are bucketed together
+type sampleDocument struct {
+	Timestamp time.Time  `bson:"timestamp"`
+	Meta      sampleMeta `bson:"meta"`
+	Value     float64    `bson:"value"`
 }
 
-// NewMongoStorage returns a mongo storage containing timeseries data
-func NewMongoStorage(ctx context.Context, databaseURI, appName, databaseName, collectionName string) (*MongoStorage, error) {
+type sampleMeta struct {
+	// Series is the model.Sample SeriesID, so the points of a series can be matched without comparing label maps
+	Series string            `bson:"series"`
+	Name   string            `bson:"name"`
+	Type   string            `bson:"type,omitempty"`
+	Labels map[string]string `bson:"labels,omitempty"`
+}
+
+// NewMongoStorage returns a mongo storage containing timeseries data; the retention is applied to the collections
+// it creates, the existing collections keep theirs
+func NewMongoStorage(ctx context.Context, databaseURI, appName, databaseName, collectionName string, retention model.Retention) (*MongoStorage, error) {
 	client, err := createMongoClient(ctx, databaseURI, appName)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := initMongo(ctx, client, databaseName, collectionName); err != nil {
+	if err := initMongo(ctx, client, databaseName, collectionName, retention); err != nil {
 		return nil, err
 	}
 
 	return &MongoStorage{
-		client:     client,
-		database:   databaseName,
-		collection: collectionName,
+		client:         client,
+		database:       databaseName,
+		collection:     collectionName,
+		conflictPolicy: conflict.PolicyAppend,
+		late:           late.NewTracker(late.PolicyAccept, 0),
+		rollups:        make(map[string]time.Time),
 	}, nil
 }
 
-func initMongo(ctx context.Context, client *mongo.Client, databaseName, collectionName string) error {
+// SetConflictPolicy sets how the inserts treat points with the same timestamp and series as a stored point;
+// overwrite and merge delete the replaced points from the timeseries collections by their timestamp, which mongo
+// allows from 7.0, so they are refused by the older servers
+func (m *MongoStorage) SetConflictPolicy(ctx context.Context, policy conflict.Policy) error {
+	if policy == conflict.PolicyOverwrite || policy == conflict.PolicyMerge {
+		version, major, err := m.serverVersion(ctx)
+		if err != nil {
+			return err
+		}
+		if major < 7 {
+			return fmt.Errorf("conflict policy %s needs mongo 7.0 or newer to replace the points of the timeseries collections, but the server is %s; use append, reject or keep-first", policy, version)
+		}
+	}
+	m.conflictPolicy = policy
+	return nil
+}
+
+// serverVersion returns the version of the mongo server and its major version
+func (m *MongoStorage) serverVersion(ctx context.Context) (string, int32, error) {
+	var info struct {
+		Version      string  `bson:"version"`
+		VersionArray []int32 `bson:"versionArray"`
+	}
+	if err := m.client.Database(m.database).RunCommand(ctx, bson.D{primitive.E{Key: "buildInfo", Value: 1}}).Decode(&info); err != nil {
+		return "", 0, fmt.Errorf("error while retrieving the server version: %w", err)
+	}
+	if len(info.VersionArray) == 0 {
+		return "", 0, fmt.Errorf("server version %s is not valid", info.Version)
+	}
+	return info.Version, info.VersionArray[0], nil
+}
+
+func initMongo(ctx context.Context, client *mongo.Client, databaseName, collectionName string, retention model.Retention) error {
 
 	opts := options.CreateCollection().
 		SetTimeSeriesOptions(options.TimeSeries().
 			SetTimeField("timestamp").
 			SetGranularity("minutes")).
-		SetExpireAfterSeconds(315360000)
+		SetExpireAfterSeconds(retention.Raw)
+	if err := createCollection(ctx, client, databaseName, collectionName, opts); err != nil {
+		return err
+	}
+	// the first and the last metrics of the series info are read from the index
+	index := mongo.IndexModel{Keys: bson.D{primitive.E{Key: "timestamp", Value: 1}}}
+	if _, err := client.Database(databaseName).Collection(collectionName).Indexes().CreateOne(ctx, index); err != nil {
+		return fmt.Errorf("failed to create the timestamp index of %s: %w", collectionName, err)
+	}
+	if err := createCollection(ctx, client, databaseName, collectionName+lateSuffix, opts); err != nil {
+		return err
+	}
+
+	samplesOpts := options.CreateCollection().
+		SetTimeSeriesOptions(options.TimeSeries().
+			SetTimeField("timestamp").
+			SetMetaField("meta").
+			SetGranularity("seconds")).
+		SetExpireAfterSeconds(retention.Raw)
+	if err := createCollection(ctx, client, databaseName, collectionName+samplesSuffix, samplesOpts); err != nil {
+		return err
+	}
+	if err := createCollection(ctx, client, databaseName, collectionName+samplesSuffix+lateSuffix, samplesOpts); err != nil {
+		return err
+	}
+	if err := createCollection(ctx, client, databaseName, collectionName+distributionsSuffix, samplesOpts); err != nil {
+		return err
+	}
+	return initRollups(ctx, client, databaseName, collectionName, retention)
+}
 
+func createCollection(ctx context.Context, client *mongo.Client, databaseName, collectionName string, opts *options.CreateCollectionOptions) error {
 	timeoutContext, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 	if err := client.Database(databaseName).CreateCollection(timeoutContext, collectionName, opts); err != nil {
 		cmdErr, ok := err.(mongo.CommandError)
 		if ok && cmdErr.Code == NamespaceExistsErrCode {
-			fmt.Printf("collection already exists, do nothing \n")
+			fmt.Printf("collection %s already exists, do nothing \n", collectionName)
 		} else {
 			return err
 		}
@@ -64,14 +163,30 @@ func initMongo(ctx context.Context, client *mongo.Client, databaseName, collecti
 	return nil
 }
 
+// roundConcurrency rounds an average concurrency half away from zero like the averages of the rollups, as the
+// concurrency is never negative
+func roundConcurrency(value interface{}) bson.D {
+	return bson.D{primitive.E{Key: "$floor", Value: bson.A{
+		bson.D{primitive.E{Key: "$add", Value: bson.A{value, 0.5}}}}}}
+}
+
 // GetSeries returns the series of events saved in the DB for the particular filter given
 func (m *MongoStorage) GetSeries(ctx context.Context, config model.Query) ([]model.Metric, error) {
 
+	if tier, ok := rollup.TierFor(config.Frequency); ok {
+		if coveredUntil := m.rolledUntil(tier); !coveredUntil.IsZero() {
+			return m.getSeriesByRollup(ctx, config, tier, coveredUntil)
+		}
+	}
 	if config.Frequency != model.FrequencyNone && config.Frequency != model.FrequencyByMinutes {
 		return m.getSeriesByFrequency(ctx, config)
 	}
 
 	filter := bson.D{primitive.E{Key: "timestamp", Value: primitive.M{"$lte": config.EndAt, "$gte": config.StartAt}}}
+	if field := config.MetricType.String(); field != "" {
+		// the metrics without the field would be decoded as 0
+		filter = append(filter, primitive.E{Key: field, Value: primitive.M{"$exists": true}})
+	}
 	findOptions := options.Find()
 	findOptions.SetSort(bson.D{primitive.E{Key: "timestamp", Value: 1}}) // ordering - for display it is kept from old to new
 
@@ -108,19 +223,7 @@ func (m *MongoStorage) getSeriesByFrequency(ctx context.Context, config model.Qu
 		}},
 	}
 
-	var frequency string
-	switch config.Frequency {
-	case model.FrequencyBySeconds, model.FrequencyByMinutes, model.FrequencyNone:
-		frequency = "minute"
-	case model.FrequencyByHours:
-		frequency = "hour"
-	case model.FrequencyByDays:
-		frequency = "day"
-	case model.FrequencyByMonths:
-		frequency = "month"
-	case model.FrequencyByYears:
-		frequency = "year"
-	}
+	frequency := frequencyUnit(config.Frequency)
 
 	var groupStage bson.D
 	switch config.MetricType {
@@ -148,12 +251,15 @@ func (m *MongoStorage) getSeriesByFrequency(ctx context.Context, config model.Qu
 		primitive.E{Key: "$project", Value: bson.D{
 			primitive.E{Key: "timestamp", Value: "$_id.frequency"},
 			primitive.E{Key: "cpu_load", Value: "$cpu_load"},
-			primitive.E{Key: "concurrency", Value: "$concurrency"},
+			primitive.E{Key: "concurrency", Value: roundConcurrency("$concurrency")},
 		}},
 	}
 
+	// the groups come out in no particular order; the series is kept from old to new, like the raw one
+	sortStage := bson.D{primitive.E{Key: "$sort", Value: bson.D{primitive.E{Key: "timestamp", Value: 1}}}}
+
 	opts := options.Aggregate().SetMaxTime(2 * time.Second)
-	pipeline := mongo.Pipeline{matchStage, groupStage, projectionStage}
+	pipeline := mongo.Pipeline{matchStage, groupStage, projectionStage, sortStage}
 
 	var results []model.Metric
 	cursor, err := m.client.Database(m.database).Collection(m.collection).Aggregate(ctx, pipeline, opts)
@@ -172,6 +278,22 @@ func (m *MongoStorage) getSeriesByFrequency(ctx context.Context, config model.Qu
 	return results, nil
 }
 
+// frequencyUnit returns the $dateTrunc unit of the buckets of a frequency
+func frequencyUnit(frequency model.Frequency) string {
+	switch frequency {
+	case model.FrequencyByHours:
+		return "hour"
+	case model.FrequencyByDays:
+		return "day"
+	case model.FrequencyByMonths:
+		return "month"
+	case model.FrequencyByYears:
+		return "year"
+	default:
+		return "minute"
+	}
+}
+
 // GetAverage - returns the average value of a metrics for a certain time range
 func (m *MongoStorage) GetAverage(ctx context.Context, config model.Query) (*model.MetricAverage, error) {
 	matchStage := bson.D{
@@ -214,6 +336,10 @@ func (m *MongoStorage) GetAverage(ctx context.Context, config model.Query) (*mod
 	if len(results) > 1 {
 		return nil, fmt.Errorf("only one aggregation is expected")
 	}
+	// the $group stage returns no document when no metric is in the range
+	if len(results) == 0 {
+		return nil, nil
+	}
 
 	res := results[0]
 	res.StartTime = config.StartAt
@@ -222,6 +348,255 @@ func (m *MongoStorage) GetAverage(ctx context.Context, config model.Query) (*mod
 	return &res, nil
 }
 
+// InsertMetrics saves the given metrics in the timeseries collection, resolving the duplicates by the conflict policy
+// and the late metrics by the late policy; the metrics rejected by the policies are returned in a
+// *model.RejectedError, after the rest are saved
+func (m *MongoStorage) InsertMetrics(ctx context.Context, metrics []model.Metric) error {
+	metrics, lateMetrics, lateRejected := m.splitLateMetrics(ctx, metrics)
+	var rejectedErr *model.RejectedError
+	if lateRejected != nil && !errors.As(lateRejected, &rejectedErr) {
+		return lateRejected
+	}
+	if len(lateMetrics) > 0 {
+		docs := make([]interface{}, 0, len(lateMetrics))
+		for _, metric := range lateMetrics {
+			docs = append(docs, metric)
+		}
+		if _, err := m.client.Database(m.database).Collection(m.collection+lateSuffix).InsertMany(ctx, docs); err != nil {
+			return fmt.Errorf("error while inserting late data: %w", classify(err))
+		}
+	}
+	if len(metrics) == 0 {
+		return lateRejected
+	}
+
+	coll := m.client.Database(m.database).Collection(m.collection)
+	var stored []model.Metric
+	if m.conflictPolicy != conflict.PolicyAppend {
+		var err error
+		if stored, err = m.storedMetrics(ctx, metrics); err != nil {
+			return err
+		}
+	}
+
+	// the duplicates rejected by the policy are reported after the rest of the metrics are saved
+	insert, remove, rejected := conflict.ResolveMetrics(m.conflictPolicy, metrics, stored)
+	if rejected != nil && !errors.As(rejected, &rejectedErr) {
+		return rejected
+	}
+	if len(remove) > 0 {
+		// deleting from a timeseries collection by timestamp needs mongo 7.0 or newer, see SetConflictPolicy
+		if _, err := coll.DeleteMany(ctx, bson.D{primitive.E{Key: "timestamp", Value: primitive.M{"$in": remove}}}); err != nil {
+			return fmt.Errorf("error while removing overwritten data: %w", classify(err))
+		}
+	}
+	if len(insert) > 0 {
+		docs := make([]interface{}, 0, len(insert))
+		for _, metric := range insert {
+			docs = append(docs, metric)
+		}
+		if _, err := coll.InsertMany(ctx, docs); err != nil {
+			return fmt.Errorf("error while inserting data: %w", classify(err))
+		}
+	}
+	// the newest timestamp moves forward only once the metrics are saved, so the retry of a failed write isn't late
+	m.advanceLate(m.metricPoints(metrics))
+	return model.MergeRejected(lateRejected, rejected)
+}
+
+// CheckMetrics returns the *model.RejectedError of the metrics that the reject late and conflict policies would not
+// save, so a write can be refused before it is queued
+func (m *MongoStorage) CheckMetrics(ctx context.Context, metrics []model.Metric) error {
+	var lateRejected error
+	if m.late.Policy() == late.PolicyReject {
+		var rejectedErr *model.RejectedError
+		metrics, _, lateRejected = m.splitLateMetrics(ctx, metrics)
+		if lateRejected != nil && !errors.As(lateRejected, &rejectedErr) {
+			return lateRejected
+		}
+	}
+	if m.conflictPolicy != conflict.PolicyReject || len(metrics) == 0 {
+		return lateRejected
+	}
+	stored, err := m.storedMetrics(ctx, metrics)
+	if err != nil {
+		return err
+	}
+	_, _, err = conflict.ResolveMetrics(m.conflictPolicy, metrics, stored)
+	return model.MergeRejected(lateRejected, err)
+}
+
+// storedMetrics returns the stored metrics with the timestamps of the given metrics
+func (m *MongoStorage) storedMetrics(ctx context.Context, metrics []model.Metric) ([]model.Metric, error) {
+	times := make([]time.Time, 0, len(metrics))
+	for _, metric := range metrics {
+		times = append(times, metric.Timestamp)
+	}
+	cursor, err := m.client.Database(m.database).Collection(m.collection).Find(ctx, bson.D{primitive.E{Key: "timestamp", Value: primitive.M{"$in": times}}})
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving stored data: %w", classify(err))
+	}
+	var stored []model.Metric
+	if err := cursor.All(ctx, &stored); err != nil {
+		return nil, classify(err)
+	}
+	return stored, nil
+}
+
+// InsertSamples saves the given labelled samples in the samples collection, resolving the duplicates by the conflict
+// policy and the late samples by the late policy; like with InsertMetrics, the rejected samples are returned in a
+// *model.RejectedError
+func (m *MongoStorage) InsertSamples(ctx context.Context, samples []model.Sample) error {
+	samples, lateSamples, lateRejected := m.splitLateSamples(ctx, samples)
+	var rejectedErr *model.RejectedError
+	if lateRejected != nil && !errors.As(lateRejected, &rejectedErr) {
+		return lateRejected
+	}
+	if len(lateSamples) > 0 {
+		docs := make([]interface{}, 0, len(lateSamples))
+		for _, sample := range lateSamples {
+			docs = append(docs, newSampleDocument(sample))
+		}
+		if _, err := m.client.Database(m.database).Collection(m.collection+samplesSuffix+lateSuffix).InsertMany(ctx, docs); err != nil {
+			return fmt.Errorf("error while inserting late samples: %w", classify(err))
+		}
+	}
+	if len(samples) == 0 {
+		return lateRejected
+	}
+
+	coll := m.client.Database(m.database).Collection(m.collection + samplesSuffix)
+	var stored []model.Sample
+	if m.conflictPolicy != conflict.PolicyAppend {
+		times := make([]time.Time, 0, len(samples))
+		series := make([]string, 0, len(samples))
+		for _, sample := range samples {
+			times = append(times, sample.Timestamp)
+			series = append(series, sample.SeriesID())
+		}
+		filter := bson.D{
+			primitive.E{Key: "timestamp", Value: primitive.M{"$in": times}},
+			primitive.E{Key: "meta.series", Value: primitive.M{"$in": series}},
+		}
+		var docs []sampleDocument
+		cursor, err := coll.Find(ctx, filter)
+		if err != nil {
+			return fmt.Errorf("error while retrieving stored samples: %w", classify(err))
+		}
+		if err := cursor.All(ctx, &docs); err != nil {
+			return classify(err)
+		}
+		for _, doc := range docs {
+			stored = append(stored, doc.sample())
+		}
+	}
+
+	insert, remove, rejected := conflict.ResolveSamples(m.conflictPolicy, samples, stored)
+	if rejected != nil && !errors.As(rejected, &rejectedErr) {
+		return rejected
+	}
+	for _, sample := range remove {
+		filter := bson.D{
+			primitive.E{Key: "timestamp", Value: sample.Timestamp},
+			primitive.E{Key: "meta.series", Value: sample.SeriesID()},
+		}
+		if _, err := coll.DeleteMany(ctx, filter); err != nil {
+			return fmt.Errorf("error while removing overwritten samples: %w", classify(err))
+		}
+	}
+	if len(insert) > 0 {
+		docs := make([]interface{}, 0, len(insert))
+		for _, sample := range insert {
+			docs = append(docs, newSampleDocument(sample))
+		}
+		if _, err := coll.InsertMany(ctx, docs); err != nil {
+			return fmt.Errorf("error while inserting samples: %w", classify(err))
+		}
+	}
+	m.advanceLate(samplePoints(samples))
+	return model.MergeRejected(lateRejected, rejected)
+}
+
+// unavailableError marks the errors of the driver that can pass, so they match model.ErrUnavailable
+type unavailableError struct {
+	error
+}
+
+func (e unavailableError) Unwrap() error {
+	return e.error
+}
+
+func (e unavailableError) Is(target error) bool {
+	return target == model.ErrUnavailable
+}
+
+// classify marks the network, timeout and server selection errors of the writes as model.ErrUnavailable
+func classify(err error) error {
+	var selection topology.ServerSelectionError
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) || errors.As(err, &selection) || errors.Is(err, mongo.ErrClientDisconnected) {
+		return unavailableError{err}
+	}
+	return err
+}
+
+// GetSamples returns the samples of the query ordered by time
+func (m *MongoStorage) GetSamples(ctx context.Context, query model.SampleQuery) ([]model.Sample, error) {
+	filter := bson.D{
+		primitive.E{Key: "meta.name", Value: query.Name},
+		primitive.E{Key: "timestamp", Value: primitive.M{"$lte": query.EndAt, "$gte": query.StartAt}},
+	}
+	for name, value := range query.Labels {
+		filter = append(filter, primitive.E{Key: "meta.labels." + name, Value: value})
+	}
+	findOptions := options.Find().SetSort(bson.D{primitive.E{Key: "timestamp", Value: 1}})
+
+	cursor, err := m.client.Database(m.database).Collection(m.collection+samplesSuffix).Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving samples: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var docs []sampleDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	samples := make([]model.Sample, 0, len(docs))
+	for _, doc := range docs {
+		samples = append(samples, doc.sample())
+	}
+	return samples, nil
+}
+
+// LastSampleTime returns the timestamp of the newest sample of the series with the name and labels, or zero time if
+// there is none
+func (m *MongoStorage) LastSampleTime(ctx context.Context, name string, labels map[string]string) (time.Time, error) {
+	filter := bson.D{primitive.E{Key: "meta.series", Value: model.Sample{Name: name, Labels: labels}.SeriesID()}}
+	return m.newestTimestamp(ctx, m.collection+samplesSuffix, filter)
+}
+
+func newSampleDocument(sample model.Sample) sampleDocument {
+	return sampleDocument{
+		Timestamp: sample.Timestamp,
+		Meta: sampleMeta{
+			Series: sample.SeriesID(),
+			Name:   sample.Name,
+			Type:   sample.Type,
+			Labels: sample.Labels,
+		},
+		Value: sample.Value,
+	}
+}
+
+func (d sampleDocument) sample() model.Sample {
+	return model.Sample{
+		Timestamp: d.Timestamp,
+		Name:      d.Meta.Name,
+		Type:      d.Meta.Type,
+		Labels:    d.Meta.Labels,
+		Value:     d.Value,
+	}
+}
+
 func createMongoClient(ctx context.Context, dbURI, appName string) (*mongo.Client, error) {
 	clientOptions := options.Client().
 		ApplyURI(dbURI).
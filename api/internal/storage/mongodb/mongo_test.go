@@ -14,6 +14,10 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"sky/api/internal/model"
+	"sky/api/internal/storage/conflict"
+	"sky/api/internal/storage/histogram"
+	"sky/api/internal/storage/late"
+	"sky/api/internal/storage/rollup"
 
 	"github.com/docker/go-connections/nat"
 	"github.com/testcontainers/testcontainers-go"
@@ -33,6 +37,8 @@ const (
 
 var dbURL string
 
+var retention = model.Retention{Raw: 315360000, Hourly: 315360000, Daily: 315360000}
+
 func TestMain(m *testing.M) {
 	ctx := context.Background()
 
@@ -83,7 +89,7 @@ func TestMain(m *testing.M) {
 func TestGetSeries(t *testing.T) {
 	ctx := context.Background()
 
-	mongoDB, err := NewMongoStorage(ctx, dbURL, appName, db, collectionName)
+	mongoDB, err := NewMongoStorage(ctx, dbURL, appName, db, collectionName, retention)
 	assert.Nil(t, err, "error initialising test db")
 
 	now := time.Now()
@@ -140,3 +146,375 @@ func createClient(ctx context.Context, url, appName string) (*mongo.Client, erro
 	}
 	return client, nil
 }
+
+func TestConflictPolicies(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewMongoStorage(ctx, dbURL, appName, db, "conflicts", retention)
+	assert.Nil(t, err, "error initialising test db")
+
+	at := time.Date(2022, 4, 25, 10, 0, 0, 0, time.UTC)
+	assert.Nil(t, store.InsertMetrics(ctx, []model.Metric{{Timestamp: at, CPULoad: 42, Concurrency: 100}}))
+	cpuLoad := func() []float64 {
+		var values []float64
+		metrics, err := store.GetSeries(ctx, model.Query{StartAt: at, EndAt: at, MetricType: model.MetricTypeCPULoad})
+		assert.Nil(t, err)
+		for _, metric := range metrics {
+			values = append(values, metric.CPULoad)
+		}
+		return values
+	}
+
+	assert.Nil(t, store.SetConflictPolicy(ctx, conflict.PolicyReject))
+	err = store.InsertMetrics(ctx, []model.Metric{{Timestamp: at, CPULoad: 50}})
+	assert.ErrorIs(t, err, conflict.ErrDuplicate)
+	assert.Equal(t, []float64{42}, cpuLoad())
+
+	assert.Nil(t, store.SetConflictPolicy(ctx, conflict.PolicyKeepFirst))
+	assert.Nil(t, store.InsertMetrics(ctx, []model.Metric{{Timestamp: at, CPULoad: 50}}))
+	assert.Equal(t, []float64{42}, cpuLoad())
+
+	// the servers older than 7.0 can't delete the replaced points, so the policies replacing them are refused
+	_, major, err := store.serverVersion(ctx)
+	assert.Nil(t, err)
+	for _, policy := range []conflict.Policy{conflict.PolicyOverwrite, conflict.PolicyMerge} {
+		err := store.SetConflictPolicy(ctx, policy)
+		if major < 7 {
+			assert.NotNil(t, err, policy)
+			assert.Equal(t, conflict.PolicyKeepFirst, store.conflictPolicy, policy)
+			continue
+		}
+		assert.Nil(t, err, policy)
+	}
+	if major < 7 {
+		return
+	}
+
+	assert.Nil(t, store.SetConflictPolicy(ctx, conflict.PolicyMerge))
+	assert.Nil(t, store.InsertMetrics(ctx, []model.Metric{{Timestamp: at, Concurrency: 200}}))
+	metrics, err := store.GetSeries(ctx, model.Query{StartAt: at, EndAt: at})
+	assert.Nil(t, err)
+	assert.Equal(t, []model.Metric{{Timestamp: at, CPULoad: 42, Concurrency: 200}}, metrics)
+
+	assert.Nil(t, store.SetConflictPolicy(ctx, conflict.PolicyOverwrite))
+	assert.Nil(t, store.InsertMetrics(ctx, []model.Metric{{Timestamp: at, CPULoad: 50}}))
+	assert.Equal(t, []float64{50}, cpuLoad())
+}
+
+func TestLateReject(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewMongoStorage(ctx, dbURL, appName, db, "late", retention)
+	assert.Nil(t, err, "error initialising test db")
+	store.SetLatePolicy(late.PolicyReject, time.Hour)
+
+	at := time.Date(2022, 4, 25, 10, 0, 0, 0, time.UTC)
+	assert.Nil(t, store.InsertMetrics(ctx, []model.Metric{{Timestamp: at, CPULoad: 42}}))
+	assert.ErrorIs(t, store.CheckMetrics(ctx, []model.Metric{{Timestamp: at.Add(-2 * time.Hour)}}), late.ErrLate)
+
+	// the late metric is rejected and the rest are saved
+	err = store.InsertMetrics(ctx, []model.Metric{{Timestamp: at.Add(-2 * time.Hour), CPULoad: 10}, {Timestamp: at.Add(time.Minute), CPULoad: 20}})
+	var rejected *model.RejectedError
+	if assert.ErrorAs(t, err, &rejected) {
+		assert.Equal(t, 1, rejected.Rejected)
+	}
+	assert.ErrorIs(t, err, late.ErrLate)
+	metrics, err := store.GetSeries(ctx, model.Query{StartAt: at.Add(-3 * time.Hour), EndAt: at.Add(time.Hour), MetricType: model.MetricTypeCPULoad})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(metrics))
+}
+
+func TestRollupsResume(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewMongoStorage(ctx, dbURL, appName, db, "resumed", retention)
+	assert.Nil(t, err, "error initialising test db")
+
+	at := time.Date(2022, 4, 25, 10, 0, 0, 0, time.UTC)
+	assert.Nil(t, store.InsertMetrics(ctx, []model.Metric{
+		{Timestamp: at, Concurrency: 1}, {Timestamp: at.Add(time.Minute), Concurrency: 2}, {Timestamp: at.Add(2 * time.Hour), Concurrency: 5},
+	}))
+	assert.Nil(t, store.updateRollups(ctx, at.Add(24*time.Hour), time.Hour))
+
+	// a point of the rolled up hour, written while no process was rolling up, is past the lookback of the next process
+	assert.Nil(t, store.InsertMetrics(ctx, []model.Metric{{Timestamp: at.Add(2 * time.Minute), Concurrency: 100}}))
+	restarted, err := NewMongoStorage(ctx, dbURL, appName, db, "resumed", retention)
+	assert.Nil(t, err)
+	assert.Nil(t, restarted.updateRollups(ctx, at.Add(25*time.Hour), time.Hour))
+	assert.Equal(t, at.Add(25*time.Hour), restarted.rolledUntil(rollup.Hourly))
+
+	// the restarted process continued from the stored buckets instead of rolling up all the metrics again
+	series, err := restarted.GetSeries(ctx, model.Query{StartAt: at, EndAt: at.Add(time.Hour), MetricType: model.MetricTypeConcurrency, Frequency: model.FrequencyByHours})
+	assert.Nil(t, err)
+	assert.Equal(t, []model.Metric{{Timestamp: at, Concurrency: 2}}, series)
+
+	// the raw path rounds the averages like the rollups
+	raw, err := NewMongoStorage(ctx, dbURL, appName, db, "resumed", retention)
+	assert.Nil(t, err)
+	series, err = raw.GetSeries(ctx, model.Query{StartAt: at, EndAt: at.Add(time.Minute), MetricType: model.MetricTypeConcurrency, Frequency: model.FrequencyByHours})
+	assert.Nil(t, err)
+	assert.Equal(t, []model.Metric{{Timestamp: at, Concurrency: 2}}, series)
+}
+
+func TestGetSeriesMissingFields(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewMongoStorage(ctx, dbURL, appName, db, "partial", retention)
+	assert.Nil(t, err, "error initialising test db")
+
+	at := time.Date(2022, 4, 25, 10, 0, 0, 0, time.UTC)
+	assert.Nil(t, store.InsertMetrics(ctx, []model.Metric{{Timestamp: at, CPULoad: 42}, {Timestamp: at.Add(time.Minute), Concurrency: 100}}))
+
+	// the metrics without the field of the metric type are left out instead of being read as 0
+	metrics, err := store.GetSeries(ctx, model.Query{StartAt: at, EndAt: at.Add(time.Hour), MetricType: model.MetricTypeCPULoad})
+	assert.Nil(t, err)
+	assert.Equal(t, []model.Metric{{Timestamp: at, CPULoad: 42}}, metrics)
+
+	last, err := store.LastSampleTime(ctx, "cpu_load:max:1h", nil)
+	assert.Nil(t, err)
+	assert.True(t, last.IsZero())
+	assert.Nil(t, store.InsertSamples(ctx, []model.Sample{{Timestamp: at, Name: "cpu_load:max:1h", Type: model.SampleTypeGauge, Value: 42}}))
+	last, err = store.LastSampleTime(ctx, "cpu_load:max:1h", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, at, last.UTC())
+}
+
+func TestGetSeriesByFrequencyOrder(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewMongoStorage(ctx, dbURL, appName, db, "ordered", retention)
+	assert.Nil(t, err, "error initialising test db")
+
+	at := time.Date(2022, 4, 25, 0, 0, 0, 0, time.UTC)
+	var metrics []model.Metric
+	for i := 0; i < 48; i++ {
+		metrics = append(metrics, model.Metric{Timestamp: at.Add(time.Duration(i) * 30 * time.Minute), CPULoad: float64(i + 1)})
+	}
+	assert.Nil(t, store.InsertMetrics(ctx, metrics))
+
+	series, err := store.GetSeries(ctx, model.Query{StartAt: at, EndAt: at.Add(24 * time.Hour), MetricType: model.MetricTypeCPULoad, Frequency: model.FrequencyByHours})
+	assert.Nil(t, err)
+	assert.Equal(t, 24, len(series))
+	for i := 1; i < len(series); i++ {
+		assert.True(t, series[i-1].Timestamp.Before(series[i].Timestamp), "the series is ordered by time")
+	}
+}
+
+func TestGetHistogram(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewMongoStorage(ctx, dbURL, appName, db, "histogram", retention)
+	assert.Nil(t, err, "error initialising test db")
+
+	at := time.Date(2022, 4, 25, 0, 0, 0, 0, time.UTC)
+	var metrics []model.Metric
+	var loads, concurrency []float64
+	for i := 0; i < 100; i++ {
+		metric := model.Metric{Timestamp: at.Add(time.Duration(i) * time.Minute), CPULoad: float64(i%20) / 2, Concurrency: int32(i + 1)}
+		metrics = append(metrics, metric)
+		loads = append(loads, metric.CPULoad)
+		concurrency = append(concurrency, float64(metric.Concurrency))
+	}
+	assert.Nil(t, store.InsertMetrics(ctx, metrics))
+
+	min, max := 1.0, 100.0
+	cases := []struct {
+		description string
+		metricType  model.MetricType
+		values      []float64
+		spec        model.HistogramSpec
+	}{
+		{"linear", model.MetricTypeCPULoad, loads, model.HistogramSpec{Count: 4, Scale: model.HistogramScaleLinear}},
+		{"boundaries", model.MetricTypeCPULoad, loads, model.HistogramSpec{Boundaries: []float64{1, 2.5, 6}}},
+		{"log", model.MetricTypeConcurrency, concurrency, model.HistogramSpec{Count: 2, Scale: model.HistogramScaleLog, Min: &min, Max: &max}},
+		{"auto", model.MetricTypeConcurrency, concurrency, model.HistogramSpec{Count: 4, Scale: model.HistogramScaleAuto}},
+		{"auto with equal values", model.MetricTypeCPULoad, loads, model.HistogramSpec{Count: 3, Scale: model.HistogramScaleAuto}},
+	}
+	for _, c := range cases {
+		// $bucket and $bucketAuto count the stored values like the histogram package counts them in memory
+		expected, err := histogram.Compute(c.values, c.spec)
+		assert.Nil(t, err, c.description)
+		hist, err := store.GetHistogram(ctx, model.Query{StartAt: at, EndAt: at.Add(2 * time.Hour), MetricType: c.metricType}, c.spec)
+		assert.Nil(t, err, c.description)
+		assert.Equal(t, expected, hist, c.description)
+	}
+}
+
+func TestGetTop(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewMongoStorage(ctx, dbURL, appName, db, "top", retention)
+	assert.Nil(t, err, "error initialising test db")
+
+	at := time.Date(2022, 4, 25, 0, 0, 0, 0, time.UTC)
+	var metrics []model.Metric
+	for i, load := range []float64{30, 90, 10, 70, 90, 50} {
+		metrics = append(metrics, model.Metric{Timestamp: at.Add(time.Duration(i) * 30 * time.Minute), CPULoad: load})
+	}
+	// a metric without a cpu load is left out instead of being read as 0
+	metrics = append(metrics, model.Metric{Timestamp: at.Add(3 * time.Hour), Concurrency: 100})
+	assert.Nil(t, store.InsertMetrics(ctx, metrics))
+
+	query := model.Query{StartAt: at, EndAt: at.Add(4 * time.Hour), MetricType: model.MetricTypeCPULoad}
+	top, err := store.GetTop(ctx, query, 3, false)
+	assert.Nil(t, err)
+	// the earlier point comes first among the equal values
+	assert.Equal(t, []model.Metric{
+		{Timestamp: at.Add(30 * time.Minute), CPULoad: 90},
+		{Timestamp: at.Add(2 * time.Hour), CPULoad: 90},
+		{Timestamp: at.Add(90 * time.Minute), CPULoad: 70},
+	}, top)
+
+	bottom, err := store.GetTop(ctx, query, 2, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.Metric{
+		{Timestamp: at.Add(time.Hour), CPULoad: 10},
+		{Timestamp: at, CPULoad: 30},
+	}, bottom)
+
+	query.Frequency = model.FrequencyByHours
+	hourly, err := store.GetTop(ctx, query, 1, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.Metric{{Timestamp: at.Add(2 * time.Hour), CPULoad: 70}}, hourly)
+
+	// the average concurrency of an hour is rounded like in the timeline: (1 + 2) / 2 is 2, not 1
+	assert.Nil(t, store.InsertMetrics(ctx, []model.Metric{{Timestamp: at.Add(5 * time.Hour), Concurrency: 1}, {Timestamp: at.Add(5*time.Hour + time.Minute), Concurrency: 2}}))
+	query = model.Query{StartAt: at.Add(5 * time.Hour), EndAt: at.Add(6 * time.Hour), MetricType: model.MetricTypeConcurrency, Frequency: model.FrequencyByHours}
+	hourly, err = store.GetTop(ctx, query, 1, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []model.Metric{{Timestamp: at.Add(5 * time.Hour), Concurrency: 2}}, hourly)
+	series, err := store.GetSeries(ctx, query)
+	assert.Nil(t, err)
+	assert.Equal(t, hourly, series)
+}
+
+func TestGetCollectionStats(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewMongoStorage(ctx, dbURL, appName, db, "stats", retention)
+	assert.Nil(t, err, "error initialising test db")
+
+	at := time.Date(2022, 4, 25, 0, 0, 0, 0, time.UTC)
+	assert.Nil(t, store.InsertMetrics(ctx, []model.Metric{{Timestamp: at, CPULoad: 42}, {Timestamp: at.Add(time.Minute), CPULoad: 12}}))
+
+	stats, err := store.GetCollectionStats(ctx)
+	assert.Nil(t, err)
+	names := make([]string, 0, len(stats))
+	for _, s := range stats {
+		names = append(names, s.Name)
+	}
+	assert.Equal(t, []string{"stats", "stats_late", "stats_samples", "stats_samples_late", "stats_distributions", "stats_hourly", "stats_daily"}, names)
+
+	// the metrics collection is a timeseries collection, whose documents are counted by day
+	metrics := stats[0]
+	assert.Nil(t, metrics.Documents)
+	if assert.NotNil(t, metrics.Buckets) {
+		assert.Equal(t, int64(1), *metrics.Buckets)
+	}
+	assert.NotNil(t, metrics.AvgBucketSize)
+	assert.Greater(t, metrics.Size, int64(0))
+
+	// the rollups are regular collections
+	hourly := stats[5]
+	assert.Nil(t, hourly.Buckets)
+	if assert.NotNil(t, hourly.Documents) {
+		assert.Equal(t, int64(0), *hourly.Documents)
+	}
+	assert.Greater(t, hourly.IndexSize, int64(0))
+}
+
+func TestGetAverageEmptyRange(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewMongoStorage(ctx, dbURL, appName, db, "average", retention)
+	assert.Nil(t, err, "error initialising test db")
+
+	at := time.Date(2022, 4, 25, 0, 0, 0, 0, time.UTC)
+	assert.Nil(t, store.InsertMetrics(ctx, []model.Metric{{Timestamp: at, CPULoad: 40}, {Timestamp: at.Add(time.Minute), CPULoad: 60}}))
+
+	average, err := store.GetAverage(ctx, model.Query{StartAt: at, EndAt: at.Add(time.Hour), MetricType: model.MetricTypeCPULoad})
+	assert.Nil(t, err)
+	if assert.NotNil(t, average) {
+		assert.Equal(t, 50.0, average.CPULoad)
+	}
+
+	// a range without metrics has no average
+	average, err = store.GetAverage(ctx, model.Query{StartAt: at.Add(24 * time.Hour), EndAt: at.Add(25 * time.Hour), MetricType: model.MetricTypeCPULoad})
+	assert.Nil(t, err)
+	assert.Nil(t, average)
+}
+
+func TestGetSeriesInfo(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewMongoStorage(ctx, dbURL, appName, db, "info", retention)
+	assert.Nil(t, err, "error initialising test db")
+
+	info, err := store.GetSeriesInfo(ctx, model.MetricTypeCPULoad)
+	assert.Nil(t, err)
+	assert.Nil(t, info)
+
+	// four days of a metric every 6 hours, starting and ending within a day
+	at := time.Date(2022, 4, 25, 3, 0, 0, 0, time.UTC)
+	var metrics []model.Metric
+	for i := 0; i < 14; i++ {
+		metrics = append(metrics, model.Metric{Timestamp: at.Add(time.Duration(i) * 6 * time.Hour), CPULoad: float64(10 + i)})
+	}
+	metrics = append(metrics, model.Metric{Timestamp: at.Add(time.Hour), Concurrency: 100})
+	assert.Nil(t, store.InsertMetrics(ctx, metrics))
+
+	expected := &model.SeriesInfo{Name: "cpu_load", Kind: model.SeriesKindMetric, First: at, Last: at.Add(78 * time.Hour), Count: 14}
+	min, max := 10.0, 23.0
+	expected.Min, expected.Max = &min, &max
+	info, err = store.GetSeriesInfo(ctx, model.MetricTypeCPULoad)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, info)
+
+	// the complete days are counted from the daily rollup, and the edges from the raw metrics
+	assert.Nil(t, store.updateRollups(ctx, at.Add(96*time.Hour), time.Hour))
+	info, err = store.GetSeriesInfo(ctx, model.MetricTypeCPULoad)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, info)
+
+	info, err = store.GetSeriesInfo(ctx, model.MetricTypeConcurrency)
+	assert.Nil(t, err)
+	if assert.NotNil(t, info) {
+		assert.Equal(t, int64(1), info.Count)
+		assert.Equal(t, at.Add(time.Hour), info.First)
+		assert.Equal(t, 100.0, *info.Max)
+	}
+}
+
+func TestRetentionKeptOnRestart(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewMongoStorage(ctx, dbURL, appName, db, "kept", retention)
+	assert.Nil(t, err, "error initialising test db")
+
+	changed := model.Retention{Raw: 86400, Hourly: 604800, Daily: 31536000}
+	assert.Nil(t, store.SetRetention(ctx, changed))
+
+	// a restart with the configured retention keeps the retention changed through the api
+	restarted, err := NewMongoStorage(ctx, dbURL, appName, db, "kept", retention)
+	assert.Nil(t, err)
+	current, err := restarted.GetRetention(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, &changed, current)
+}
+
+func TestGetDailyCounts(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewMongoStorage(ctx, dbURL, appName, db, "daily", retention)
+	assert.Nil(t, err, "error initialising test db")
+
+	at := time.Date(2022, 4, 24, 10, 0, 0, 0, time.UTC)
+	assert.Nil(t, store.InsertMetrics(ctx, []model.Metric{
+		{Timestamp: at, CPULoad: 42, Concurrency: 100},
+		{Timestamp: at.Add(time.Hour), CPULoad: 12},
+		{Timestamp: at.Add(24 * time.Hour), Concurrency: 5},
+	}))
+	expected := []model.DailyCount{
+		{Day: time.Date(2022, 4, 24, 0, 0, 0, 0, time.UTC), Count: 2},
+		{Day: time.Date(2022, 4, 25, 0, 0, 0, 0, time.UTC), Count: 1},
+	}
+	start, end := time.Date(2022, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+	counts, err := store.GetDailyCounts(ctx, start, end, time.UTC)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, counts)
+
+	// the days older than the raw retention are counted from the daily rollup
+	assert.Nil(t, store.updateRollups(ctx, at.Add(72*time.Hour), time.Hour))
+	assert.Nil(t, store.SetRetention(ctx, model.Retention{Raw: 86400, Hourly: retention.Hourly, Daily: retention.Daily}))
+	counts, err = store.GetDailyCounts(ctx, start, end, time.UTC)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, counts)
+}
@@ -0,0 +1,118 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"sky/api/internal/model"
+	"sky/api/internal/storage/rollup"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// IndexOptionsConflictErrCode is returned when an index exists with the same keys but different options
+var IndexOptionsConflictErrCode int32 = 85
+
+// rawCollections are the collections of the written points, which share the raw retention
+func rawCollections(collectionName string) []string {
+	return []string{
+		collectionName,
+		collectionName + lateSuffix,
+		collectionName + samplesSuffix,
+		collectionName + samplesSuffix + lateSuffix,
+		collectionName + distributionsSuffix,
+	}
+}
+
+// GetRetention returns the retention of the resolutions, read from the collection options and the ttl indexes;
+// a resolution without an expiry has a retention of 0
+func (m *MongoStorage) GetRetention(ctx context.Context) (*model.Retention, error) {
+	db := m.client.Database(m.database)
+	specs, err := db.ListCollectionSpecifications(ctx, bson.D{primitive.E{Key: "name", Value: m.collection}})
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving the collection options: %w", err)
+	}
+	var retention model.Retention
+	for _, spec := range specs {
+		if expiry, ok := spec.Options.Lookup("expireAfterSeconds").AsInt64OK(); ok {
+			retention.Raw = expiry
+		}
+	}
+
+	for _, tier := range []rollup.Tier{rollup.Hourly, rollup.Daily} {
+		expiry, err := indexExpiry(ctx, db, rollupCollection(m.collection, tier))
+		if err != nil {
+			return nil, err
+		}
+		if tier == rollup.Hourly {
+			retention.Hourly = expiry
+		} else {
+			retention.Daily = expiry
+		}
+	}
+	return &retention, nil
+}
+
+// indexExpiry returns the expiry of the timestamp index of a rollup collection, or 0 if the index has none
+func indexExpiry(ctx context.Context, db *mongo.Database, collectionName string) (int64, error) {
+	indexes, err := db.Collection(collectionName).Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error while retrieving the indexes of %s: %w", collectionName, err)
+	}
+	for _, index := range indexes {
+		if _, err := index.KeysDocument.LookupErr("timestamp"); err == nil && index.ExpireAfterSeconds != nil {
+			return int64(*index.ExpireAfterSeconds), nil
+		}
+	}
+	return 0, nil
+}
+
+// SetRetention changes the retention of the resolutions with collMod; the expired points are removed by the
+// next pass of the ttl monitor
+func (m *MongoStorage) SetRetention(ctx context.Context, retention model.Retention) error {
+	if retention.Raw <= 0 || retention.Hourly <= 0 || retention.Daily <= 0 {
+		return fmt.Errorf("retention has to be positive")
+	}
+	if retention.Hourly > math.MaxInt32 || retention.Daily > math.MaxInt32 {
+		return fmt.Errorf("retention of the rollups can be at most %d seconds", math.MaxInt32)
+	}
+
+	for _, name := range rawCollections(m.collection) {
+		if err := m.setCollectionExpiry(ctx, name, retention.Raw); err != nil {
+			return err
+		}
+	}
+	if err := setIndexExpiry(ctx, m.client.Database(m.database), rollupCollection(m.collection, rollup.Hourly), retention.Hourly); err != nil {
+		return err
+	}
+	return setIndexExpiry(ctx, m.client.Database(m.database), rollupCollection(m.collection, rollup.Daily), retention.Daily)
+}
+
+func (m *MongoStorage) setCollectionExpiry(ctx context.Context, collectionName string, seconds int64) error {
+	cmd := bson.D{
+		primitive.E{Key: "collMod", Value: collectionName},
+		primitive.E{Key: "expireAfterSeconds", Value: seconds},
+	}
+	if err := m.client.Database(m.database).RunCommand(ctx, cmd).Err(); err != nil {
+		return fmt.Errorf("error while changing the retention of %s: %w", collectionName, err)
+	}
+	return nil
+}
+
+// setIndexExpiry changes the expiry of the timestamp index of a rollup collection
+func setIndexExpiry(ctx context.Context, db *mongo.Database, collectionName string, seconds int64) error {
+	cmd := bson.D{
+		primitive.E{Key: "collMod", Value: collectionName},
+		primitive.E{Key: "index", Value: bson.D{
+			primitive.E{Key: "keyPattern", Value: bson.D{primitive.E{Key: "timestamp", Value: 1}}},
+			primitive.E{Key: "expireAfterSeconds", Value: seconds},
+		}},
+	}
+	if err := db.RunCommand(ctx, cmd).Err(); err != nil {
+		return fmt.Errorf("error while changing the retention of %s: %w", collectionName, err)
+	}
+	return nil
+}
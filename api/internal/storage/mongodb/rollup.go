@@ -0,0 +1,229 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"sky/api/internal/model"
+	"sky/api/internal/storage/rollup"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// rollupFields are the fields of the metrics collection that are rolled up
+var rollupFields = []string{"cpu_load", "concurrency"}
+
+func rollupCollection(collection string, tier rollup.Tier) string {
+	return collection + "_" + tier.Name
+}
+
+// initRollups creates the rollup collections; they are regular collections with a unique bucket timestamp,
+// so the buckets can be replaced when they are rolled up again, which is also the ttl index of their retention
+func initRollups(ctx context.Context, client *mongo.Client, databaseName, collectionName string, retention model.Retention) error {
+	expiry := map[rollup.Tier]int64{rollup.Hourly: retention.Hourly, rollup.Daily: retention.Daily}
+	for _, tier := range []rollup.Tier{rollup.Hourly, rollup.Daily} {
+		name := rollupCollection(collectionName, tier)
+		if err := createCollection(ctx, client, databaseName, name, options.CreateCollection()); err != nil {
+			return err
+		}
+		index := mongo.IndexModel{
+			Keys:    bson.D{primitive.E{Key: "timestamp", Value: 1}},
+			Options: options.Index().SetUnique(true).SetExpireAfterSeconds(int32(expiry[tier])),
+		}
+		_, err := client.Database(databaseName).Collection(name).Indexes().CreateOne(ctx, index)
+		if cmdErr, ok := err.(mongo.CommandError); ok && cmdErr.Code == IndexOptionsConflictErrCode {
+			// the index exists with other options: its retention was changed through the api and is kept, like the
+			// retention of the raw collections, unless the index was created without one
+			var current int64
+			if current, err = indexExpiry(ctx, client.Database(databaseName), name); err == nil && current == 0 {
+				err = setIndexExpiry(ctx, client.Database(databaseName), name, expiry[tier])
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create the timestamp index of %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// RunRollups keeps the hourly and daily rollups up to date until the context is cancelled; every interval the
+// buckets completed since the previous run are rolled up, together with the buckets of the lookback before them,
+// which may have received late points
+func (m *MongoStorage) RunRollups(ctx context.Context, interval, lookback time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.updateRollups(ctx, time.Now(), lookback); err != nil && ctx.Err() == nil {
+			log.Printf("failed to update the rollups: %s", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// updateRollups rolls up the raw metrics into the complete hours before now, and the hours into the complete days;
+// the first update of the process continues from the newest stored bucket, and only an empty rollup is rolled up
+// from all the stored metrics
+func (m *MongoStorage) updateRollups(ctx context.Context, now time.Time, lookback time.Duration) error {
+	source := m.collection
+	fromRollup := false
+	for _, tier := range []rollup.Tier{rollup.Hourly, rollup.Daily} {
+		target := rollupCollection(m.collection, tier)
+		until := now.UTC().Truncate(tier.Resolution)
+		covered := m.rolledUntil(tier)
+		if covered.IsZero() {
+			// the rollup was covered by the previous process until the end of its newest bucket
+			newest, err := m.newestTimestamp(ctx, target, bson.D{})
+			if err != nil {
+				return err
+			}
+			if !newest.IsZero() {
+				covered = newest.Add(tier.Resolution)
+			}
+		}
+		var from time.Time
+		if !covered.IsZero() {
+			from = covered.Add(-lookback).Truncate(tier.Resolution)
+		}
+
+		pipeline := append(rollupPipeline(rollup.Range{Start: from, End: until}, tier.Unit, fromRollup),
+			bson.D{primitive.E{Key: "$merge", Value: bson.D{
+				primitive.E{Key: "into", Value: target},
+				primitive.E{Key: "on", Value: "timestamp"},
+				primitive.E{Key: "whenMatched", Value: "replace"},
+				primitive.E{Key: "whenNotMatched", Value: "insert"},
+			}}})
+		cursor, err := m.client.Database(m.database).Collection(source).Aggregate(ctx, pipeline)
+		if err != nil {
+			return fmt.Errorf("error while rolling up %s into %s: %w", source, target, err)
+		}
+		cursor.Close(ctx)
+
+		m.setRolledUntil(tier, until)
+		source = target
+		fromRollup = true
+	}
+	return nil
+}
+
+func (m *MongoStorage) rolledUntil(tier rollup.Tier) time.Time {
+	m.rollupMu.RLock()
+	defer m.rollupMu.RUnlock()
+	return m.rollups[tier.Name]
+}
+
+func (m *MongoStorage) setRolledUntil(tier rollup.Tier, until time.Time) {
+	m.rollupMu.Lock()
+	defer m.rollupMu.Unlock()
+	m.rollups[tier.Name] = until
+}
+
+// rollupPipeline summarises the fields of the documents in the range into buckets of the unit; the documents are
+// either raw metrics or the buckets of a finer rollup, whose summaries are combined
+func rollupPipeline(r rollup.Range, unit string, fromRollup bool) mongo.Pipeline {
+	timestamp := primitive.M{"$gte": r.Start, "$lt": r.End}
+	if r.IncludeEnd {
+		timestamp = primitive.M{"$gte": r.Start, "$lte": r.End}
+	}
+	matchStage := bson.D{primitive.E{Key: "$match", Value: bson.D{primitive.E{Key: "timestamp", Value: timestamp}}}}
+
+	// count is the number of metrics of the bucket, which may have either field or both
+	var documents interface{} = 1
+	if fromRollup {
+		documents = "$count"
+	}
+	group := bson.D{
+		primitive.E{Key: "_id", Value: bson.D{
+			primitive.E{Key: "$dateTrunc", Value: primitive.M{"date": "$timestamp", "unit": unit}}}},
+		primitive.E{Key: "count", Value: bson.D{primitive.E{Key: "$sum", Value: documents}}},
+	}
+	project := bson.D{
+		primitive.E{Key: "_id", Value: 0},
+		primitive.E{Key: "timestamp", Value: "$_id"},
+		primitive.E{Key: "count", Value: "$count"},
+	}
+	for _, field := range rollupFields {
+		value := "$" + field
+		count := bson.D{primitive.E{Key: "$cond", Value: bson.A{bson.D{primitive.E{Key: "$isNumber", Value: value}}, 1, 0}}}
+		min, max, sum := value, value, value
+		var countValue interface{} = count
+		if fromRollup {
+			min, max, sum = value+".min", value+".max", value+".sum"
+			countValue = value + ".count"
+		}
+		group = append(group,
+			primitive.E{Key: field + "_min", Value: bson.D{primitive.E{Key: "$min", Value: min}}},
+			primitive.E{Key: field + "_max", Value: bson.D{primitive.E{Key: "$max", Value: max}}},
+			primitive.E{Key: field + "_sum", Value: bson.D{primitive.E{Key: "$sum", Value: sum}}},
+			primitive.E{Key: field + "_count", Value: bson.D{primitive.E{Key: "$sum", Value: countValue}}},
+		)
+
+		avg := bson.D{primitive.E{Key: "$cond", Value: bson.A{
+			bson.D{primitive.E{Key: "$gt", Value: bson.A{"$" + field + "_count", 0}}},
+			bson.D{primitive.E{Key: "$divide", Value: bson.A{"$" + field + "_sum", "$" + field + "_count"}}},
+			nil,
+		}}}
+		project = append(project, primitive.E{Key: field, Value: bson.D{
+			primitive.E{Key: "min", Value: "$" + field + "_min"},
+			primitive.E{Key: "max", Value: "$" + field + "_max"},
+			primitive.E{Key: "avg", Value: avg},
+			primitive.E{Key: "sum", Value: "$" + field + "_sum"},
+			primitive.E{Key: "count", Value: "$" + field + "_count"},
+		}})
+	}
+
+	return mongo.Pipeline{
+		matchStage,
+		bson.D{primitive.E{Key: "$group", Value: group}},
+		bson.D{primitive.E{Key: "$project", Value: project}},
+	}
+}
+
+// getSeriesByRollup returns the averages of the frequency buckets of the query, reading the complete buckets of the
+// tier from its rollup and the edges of the range, not covered by the rollup, from the raw metrics
+func (m *MongoStorage) getSeriesByRollup(ctx context.Context, config model.Query, tier rollup.Tier, coveredUntil time.Time) ([]model.Metric, error) {
+	plan := rollup.PlanQuery(config.StartAt, config.EndAt, tier, coveredUntil)
+	unit := frequencyUnit(config.Frequency)
+
+	var partials []rollup.Partial
+	if plan.Rollup != nil {
+		p, err := m.aggregatePartials(ctx, rollupCollection(m.collection, tier), rollupPipeline(*plan.Rollup, unit, true))
+		if err != nil {
+			return nil, err
+		}
+		partials = append(partials, p...)
+	}
+	for _, r := range plan.Raw {
+		p, err := m.aggregatePartials(ctx, m.collection, rollupPipeline(r, unit, false))
+		if err != nil {
+			return nil, err
+		}
+		partials = append(partials, p...)
+	}
+
+	return rollup.Merge(partials, config.MetricType), nil
+}
+
+func (m *MongoStorage) aggregatePartials(ctx context.Context, collection string, pipeline mongo.Pipeline) ([]rollup.Partial, error) {
+	opts := options.Aggregate().SetMaxTime(2 * time.Second)
+	cursor, err := m.client.Database(m.database).Collection(collection).Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving data: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var partials []rollup.Partial
+	if err := cursor.All(ctx, &partials); err != nil {
+		return nil, err
+	}
+	return partials, nil
+}
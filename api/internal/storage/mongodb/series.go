@@ -0,0 +1,181 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"sky/api/internal/model"
+	"sky/api/internal/storage/rollup"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// seriesInfoTimeout limits the aggregations of the series info, which read the whole collections of the samples and
+// of the distributions
+const seriesInfoTimeout = 30 * time.Second
+
+// seriesInfoDocument is the result of the aggregation of the points of a series
+type seriesInfoDocument struct {
+	Name   string            `bson:"name"`
+	Type   string            `bson:"type"`
+	Labels map[string]string `bson:"labels"`
+	First  time.Time         `bson:"first"`
+	Last   time.Time         `bson:"last"`
+	Count  int64             `bson:"count"`
+	Min    *float64          `bson:"min"`
+	Max    *float64          `bson:"max"`
+}
+
+func (d seriesInfoDocument) info(kind model.SeriesKind) model.SeriesInfo {
+	return model.SeriesInfo{
+		Name:   d.Name,
+		Kind:   kind,
+		Type:   d.Type,
+		Labels: d.Labels,
+		First:  d.First,
+		Last:   d.Last,
+		Count:  d.Count,
+		Min:    d.Min,
+		Max:    d.Max,
+	}
+}
+
+// GetSeriesInfo returns the first and last timestamps, the number and the range of the stored values of the metric
+// type; it returns nil if there are none. The first and last timestamps are read from the timestamp index, and the
+// days covered by the daily rollup are counted from its buckets, so only the edges of the series read raw metrics.
+func (m *MongoStorage) GetSeriesInfo(ctx context.Context, metricType model.MetricType) (*model.SeriesInfo, error) {
+	field := metricType.String()
+	exists := bson.D{primitive.E{Key: field, Value: primitive.M{"$exists": true}}}
+	first, err := m.edgeTimestamp(ctx, m.collection, exists, 1)
+	if err != nil || first.IsZero() {
+		return nil, err
+	}
+	last, err := m.edgeTimestamp(ctx, m.collection, exists, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	db := m.client.Database(m.database)
+	plan := rollup.PlanQuery(first, last, rollup.Daily, m.rolledUntil(rollup.Daily))
+	var docs []seriesInfoDocument
+	if plan.Rollup != nil {
+		var rolledUp []seriesInfoDocument
+		pipeline := seriesRangePipeline(*plan.Rollup, field+".count", bson.D{
+			primitive.E{Key: "_id", Value: ""},
+			primitive.E{Key: "count", Value: bson.D{primitive.E{Key: "$sum", Value: "$" + field + ".count"}}},
+			primitive.E{Key: "min", Value: bson.D{primitive.E{Key: "$min", Value: "$" + field + ".min"}}},
+			primitive.E{Key: "max", Value: bson.D{primitive.E{Key: "$max", Value: "$" + field + ".max"}}},
+		})
+		if err := aggregate(ctx, db.Collection(rollupCollection(m.collection, rollup.Daily)), pipeline, seriesInfoOptions(), &rolledUp); err != nil {
+			return nil, err
+		}
+		docs = append(docs, rolledUp...)
+	}
+	for _, r := range plan.Raw {
+		var raw []seriesInfoDocument
+		pipeline := seriesRangePipeline(r, field, bson.D{
+			primitive.E{Key: "_id", Value: ""},
+			primitive.E{Key: "count", Value: bson.D{primitive.E{Key: "$sum", Value: 1}}},
+			primitive.E{Key: "min", Value: bson.D{primitive.E{Key: "$min", Value: "$" + field}}},
+			primitive.E{Key: "max", Value: bson.D{primitive.E{Key: "$max", Value: "$" + field}}},
+		})
+		if err := aggregate(ctx, db.Collection(m.collection), pipeline, seriesInfoOptions(), &raw); err != nil {
+			return nil, err
+		}
+		docs = append(docs, raw...)
+	}
+
+	info := model.SeriesInfo{Name: field, Kind: model.SeriesKindMetric, First: first, Last: last}
+	for _, doc := range docs {
+		info.Count += doc.Count
+		if doc.Min != nil && (info.Min == nil || *doc.Min < *info.Min) {
+			info.Min = doc.Min
+		}
+		if doc.Max != nil && (info.Max == nil || *doc.Max > *info.Max) {
+			info.Max = doc.Max
+		}
+	}
+	return &info, nil
+}
+
+// ListSeries returns the info of every stored series: the metric types, and the series of the samples and of the
+// distributions by name and labels
+func (m *MongoStorage) ListSeries(ctx context.Context) ([]model.SeriesInfo, error) {
+	series := make([]model.SeriesInfo, 0)
+	for _, metricType := range []model.MetricType{model.MetricTypeCPULoad, model.MetricTypeConcurrency} {
+		info, err := m.GetSeriesInfo(ctx, metricType)
+		if err != nil {
+			return nil, err
+		}
+		if info != nil {
+			series = append(series, *info)
+		}
+	}
+
+	db := m.client.Database(m.database)
+	for _, c := range []struct {
+		collection string
+		kind       model.SeriesKind
+		value      string
+	}{
+		{m.collection + samplesSuffix, model.SeriesKindSample, "$value"},
+		// the distributions have no single value to take the range of
+		{m.collection + distributionsSuffix, model.SeriesKindDistribution, ""},
+	} {
+		pipeline := mongo.Pipeline{
+			seriesInfoGroup("$meta.series", c.value),
+			bson.D{primitive.E{Key: "$sort", Value: bson.D{primitive.E{Key: "_id", Value: 1}}}},
+		}
+		var docs []seriesInfoDocument
+		if err := aggregate(ctx, db.Collection(c.collection), pipeline, seriesInfoOptions(), &docs); err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			series = append(series, doc.info(c.kind))
+		}
+	}
+	return series, nil
+}
+
+// seriesRangePipeline groups the documents of the range that have the field
+func seriesRangePipeline(r rollup.Range, field string, group bson.D) mongo.Pipeline {
+	timestamp := primitive.M{"$gte": r.Start, "$lt": r.End}
+	if r.IncludeEnd {
+		timestamp = primitive.M{"$gte": r.Start, "$lte": r.End}
+	}
+	return mongo.Pipeline{
+		bson.D{primitive.E{Key: "$match", Value: bson.D{
+			primitive.E{Key: "timestamp", Value: timestamp},
+			primitive.E{Key: field, Value: primitive.M{"$exists": true}},
+		}}},
+		bson.D{primitive.E{Key: "$group", Value: group}},
+	}
+}
+
+// seriesInfoGroup groups the points by the id, taking the name, type and labels from the meta field of the samples,
+// and the range of the value, unless it is empty
+func seriesInfoGroup(id interface{}, value string) bson.D {
+	group := bson.D{
+		primitive.E{Key: "_id", Value: id},
+		primitive.E{Key: "name", Value: bson.D{primitive.E{Key: "$first", Value: "$meta.name"}}},
+		primitive.E{Key: "type", Value: bson.D{primitive.E{Key: "$first", Value: "$meta.type"}}},
+		primitive.E{Key: "labels", Value: bson.D{primitive.E{Key: "$first", Value: "$meta.labels"}}},
+		primitive.E{Key: "first", Value: bson.D{primitive.E{Key: "$min", Value: "$timestamp"}}},
+		primitive.E{Key: "last", Value: bson.D{primitive.E{Key: "$max", Value: "$timestamp"}}},
+		primitive.E{Key: "count", Value: bson.D{primitive.E{Key: "$sum", Value: 1}}},
+	}
+	if value != "" {
+		group = append(group,
+			primitive.E{Key: "min", Value: bson.D{primitive.E{Key: "$min", Value: value}}},
+			primitive.E{Key: "max", Value: bson.D{primitive.E{Key: "$max", Value: value}}},
+		)
+	}
+	return bson.D{primitive.E{Key: "$group", Value: group}}
+}
+
+func seriesInfoOptions() *options.AggregateOptions {
+	return options.Aggregate().SetMaxTime(seriesInfoTimeout)
+}
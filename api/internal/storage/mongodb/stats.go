@@ -0,0 +1,152 @@
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"sky/api/internal/model"
+	"sky/api/internal/storage/rollup"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// collStatsDocument is the storageStats of the $collStats stage; the timeseries stats are only set for the
+// timeseries collections
+type collStatsDocument struct {
+	StorageStats struct {
+		Size           int64  `bson:"size"`
+		Count          *int64 `bson:"count"`
+		StorageSize    int64  `bson:"storageSize"`
+		TotalIndexSize int64  `bson:"totalIndexSize"`
+		Timeseries     *struct {
+			BucketCount   int64 `bson:"bucketCount"`
+			AvgBucketSize int64 `bson:"avgBucketSize"`
+		} `bson:"timeseries"`
+	} `bson:"storageStats"`
+}
+
+// GetCollectionStats returns the storage usage of the collections of the raw points and of the rollups
+func (m *MongoStorage) GetCollectionStats(ctx context.Context) ([]model.CollectionStats, error) {
+	names := append(rawCollections(m.collection), rollupCollection(m.collection, rollup.Hourly), rollupCollection(m.collection, rollup.Daily))
+	pipeline := mongo.Pipeline{
+		bson.D{primitive.E{Key: "$collStats", Value: bson.D{primitive.E{Key: "storageStats", Value: bson.D{}}}}},
+	}
+
+	db := m.client.Database(m.database)
+	stats := make([]model.CollectionStats, 0, len(names))
+	for _, name := range names {
+		var docs []collStatsDocument
+		if err := aggregate(ctx, db.Collection(name), pipeline, options.Aggregate(), &docs); err != nil {
+			return nil, fmt.Errorf("error while retrieving the stats of %s: %w", name, err)
+		}
+		if len(docs) == 0 {
+			continue
+		}
+		s := docs[0].StorageStats
+		collStats := model.CollectionStats{
+			Name:        name,
+			Size:        s.Size,
+			StorageSize: s.StorageSize,
+			IndexSize:   s.TotalIndexSize,
+			Documents:   s.Count,
+		}
+		if s.StorageSize > 0 {
+			ratio := float64(s.Size) / float64(s.StorageSize)
+			collStats.CompressionRatio = &ratio
+		}
+		if s.Timeseries != nil {
+			collStats.Buckets = &s.Timeseries.BucketCount
+			collStats.AvgBucketSize = &s.Timeseries.AvgBucketSize
+			// the documents of a timeseries collection are counted by day with GetDailyCounts instead
+			collStats.Documents = nil
+		}
+		stats = append(stats, collStats)
+	}
+	return stats, nil
+}
+
+// GetDailyCounts returns the number of metrics stored for each day of the range, with the days starting at midnight
+// in the location; the days without metrics are left out. The days older than the raw retention, whose metrics have
+// expired, are counted from the daily rollup, whose buckets are UTC days: they are returned as the midnight of the
+// same date in the location.
+func (m *MongoStorage) GetDailyCounts(ctx context.Context, start, end time.Time, loc *time.Location) ([]model.DailyCount, error) {
+	retention, err := m.GetRetention(ctx)
+	if err != nil {
+		return nil, err
+	}
+	db := m.client.Database(m.database)
+	days := make(map[time.Time]int64)
+
+	rawStart := start
+	if retention.Raw > 0 {
+		// the raw metrics of the day of the expiry may be partly expired already
+		expired := time.Now().Add(-time.Duration(retention.Raw) * time.Second).UTC().Truncate(24 * time.Hour)
+		if start.Before(expired) {
+			// the buckets rolled up before the count of the metrics was kept have the counts of the fields only
+			count := bson.D{primitive.E{Key: "$ifNull", Value: bson.A{"$count", bson.D{primitive.E{Key: "$max", Value: bson.A{"$cpu_load.count", "$concurrency.count"}}}}}}
+			pipeline := mongo.Pipeline{
+				bson.D{primitive.E{Key: "$match", Value: bson.D{primitive.E{Key: "timestamp", Value: primitive.M{
+					"$gte": start.UTC().Truncate(24 * time.Hour), "$lt": expired, "$lte": end}}}}},
+				bson.D{primitive.E{Key: "$project", Value: bson.D{
+					primitive.E{Key: "_id", Value: "$timestamp"},
+					primitive.E{Key: "count", Value: count},
+				}}},
+			}
+			docs, err := dailyCounts(ctx, db.Collection(rollupCollection(m.collection, rollup.Daily)), pipeline)
+			if err != nil {
+				return nil, err
+			}
+			for _, doc := range docs {
+				year, month, day := doc.Day.UTC().Date()
+				days[time.Date(year, month, day, 0, 0, 0, 0, loc)] += doc.Count
+			}
+			rawStart = expired
+		}
+	}
+
+	if !end.Before(rawStart) {
+		pipeline := mongo.Pipeline{
+			bson.D{primitive.E{Key: "$match", Value: bson.D{primitive.E{Key: "timestamp", Value: bson.D{
+				primitive.E{Key: "$gte", Value: primitive.NewDateTimeFromTime(rawStart)},
+				primitive.E{Key: "$lte", Value: primitive.NewDateTimeFromTime(end)},
+			}}}}},
+			bson.D{primitive.E{Key: "$group", Value: bson.D{
+				primitive.E{Key: "_id", Value: bson.D{primitive.E{Key: "$dateTrunc", Value: primitive.M{
+					"date": "$timestamp", "unit": "day", "timezone": loc.String()}}}},
+				primitive.E{Key: "count", Value: bson.D{primitive.E{Key: "$sum", Value: 1}}},
+			}}},
+		}
+		docs, err := dailyCounts(ctx, db.Collection(m.collection), pipeline)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			days[doc.Day.In(loc)] += doc.Count
+		}
+	}
+
+	counts := make([]model.DailyCount, 0, len(days))
+	for day, count := range days {
+		counts = append(counts, model.DailyCount{Day: day, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Day.Before(counts[j].Day) })
+	return counts, nil
+}
+
+type dailyCountDocument struct {
+	Day   time.Time `bson:"_id"`
+	Count int64     `bson:"count"`
+}
+
+func dailyCounts(ctx context.Context, collection *mongo.Collection, pipeline mongo.Pipeline) ([]dailyCountDocument, error) {
+	var docs []dailyCountDocument
+	if err := aggregate(ctx, collection, pipeline, seriesInfoOptions(), &docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
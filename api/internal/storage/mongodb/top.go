@@ -0,0 +1,66 @@
+package mongodb
+
+import (
+	"context"
+	"time"
+
+	"sky/api/internal/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetTop returns the k points of the metric type with the highest values in the time range, or the lowest ones if
+// ascending is set, from the highest (lowest) down; with a frequency, the points are the averages of its buckets.
+// The sorting and the limit run in the aggregation, so only the k points are read.
+func (m *MongoStorage) GetTop(ctx context.Context, config model.Query, k int, ascending bool) ([]model.Metric, error) {
+	field := config.MetricType.String()
+	pipeline := mongo.Pipeline{
+		bson.D{primitive.E{Key: "$match", Value: bson.D{
+			primitive.E{Key: "timestamp", Value: primitive.M{"$lte": config.EndAt, "$gte": config.StartAt}},
+			primitive.E{Key: field, Value: primitive.M{"$exists": true}},
+		}}},
+	}
+	if config.Frequency != model.FrequencyNone {
+		pipeline = append(pipeline,
+			bson.D{primitive.E{Key: "$group", Value: bson.D{
+				primitive.E{Key: "_id", Value: bson.D{primitive.E{Key: "$dateTrunc", Value: primitive.M{
+					"date": "$timestamp", "unit": frequencyUnit(config.Frequency)}}}},
+				primitive.E{Key: field, Value: bson.D{primitive.E{Key: "$avg", Value: "$" + field}}},
+			}}},
+			bson.D{primitive.E{Key: "$set", Value: bson.D{primitive.E{Key: "timestamp", Value: "$_id"}}}},
+		)
+		if config.MetricType == model.MetricTypeConcurrency {
+			// rounded like the timeline, instead of being truncated when decoded
+			pipeline = append(pipeline, bson.D{primitive.E{Key: "$set", Value: bson.D{
+				primitive.E{Key: field, Value: roundConcurrency("$" + field)}}}})
+		}
+	}
+
+	direction := -1
+	if ascending {
+		direction = 1
+	}
+	pipeline = append(pipeline,
+		// the earlier point comes first among the equal values, so the results are stable
+		bson.D{primitive.E{Key: "$sort", Value: bson.D{
+			primitive.E{Key: field, Value: direction},
+			primitive.E{Key: "timestamp", Value: 1},
+		}}},
+		bson.D{primitive.E{Key: "$limit", Value: k}},
+		bson.D{primitive.E{Key: "$project", Value: bson.D{
+			primitive.E{Key: "_id", Value: 0},
+			primitive.E{Key: "timestamp", Value: 1},
+			primitive.E{Key: field, Value: 1},
+		}}},
+	)
+
+	var results []model.Metric
+	opts := options.Aggregate().SetMaxTime(2 * time.Second)
+	if err := aggregate(ctx, m.client.Database(m.database).Collection(m.collection), pipeline, opts, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
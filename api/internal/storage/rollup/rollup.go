@@ -0,0 +1,143 @@
+package rollup
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"sky/api/internal/model"
+)
+
+// Tier is a resolution the raw metrics are rolled up to
+type Tier struct {
+	// Name is appended to the name of the metrics collection for the collection of the tier, e.g. metrics_hourly
+	Name       string
+	Resolution time.Duration
+	// Unit is the $dateTrunc unit of the buckets
+	Unit string
+}
+
+var (
+	// Hourly rolls up the raw metrics of an hour
+	Hourly = Tier{Name: "hourly", Resolution: time.Hour, Unit: "hour"}
+	// Daily rolls up the hourly rollups of a day
+	Daily = Tier{Name: "daily", Resolution: 24 * time.Hour, Unit: "day"}
+)
+
+// TierFor returns the coarsest tier whose buckets fit into the buckets of the frequency;
+// ok is false for the frequencies finer than an hour, which are served from the raw metrics
+func TierFor(f model.Frequency) (Tier, bool) {
+	switch f {
+	case model.FrequencyByHours:
+		return Hourly, true
+	case model.FrequencyByDays, model.FrequencyByMonths, model.FrequencyByYears:
+		return Daily, true
+	default:
+		return Tier{}, false
+	}
+}
+
+// Range is a time range starting at Start (inclusive) and ending at End, which is only included if IncludeEnd is set
+type Range struct {
+	Start      time.Time
+	End        time.Time
+	IncludeEnd bool
+}
+
+// Plan splits a query into the range served from a rollup tier and the edges served from the raw metrics
+type Plan struct {
+	// Rollup is the range of the complete tier buckets that are rolled up; empty if the rollup can't be used
+	Rollup *Range
+	Raw    []Range
+}
+
+// PlanQuery plans the [start, end] range of a query for a tier whose buckets are rolled up until coveredUntil;
+// the start of the rollup range is aligned to a bucket, the end to a bucket and to the coverage
+func PlanQuery(start, end time.Time, tier Tier, coveredUntil time.Time) Plan {
+	rollupStart := start.UTC().Truncate(tier.Resolution)
+	if rollupStart.Before(start) {
+		rollupStart = rollupStart.Add(tier.Resolution)
+	}
+	rollupEnd := end.UTC().Truncate(tier.Resolution)
+	if coveredUntil.Before(rollupEnd) {
+		rollupEnd = coveredUntil.UTC().Truncate(tier.Resolution)
+	}
+
+	if !rollupStart.Before(rollupEnd) {
+		return Plan{Raw: []Range{{Start: start, End: end, IncludeEnd: true}}}
+	}
+
+	plan := Plan{Rollup: &Range{Start: rollupStart, End: rollupEnd}}
+	if start.Before(rollupStart) {
+		plan.Raw = append(plan.Raw, Range{Start: start, End: rollupStart})
+	}
+	plan.Raw = append(plan.Raw, Range{Start: rollupEnd, End: end, IncludeEnd: true})
+	return plan
+}
+
+// Aggregate is the summary of the values of a field in a bucket
+type Aggregate struct {
+	Min   float64 `bson:"min"`
+	Max   float64 `bson:"max"`
+	Avg   float64 `bson:"avg"`
+	Sum   float64 `bson:"sum"`
+	Count int64   `bson:"count"`
+}
+
+// Partial is the summary of a frequency bucket computed from a part of the plan
+type Partial struct {
+	Timestamp   time.Time `bson:"timestamp"`
+	CPULoad     Aggregate `bson:"cpu_load"`
+	Concurrency Aggregate `bson:"concurrency"`
+}
+
+// Merge combines the partial summaries of the same buckets into the averages of the buckets, ordered by time;
+// only the fields of the metric type are set
+func Merge(partials []Partial, metricType model.MetricType) []model.Metric {
+	merged := make(map[int64]*Partial)
+	var keys []int64
+	for _, p := range partials {
+		key := p.Timestamp.UnixNano()
+		m, ok := merged[key]
+		if !ok {
+			copied := p
+			merged[key] = &copied
+			keys = append(keys, key)
+			continue
+		}
+		m.CPULoad = combine(m.CPULoad, p.CPULoad)
+		m.Concurrency = combine(m.Concurrency, p.Concurrency)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	metrics := make([]model.Metric, 0, len(keys))
+	for _, key := range keys {
+		p := merged[key]
+		metric := model.Metric{Timestamp: p.Timestamp}
+		if metricType != model.MetricTypeConcurrency && p.CPULoad.Count > 0 {
+			metric.CPULoad = p.CPULoad.Sum / float64(p.CPULoad.Count)
+		}
+		if metricType != model.MetricTypeCPULoad && p.Concurrency.Count > 0 {
+			metric.Concurrency = int32(math.Round(p.Concurrency.Sum / float64(p.Concurrency.Count)))
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics
+}
+
+func combine(a, b Aggregate) Aggregate {
+	switch {
+	case a.Count == 0:
+		return b
+	case b.Count == 0:
+		return a
+	}
+	c := Aggregate{
+		Min:   math.Min(a.Min, b.Min),
+		Max:   math.Max(a.Max, b.Max),
+		Sum:   a.Sum + b.Sum,
+		Count: a.Count + b.Count,
+	}
+	c.Avg = c.Sum / float64(c.Count)
+	return c
+}
@@ -0,0 +1,95 @@
+package rollup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sky/api/internal/model"
+)
+
+func TestPlanQuery(t *testing.T) {
+	day := time.Date(2022, 4, 25, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name           string
+		start, end     time.Time
+		coveredUntil   time.Time
+		expectedRollup *Range
+		expectedRaw    []Range
+	}{
+		{
+			name:           "aligned range",
+			start:          day,
+			end:            day.Add(3 * time.Hour),
+			coveredUntil:   day.Add(24 * time.Hour),
+			expectedRollup: &Range{Start: day, End: day.Add(3 * time.Hour)},
+			expectedRaw:    []Range{{Start: day.Add(3 * time.Hour), End: day.Add(3 * time.Hour), IncludeEnd: true}},
+		},
+		{
+			name:           "partial edges",
+			start:          day.Add(30 * time.Minute),
+			end:            day.Add(3*time.Hour + 15*time.Minute),
+			coveredUntil:   day.Add(24 * time.Hour),
+			expectedRollup: &Range{Start: day.Add(time.Hour), End: day.Add(3 * time.Hour)},
+			expectedRaw: []Range{
+				{Start: day.Add(30 * time.Minute), End: day.Add(time.Hour)},
+				{Start: day.Add(3 * time.Hour), End: day.Add(3*time.Hour + 15*time.Minute), IncludeEnd: true},
+			},
+		},
+		{
+			name:           "rollup lagging behind",
+			start:          day,
+			end:            day.Add(5 * time.Hour),
+			coveredUntil:   day.Add(2 * time.Hour),
+			expectedRollup: &Range{Start: day, End: day.Add(2 * time.Hour)},
+			expectedRaw:    []Range{{Start: day.Add(2 * time.Hour), End: day.Add(5 * time.Hour), IncludeEnd: true}},
+		},
+		{
+			name:         "within a bucket",
+			start:        day.Add(10 * time.Minute),
+			end:          day.Add(50 * time.Minute),
+			coveredUntil: day.Add(24 * time.Hour),
+			expectedRaw:  []Range{{Start: day.Add(10 * time.Minute), End: day.Add(50 * time.Minute), IncludeEnd: true}},
+		},
+	}
+
+	for _, c := range cases {
+		plan := PlanQuery(c.start, c.end, Hourly, c.coveredUntil)
+		assert.Equal(t, c.expectedRollup, plan.Rollup, c.name)
+		assert.Equal(t, c.expectedRaw, plan.Raw, c.name)
+	}
+}
+
+func TestTierFor(t *testing.T) {
+	tier, ok := TierFor(model.FrequencyByHours)
+	assert.True(t, ok)
+	assert.Equal(t, Hourly, tier)
+
+	tier, ok = TierFor(model.FrequencyByYears)
+	assert.True(t, ok)
+	assert.Equal(t, Daily, tier)
+
+	_, ok = TierFor(model.FrequencyByMinutes)
+	assert.False(t, ok)
+}
+
+func TestMerge(t *testing.T) {
+	hour := time.Date(2022, 4, 25, 10, 0, 0, 0, time.UTC)
+	partials := []Partial{
+		{Timestamp: hour.Add(time.Hour), CPULoad: Aggregate{Min: 1, Max: 3, Sum: 4, Count: 2}},
+		// the raw edge and the rollup of the same bucket
+		{Timestamp: hour, CPULoad: Aggregate{Min: 10, Max: 10, Sum: 10, Count: 1}, Concurrency: Aggregate{Min: 5, Max: 5, Sum: 5, Count: 1}},
+		{Timestamp: hour, CPULoad: Aggregate{Min: 20, Max: 40, Sum: 60, Count: 2}, Concurrency: Aggregate{Min: 6, Max: 10, Sum: 16, Count: 2}},
+	}
+
+	assert.Equal(t, []model.Metric{
+		{Timestamp: hour, CPULoad: 70.0 / 3, Concurrency: 7},
+		{Timestamp: hour.Add(time.Hour), CPULoad: 2},
+	}, Merge(partials, model.MetricTypeNone))
+
+	assert.Equal(t, []model.Metric{
+		{Timestamp: hour, Concurrency: 7},
+		{Timestamp: hour.Add(time.Hour)},
+	}, Merge(partials, model.MetricTypeConcurrency))
+}
@@ -0,0 +1,336 @@
+package validation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"sky/api/internal/config"
+	"sky/api/internal/distribution"
+	"sky/api/internal/model"
+)
+
+// ErrInvalid is returned for the points breaking the validation rules
+var ErrInvalid = errors.New("invalid point")
+
+// maxReportedErrors limits how many of the invalid points of a batch are listed in the error
+const maxReportedErrors = 10
+
+// defaultSchemas are the rules of the fields of the metrics collection, unless the configuration overrides them
+var defaultSchemas = map[string]config.MetricSchema{
+	model.MetricTypeCPULoad.String():     {Min: floatPtr(0), Max: floatPtr(100)},
+	model.MetricTypeConcurrency.String(): {Min: floatPtr(0), Max: floatPtr(math.MaxInt32)},
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+// Validator checks the written metrics and samples against the rules of the configuration
+type Validator struct {
+	maxFutureSkew time.Duration
+	maxLabels     int
+	schemas       map[string]config.MetricSchema
+	now           func() time.Time
+}
+
+// NewValidator creates a validator for the rules of the configuration
+func NewValidator(cfg config.ValidationConfig) *Validator {
+	schemas := make(map[string]config.MetricSchema, len(defaultSchemas)+len(cfg.Schemas))
+	for name, schema := range defaultSchemas {
+		schemas[name] = schema
+	}
+	for name, schema := range cfg.Schemas {
+		schemas[name] = schema
+	}
+	return &Validator{
+		maxFutureSkew: time.Duration(cfg.MaxFutureSkew),
+		maxLabels:     cfg.MaxLabels,
+		schemas:       schemas,
+		now:           time.Now,
+	}
+}
+
+// ValidateMetrics returns an error listing the invalid metrics of the batch, or nil if all of them are valid
+func (v *Validator) ValidateMetrics(metrics []model.Metric) error {
+	var errs []string
+	for i, m := range metrics {
+		if err := v.ValidateMetric(m); err != nil {
+			errs = append(errs, fmt.Sprintf("metric %d: %s", i, err.Error()))
+		}
+	}
+	return batchError(errs)
+}
+
+// ValidateMetric checks the timestamp and the set fields of a metric
+func (v *Validator) ValidateMetric(m model.Metric) error {
+	if err := v.validateTimestamp(m.Timestamp); err != nil {
+		return err
+	}
+	if m.CPULoad != 0 {
+		if err := v.validateValue(model.MetricTypeCPULoad.String(), m.CPULoad); err != nil {
+			return err
+		}
+	}
+	if m.Concurrency != 0 {
+		if err := v.validateValue(model.MetricTypeConcurrency.String(), float64(m.Concurrency)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidMetrics returns the valid metrics of the batch and an error listing the invalid ones, if any
+func (v *Validator) ValidMetrics(metrics []model.Metric) ([]model.Metric, error) {
+	valid := make([]model.Metric, 0, len(metrics))
+	var errs []string
+	for _, m := range metrics {
+		if err := v.ValidateMetric(m); err != nil {
+			errs = append(errs, fmt.Sprintf("metric %s: %s", m.Timestamp.Format(time.RFC3339), err.Error()))
+			continue
+		}
+		valid = append(valid, m)
+	}
+	return valid, batchError(errs)
+}
+
+// ValidSamples returns the valid samples of the batch and an error listing the invalid ones, if any
+func (v *Validator) ValidSamples(samples []model.Sample) ([]model.Sample, error) {
+	valid := make([]model.Sample, 0, len(samples))
+	var errs []string
+	for _, s := range samples {
+		if err := v.ValidateSample(s); err != nil {
+			errs = append(errs, fmt.Sprintf("sample %s: %s", s.SeriesID(), err.Error()))
+			continue
+		}
+		valid = append(valid, s)
+	}
+	return valid, batchError(errs)
+}
+
+// ValidateSample checks the timestamp, value, type and labels of a sample against its schema
+func (v *Validator) ValidateSample(s model.Sample) error {
+	if s.Name == "" {
+		return errors.New("name is missing")
+	}
+	if err := v.validateTimestamp(s.Timestamp); err != nil {
+		return err
+	}
+	if err := v.validateValue(s.Name, s.Value); err != nil {
+		return err
+	}
+	if len(s.Labels) > v.maxLabels {
+		return fmt.Errorf("has %d labels, more than the maximum of %d", len(s.Labels), v.maxLabels)
+	}
+
+	schema, ok := v.schemas[s.Name]
+	if !ok {
+		return nil
+	}
+	if schema.Type != "" && schema.Type != s.Type {
+		return fmt.Errorf("type %s is not the declared %s", s.Type, schema.Type)
+	}
+	for _, label := range schema.RequiredLabels {
+		if _, ok := s.Labels[label]; !ok {
+			return fmt.Errorf("required label %s is missing", label)
+		}
+	}
+	if len(schema.AllowedLabels) > 0 {
+		allowed := make(map[string]bool, len(schema.AllowedLabels))
+		for _, label := range schema.AllowedLabels {
+			allowed[label] = true
+		}
+		for label := range s.Labels {
+			if !allowed[label] {
+				return fmt.Errorf("label %s is not allowed", label)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidDistributions returns the valid distributions of the batch and an error listing the invalid ones, if any
+func (v *Validator) ValidDistributions(dists []model.Distribution) ([]model.Distribution, error) {
+	valid := make([]model.Distribution, 0, len(dists))
+	var errs []string
+	for _, d := range dists {
+		if err := v.ValidateDistribution(d); err != nil {
+			errs = append(errs, fmt.Sprintf("distribution %s: %s", d.SeriesID(), err.Error()))
+			continue
+		}
+		valid = append(valid, d)
+	}
+	return valid, batchError(errs)
+}
+
+// ValidateDistribution checks the timestamp, labels and buckets of a distribution
+func (v *Validator) ValidateDistribution(d model.Distribution) error {
+	if d.Name == "" {
+		return errors.New("name is missing")
+	}
+	if err := v.validateTimestamp(d.Timestamp); err != nil {
+		return err
+	}
+	if len(d.Labels) > v.maxLabels {
+		return fmt.Errorf("has %d labels, more than the maximum of %d", len(d.Labels), v.maxLabels)
+	}
+	return distribution.Validate(d)
+}
+
+func (v *Validator) validateTimestamp(t time.Time) error {
+	if t.IsZero() {
+		return errors.New("timestamp is missing")
+	}
+	if limit := v.now().Add(v.maxFutureSkew); t.After(limit) {
+		return fmt.Errorf("timestamp %s is in the future; the latest accepted is %s", t.UTC(), limit.UTC())
+	}
+	return nil
+}
+
+// validateValue rejects NaN and infinite values, and the values outside the range of the schema of the name
+func (v *Validator) validateValue(name string, value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("%s value %v is not a finite number", name, value)
+	}
+	schema := v.schemas[name]
+	if (schema.Min != nil && value < *schema.Min) || (schema.Max != nil && value > *schema.Max) {
+		return fmt.Errorf("%s value %v is out of the range [%s, %s]", name, value, bound(schema.Min, "-Inf"), bound(schema.Max, "+Inf"))
+	}
+	return nil
+}
+
+func bound(b *float64, unset string) string {
+	if b == nil {
+		return unset
+	}
+	return fmt.Sprint(*b)
+}
+
+func batchError(errs []string) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	if len(errs) > maxReportedErrors {
+		errs = append(errs[:maxReportedErrors], fmt.Sprintf("and %d more", len(errs)-maxReportedErrors))
+	}
+	return fmt.Errorf("%w: %s", ErrInvalid, strings.Join(errs, "; "))
+}
+
+// jsonMetric is a model.Metric with the concurrency kept as a number, so it can be checked before converting it
+type jsonMetric struct {
+	Timestamp   time.Time   `json:"timestamp"`
+	CPULoad     float64     `json:"cpu_load"`
+	Concurrency json.Number `json:"concurrency"`
+}
+
+// DecodeMetrics decodes a json array of metrics; a concurrency that is not a whole number or doesn't fit into the
+// stored 32 bit integer is an ErrInvalid error, instead of being truncated
+func DecodeMetrics(r io.Reader) ([]model.Metric, error) {
+	var raw []jsonMetric
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	metrics := make([]model.Metric, 0, len(raw))
+	var errs []string
+	for i, m := range raw {
+		var concurrency int64
+		if m.Concurrency != "" {
+			var err error
+			concurrency, err = m.Concurrency.Int64()
+			if err != nil || concurrency < math.MinInt32 || concurrency > math.MaxInt32 {
+				errs = append(errs, fmt.Sprintf("metric %d: concurrency %s is not a 32 bit integer", i, m.Concurrency))
+				continue
+			}
+		}
+		metrics = append(metrics, model.Metric{
+			Timestamp:   m.Timestamp,
+			CPULoad:     m.CPULoad,
+			Concurrency: int32(concurrency),
+		})
+	}
+	if err := batchError(errs); err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// MetricWriter is an interface for saving metrics
+type MetricWriter interface {
+	InsertMetrics(ctx context.Context, metrics []model.Metric) error
+}
+
+// metricsWriter validates the metrics before saving them
+type metricsWriter struct {
+	writer    MetricWriter
+	validator *Validator
+}
+
+// NewMetricWriter wraps the writer of the collector; like with NewSampleWriter, the valid metrics of a batch are
+// saved and the invalid ones are returned in a *model.RejectedError
+func NewMetricWriter(writer MetricWriter, validator *Validator) MetricWriter {
+	return &metricsWriter{
+		writer:    writer,
+		validator: validator,
+	}
+}
+
+func (w *metricsWriter) InsertMetrics(ctx context.Context, metrics []model.Metric) error {
+	valid, err := w.validator.ValidMetrics(metrics)
+	rejected := rejectedError(len(metrics)-len(valid), err)
+	if len(valid) == 0 {
+		return rejected
+	}
+	return model.MergeRejected(rejected, w.writer.InsertMetrics(ctx, valid))
+}
+
+// SampleWriter is an interface for saving samples and distributions
+type SampleWriter interface {
+	InsertSamples(ctx context.Context, samples []model.Sample) error
+	InsertDistributions(ctx context.Context, dists []model.Distribution) error
+}
+
+// samplesWriter validates the samples and the distributions before saving them
+type samplesWriter struct {
+	writer    SampleWriter
+	validator *Validator
+}
+
+// NewSampleWriter wraps the writer of the ingestion subsystems; the valid samples and distributions of a batch are
+// saved, and the invalid ones are returned in a *model.RejectedError, merged with the rejections of the writer
+func NewSampleWriter(writer SampleWriter, validator *Validator) SampleWriter {
+	return &samplesWriter{
+		writer:    writer,
+		validator: validator,
+	}
+}
+
+func (w *samplesWriter) InsertSamples(ctx context.Context, samples []model.Sample) error {
+	valid, err := w.validator.ValidSamples(samples)
+	rejected := rejectedError(len(samples)-len(valid), err)
+	if len(valid) == 0 {
+		return rejected
+	}
+	return model.MergeRejected(rejected, w.writer.InsertSamples(ctx, valid))
+}
+
+func (w *samplesWriter) InsertDistributions(ctx context.Context, dists []model.Distribution) error {
+	valid, err := w.validator.ValidDistributions(dists)
+	rejected := rejectedError(len(dists)-len(valid), err)
+	if len(valid) == 0 {
+		return rejected
+	}
+	return model.MergeRejected(rejected, w.writer.InsertDistributions(ctx, valid))
+}
+
+func rejectedError(rejected int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &model.RejectedError{Rejected: rejected, Err: err}
+}
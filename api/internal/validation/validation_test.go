@@ -0,0 +1,183 @@
+package validation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sky/api/internal/config"
+	"sky/api/internal/model"
+)
+
+func TestValidateMetric(t *testing.T) {
+	now := time.Unix(1650843741, 0)
+	v := NewValidator(config.ValidationConfig{MaxFutureSkew: config.Duration(time.Minute), MaxLabels: 2})
+	v.now = func() time.Time { return now }
+
+	cases := []struct {
+		description string
+		metric      model.Metric
+		valid       bool
+	}{
+		{"valid", model.Metric{Timestamp: now, CPULoad: 42, Concurrency: 100}, true},
+		{"missing timestamp", model.Metric{CPULoad: 42}, false},
+		{"within the future skew", model.Metric{Timestamp: now.Add(30 * time.Second), CPULoad: 42}, true},
+		{"far future", model.Metric{Timestamp: now.Add(time.Hour), CPULoad: 42}, false},
+		{"cpu load above 100", model.Metric{Timestamp: now, CPULoad: 100.5}, false},
+		{"negative cpu load", model.Metric{Timestamp: now, CPULoad: -1}, false},
+		{"NaN cpu load", model.Metric{Timestamp: now, CPULoad: math.NaN()}, false},
+		{"negative concurrency", model.Metric{Timestamp: now, Concurrency: -5}, false},
+	}
+	for _, c := range cases {
+		err := v.ValidateMetric(c.metric)
+		assert.Equal(t, c.valid, err == nil, c.description)
+	}
+
+	err := v.ValidateMetrics([]model.Metric{{Timestamp: now}, {Timestamp: now, CPULoad: 200}})
+	assert.True(t, errors.Is(err, ErrInvalid))
+	assert.Contains(t, err.Error(), "metric 1: cpu_load value 200 is out of the range [0, 100]")
+}
+
+func TestValidSamples(t *testing.T) {
+	now := time.Now()
+	upper := 1.0
+	v := NewValidator(config.ValidationConfig{
+		MaxFutureSkew: config.Duration(time.Minute),
+		MaxLabels:     2,
+		Schemas: map[string]config.MetricSchema{
+			"ratio": {Type: model.SampleTypeGauge, Max: &upper, RequiredLabels: []string{"host"}, AllowedLabels: []string{"host", "env"}},
+		},
+	})
+
+	samples := []model.Sample{
+		{Timestamp: now, Name: "ratio", Type: model.SampleTypeGauge, Labels: map[string]string{"host": "a"}, Value: 0.5},
+		{Timestamp: now, Name: "ratio", Type: model.SampleTypeGauge, Labels: map[string]string{"host": "a"}, Value: 1.5},
+		{Timestamp: now, Name: "ratio", Type: model.SampleTypeCounter, Labels: map[string]string{"host": "a"}, Value: 0.5},
+		{Timestamp: now, Name: "ratio", Type: model.SampleTypeGauge, Labels: map[string]string{"env": "prod"}, Value: 0.5},
+		{Timestamp: now, Name: "ratio", Type: model.SampleTypeGauge, Labels: map[string]string{"host": "a", "pod": "b"}, Value: 0.5},
+		{Timestamp: now, Name: "other", Labels: map[string]string{"a": "1", "b": "2", "c": "3"}, Value: 1},
+		{Timestamp: now, Name: "other", Value: math.Inf(1)},
+		{Timestamp: now, Name: "other", Value: -1},
+	}
+	valid, err := v.ValidSamples(samples)
+	assert.True(t, errors.Is(err, ErrInvalid))
+	assert.Equal(t, []model.Sample{samples[0], samples[7]}, valid)
+}
+
+func TestValidDistributions(t *testing.T) {
+	now := time.Now()
+	v := NewValidator(config.ValidationConfig{MaxFutureSkew: config.Duration(time.Minute), MaxLabels: 1})
+
+	dists := []model.Distribution{
+		{Timestamp: now, Name: "latency", Bounds: []float64{0.1, 1}, Counts: []int64{1, 2, 0}, Sum: 1.2, Count: 3},
+		{Timestamp: now, Name: "latency", Bounds: []float64{0.1, 1}, Counts: []int64{1, 2}, Sum: 1.2, Count: 3},
+		{Timestamp: now, Name: "latency", Bounds: []float64{0.1, 1}, Counts: []int64{1, 2, 0}, Sum: 1.2, Count: 4},
+		{Timestamp: now, Name: "latency", Labels: map[string]string{"a": "1", "b": "2"}, Counts: []int64{0}},
+		{Timestamp: now.Add(time.Hour), Name: "latency", Counts: []int64{0}},
+		{Timestamp: now, Counts: []int64{0}},
+	}
+	valid, err := v.ValidDistributions(dists)
+	assert.True(t, errors.Is(err, ErrInvalid))
+	assert.Equal(t, dists[:1], valid)
+}
+
+func TestDecodeMetrics(t *testing.T) {
+	metrics, err := DecodeMetrics(strings.NewReader(`[{"timestamp":"2022-04-25T00:00:00Z","cpu_load":12.5,"concurrency":2147483647}]`))
+	assert.Nil(t, err)
+	assert.Equal(t, int32(math.MaxInt32), metrics[0].Concurrency)
+	assert.Equal(t, 12.5, metrics[0].CPULoad)
+
+	for _, body := range []string{
+		`[{"timestamp":"2022-04-25T00:00:00Z","concurrency":2147483648}]`,
+		`[{"timestamp":"2022-04-25T00:00:00Z","concurrency":12.5}]`,
+	} {
+		_, err := DecodeMetrics(strings.NewReader(body))
+		assert.True(t, errors.Is(err, ErrInvalid), body)
+	}
+}
+
+func TestSampleWriter(t *testing.T) {
+	now := time.Now()
+	duplicate := errors.New("duplicate point")
+	inner := &mockSampleWriter{err: &model.RejectedError{Rejected: 1, Err: fmt.Errorf("%w: sample ratio is already stored", duplicate)}}
+	w := NewSampleWriter(inner, NewValidator(config.ValidationConfig{MaxFutureSkew: config.Duration(time.Minute), MaxLabels: 2}))
+
+	samples := []model.Sample{
+		{Timestamp: now, Name: "ratio", Value: 0.5},
+		{Timestamp: now, Name: "ratio", Value: math.NaN()},
+		{Timestamp: now, Name: "ratio", Value: 0.7},
+	}
+	err := w.InsertSamples(context.Background(), samples)
+	assert.Equal(t, []model.Sample{samples[0], samples[2]}, inner.samples)
+	// the invalid sample and the one rejected by the inner writer are both reported
+	var rejected *model.RejectedError
+	if assert.ErrorAs(t, err, &rejected) {
+		assert.Equal(t, 2, rejected.Rejected)
+	}
+	assert.ErrorIs(t, err, ErrInvalid)
+	assert.ErrorIs(t, err, duplicate)
+
+	// a batch without valid points isn't written
+	inner = &mockSampleWriter{}
+	w = NewSampleWriter(inner, NewValidator(config.ValidationConfig{MaxFutureSkew: config.Duration(time.Minute)}))
+	err = w.InsertDistributions(context.Background(), []model.Distribution{{Timestamp: now, Counts: []int64{0}}})
+	if assert.ErrorAs(t, err, &rejected) {
+		assert.Equal(t, 1, rejected.Rejected)
+	}
+	assert.ErrorIs(t, err, ErrInvalid)
+	assert.Empty(t, inner.dists)
+}
+
+func TestMetricWriter(t *testing.T) {
+	now := time.Now()
+	inner := &mockMetricWriter{}
+	w := NewMetricWriter(inner, NewValidator(config.ValidationConfig{MaxFutureSkew: config.Duration(time.Minute)}))
+
+	metrics := []model.Metric{
+		{Timestamp: now, CPULoad: 12},
+		{Timestamp: now.Add(time.Second), CPULoad: 120},
+	}
+	err := w.InsertMetrics(context.Background(), metrics)
+	assert.Equal(t, metrics[:1], inner.metrics)
+	var rejected *model.RejectedError
+	if assert.ErrorAs(t, err, &rejected) {
+		assert.Equal(t, 1, rejected.Rejected)
+	}
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	// a batch without valid points isn't written
+	inner = &mockMetricWriter{}
+	w = NewMetricWriter(inner, NewValidator(config.ValidationConfig{MaxFutureSkew: config.Duration(time.Minute)}))
+	assert.ErrorIs(t, w.InsertMetrics(context.Background(), []model.Metric{{Timestamp: now.Add(time.Hour), CPULoad: 12}}), ErrInvalid)
+	assert.Empty(t, inner.metrics)
+}
+
+type mockMetricWriter struct {
+	metrics []model.Metric
+}
+
+func (m *mockMetricWriter) InsertMetrics(ctx context.Context, metrics []model.Metric) error {
+	m.metrics = append(m.metrics, metrics...)
+	return nil
+}
+
+type mockSampleWriter struct {
+	samples []model.Sample
+	dists   []model.Distribution
+	err     error
+}
+
+func (m *mockSampleWriter) InsertSamples(ctx context.Context, samples []model.Sample) error {
+	m.samples = append(m.samples, samples...)
+	return m.err
+}
+
+func (m *mockSampleWriter) InsertDistributions(ctx context.Context, dists []model.Distribution) error {
+	m.dists = append(m.dists, dists...)
+	return m.err
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -14,11 +15,24 @@ import (
 
 	"github.com/gorilla/mux"
 
+	"sky/api/internal/alerting"
+	"sky/api/internal/collector"
+	"sky/api/internal/config"
+	"sky/api/internal/graphite"
 	"sky/api/internal/handler"
+	"sky/api/internal/otlp"
+	"sky/api/internal/pipeline"
+	"sky/api/internal/rules"
+	"sky/api/internal/scraper"
+	"sky/api/internal/statsd"
 	"sky/api/internal/storage/mongodb"
+	"sky/api/internal/validation"
 )
 
-var dbAddress, dbName, collectionName string
+var dbAddress, dbName, collectionName, configPath string
+
+var procPath, concurrencySource string
+var collectInterval time.Duration
 
 func main() {
 	app := &cli.App{
@@ -41,15 +55,82 @@ func main() {
 				Destination: &collectionName,
 				Value:       "metrics",
 			},
+			&cli.StringFlag{
+				Name:        "config",
+				Usage:       "The path of the json configuration file of the ingestion subsystems",
+				Destination: &configPath,
+			},
 		},
 		Action: func(c *cli.Context) error {
+			cfg, err := config.Load(configPath)
+			if err != nil {
+				return err
+			}
+
 			ctx := context.Background()
-			store, err := mongodb.NewMongoStorage(ctx, dbAddress, "api", dbName, collectionName)
+			store, err := mongodb.NewMongoStorage(ctx, dbAddress, "api", dbName, collectionName, cfg.Retention.Seconds())
 			if err != nil {
 				return err
 			}
+			if err := store.SetConflictPolicy(ctx, cfg.Writes.ConflictPolicy); err != nil {
+				return err
+			}
+			store.SetLatePolicy(cfg.Writes.LatePolicy, time.Duration(cfg.Writes.LateTolerance))
 
-			return run(ctx, store)
+			return run(ctx, store, cfg)
+		},
+		Commands: []*cli.Command{
+			{
+				Name:  "collect",
+				Usage: "Samples the cpu load and concurrency of the host and saves them in the timeseries database",
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:        "interval",
+						Usage:       "How often the host metrics are sampled",
+						Destination: &collectInterval,
+						Value:       time.Minute,
+					},
+					&cli.StringFlag{
+						Name:        "procPath",
+						Usage:       "The mount point of the proc filesystem",
+						Destination: &procPath,
+						Value:       "/proc",
+					},
+					&cli.StringFlag{
+						Name:        "concurrency",
+						Usage:       "What is counted as concurrency: connections (established tcp connections) or processes",
+						Destination: &concurrencySource,
+						Value:       collector.ConcurrencyConnections,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					cfg, err := config.Load(configPath)
+					if err != nil {
+						return err
+					}
+
+					ctx := context.Background()
+					store, err := mongodb.NewMongoStorage(ctx, dbAddress, "collector", dbName, collectionName, cfg.Retention.Seconds())
+					if err != nil {
+						return err
+					}
+					if err := store.SetConflictPolicy(ctx, cfg.Writes.ConflictPolicy); err != nil {
+						return err
+					}
+					store.SetLatePolicy(cfg.Writes.LatePolicy, time.Duration(cfg.Writes.LateTolerance))
+
+					writer := validation.NewMetricWriter(store, validation.NewValidator(cfg.Validation))
+					coll, err := collector.NewCollector(writer, procPath, collectInterval, concurrencySource)
+					if err != nil {
+						return err
+					}
+
+					ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+					defer stop()
+					log.Printf("Collecting host metrics every %s", collectInterval)
+					return coll.Run(ctx)
+				},
+			},
 		},
 	}
 
@@ -58,9 +139,108 @@ func main() {
 	}
 }
 
-func run(ctx context.Context, store handler.Store) error {
+// Storage is the store of the api and of the ingestion subsystems
+type Storage interface {
+	handler.Store
+	pipeline.Writer
+	scraper.Writer
+	handler.RetentionStore
+	handler.SampleStore
+	handler.DistributionStore
+	handler.SeriesStore
+	handler.StatsStore
+	rules.Reader
+	RunRollups(ctx context.Context, interval, lookback time.Duration)
+}
+
+func run(ctx context.Context, store Storage, cfg *config.Config) error {
+	validator := validation.NewValidator(cfg.Validation)
+	samples := validation.NewSampleWriter(store, validator)
+
+	// the background subsystems are stopped (cancel) and waited for (wg) when the server shuts down
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if len(cfg.Scrape.Targets) > 0 {
+		log.Printf("Scraping %d prometheus targets every %s", len(cfg.Scrape.Targets), time.Duration(cfg.Scrape.Interval))
+		scr := scraper.NewScraper(samples, cfg.Scrape)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scr.Run(ctx)
+		}()
+	}
+
+	if cfg.StatsD.Address != "" {
+		listener, err := statsd.NewListener(samples, cfg.StatsD)
+		if err != nil {
+			return err
+		}
+		log.Printf("Receiving statsd metrics on %s", listener.Addr())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			listener.Run(ctx)
+		}()
+	}
+	if cfg.Graphite.Address != "" {
+		listener, err := graphite.NewListener(samples, cfg.Graphite)
+		if err != nil {
+			return err
+		}
+		log.Printf("Receiving graphite metrics on %s", listener.Addr())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			listener.Run(ctx)
+		}()
+	}
+
+	if cfg.Rollups.Enabled {
+		log.Printf("Rolling up the metrics every %s", time.Duration(cfg.Rollups.Interval))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.RunRollups(ctx, time.Duration(cfg.Rollups.Interval), time.Duration(cfg.Rollups.Lookback))
+		}()
+	}
+
+	if len(cfg.Rules.Recording) > 0 {
+		scheduler, err := rules.NewScheduler(store, samples, cfg.Rules)
+		if err != nil {
+			return err
+		}
+		log.Printf("Evaluating %d recording rules", len(cfg.Rules.Recording))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scheduler.Run(ctx)
+		}()
+	}
+
+	if len(cfg.Alerting.Rules) > 0 {
+		engine, err := alerting.NewEngine(store, cfg.Alerting)
+		if err != nil {
+			return err
+		}
+		log.Printf("Evaluating %d alert rules every %s", len(cfg.Alerting.Rules), time.Duration(cfg.Alerting.Interval))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			engine.Run(ctx)
+		}()
+	}
+
+	pipe := pipeline.NewPipeline(store, cfg.Pipeline)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		pipe.Run(ctx)
+	}()
 
-	r := createRouter(store)
+	r := createRouter(store, pipe, validator)
 	errCh := make(chan error, 1)
 
 	log.Print("Starting the server on port 8080")
@@ -88,14 +268,36 @@ func run(ctx context.Context, store handler.Store) error {
 	}
 }
 
-func createRouter(store handler.Store) *mux.Router {
-	hndlr := handler.NewMetricsHandler(store)
+func createRouter(store Storage, writer handler.Writer, validator *validation.Validator) *mux.Router {
+	hndlr := handler.NewMetricsHandler(store, writer, validator)
 
 	r := mux.NewRouter()
 	r.HandleFunc("/metrics", hndlr.GetTimeline).Methods(http.MethodGet)
+	r.HandleFunc("/metrics", hndlr.PostMetrics).Methods(http.MethodPost)
 	r.HandleFunc("/metrics/average", hndlr.GetAverage).Methods(http.MethodGet)
+	r.HandleFunc("/metrics/correlation", hndlr.GetCorrelation).Methods(http.MethodGet)
 	r.HandleFunc("/metrics/{type}", hndlr.GetTimeline).Methods(http.MethodGet)
 	r.HandleFunc("/metrics/{type}/average", hndlr.GetAverage).Methods(http.MethodGet)
+	r.HandleFunc("/metrics/{type}/anomalies", hndlr.GetAnomalies).Methods(http.MethodGet)
+	r.HandleFunc("/metrics/{type}/forecast", hndlr.GetForecast).Methods(http.MethodGet)
+	r.HandleFunc("/metrics/{type}/histogram", hndlr.GetHistogram).Methods(http.MethodGet)
+	r.HandleFunc("/metrics/{type}/top", hndlr.GetTop).Methods(http.MethodGet)
+	r.HandleFunc("/metrics/{type}/bottom", hndlr.GetBottom).Methods(http.MethodGet)
+	series := handler.NewSeriesHandler(store)
+	r.HandleFunc("/series", series.ListSeries).Methods(http.MethodGet)
+	r.HandleFunc("/metrics/{type}/info", series.GetInfo).Methods(http.MethodGet)
+	samples := handler.NewSamplesHandler(store)
+	r.HandleFunc("/samples/{name}", samples.GetSamples).Methods(http.MethodGet)
+	distributions := handler.NewDistributionsHandler(store, validator)
+	r.HandleFunc("/distributions", distributions.PostDistributions).Methods(http.MethodPost)
+	r.HandleFunc("/distributions/{name}", distributions.GetDistributions).Methods(http.MethodGet)
+	retention := handler.NewRetentionHandler(store)
+	r.HandleFunc("/retention", retention.GetRetention).Methods(http.MethodGet)
+	r.HandleFunc("/retention", retention.SetRetention).Methods(http.MethodPut)
+	stats := handler.NewStatsHandler(store)
+	r.HandleFunc("/admin/stats", stats.GetStats).Methods(http.MethodGet)
+	r.HandleFunc("/admin/stats/daily", stats.GetDailyCounts).Methods(http.MethodGet)
+	r.Handle("/v1/metrics", otlp.NewReceiver(validation.NewSampleWriter(store, validator))).Methods(http.MethodPost)
 
 	return r
 }
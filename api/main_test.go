@@ -5,12 +5,20 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"sky/api/internal/analysis"
+	"sky/api/internal/config"
 	"sky/api/internal/model"
+	"sky/api/internal/pipeline"
+	"sky/api/internal/storage/conflict"
+	"sky/api/internal/storage/histogram"
+	"sky/api/internal/storage/late"
+	"sky/api/internal/validation"
 
 	"net/http"
 	"net/http/httptest"
@@ -52,7 +60,7 @@ func TestHandler(t *testing.T) {
 
 	for _, c := range cases {
 		store := mockStore{c.dbSeries}
-		router := createRouter(store)
+		router := createRouter(store, newTestPipeline(store, 10), newTestValidator())
 
 		nowT := now.Unix()
 		minAgoT := now.Add(time.Duration(-1) * time.Minute).Unix()
@@ -88,6 +96,528 @@ func TestHandler(t *testing.T) {
 	}
 }
 
+func TestTimeRange(t *testing.T) {
+	router := newTestRouter(mockStore{[]model.Metric{{Timestamp: time.Now(), CPULoad: 48}}})
+
+	cases := []struct {
+		description        string
+		query              string
+		expectedRespStatus int
+	}{
+		{"epoch seconds", "start=1650844800&end=1650848400", http.StatusOK},
+		{"epoch milliseconds", "start=1650844800000&end=1650848400000", http.StatusOK},
+		{"rfc3339", "start=2022-04-25T00:00:00Z&end=2022-04-25T03:00:00%2B02:00", http.StatusOK},
+		{"relative", "start=now-24h&end=now", http.StatusOK},
+		{"end defaults to now", "start=now/d", http.StatusOK},
+		{"missing start", "end=now", http.StatusBadRequest},
+		{"invalid start", "start=yesterday", http.StatusBadRequest},
+		{"invalid end", "start=now-1h&end=now/q", http.StatusBadRequest},
+		{"start after end", "start=now&end=now-1h", http.StatusBadRequest},
+		{"period", "period=yesterday&tz=Europe/Berlin", http.StatusOK},
+		{"month", "period=2022-04", http.StatusOK},
+		{"period with a range", "period=today&start=now-1h", http.StatusBadRequest},
+		{"invalid period", "period=tomorrow", http.StatusBadRequest},
+		{"invalid timezone", "period=today&tz=Mars", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		rr := serve(t, router, http.MethodGet, "/metrics/cpu_load?"+c.query, "")
+		assert.Equal(t, c.expectedRespStatus, rr.Code, c.description)
+	}
+
+	rr := serve(t, router, http.MethodGet, "/metrics/cpu_load/average?period=2022-04&tz=Europe/Berlin", "")
+	assert.Equal(t, "2022-04-01T00:00:00+02:00", rr.Header().Get("Period-Start"))
+	assert.Equal(t, "2022-05-01T00:00:00+02:00", rr.Header().Get("Period-End"))
+}
+
+func TestPostMetrics(t *testing.T) {
+	// the mock store rejects the metrics at the timestamps of its series, like the reject conflict policy
+	store := mockStore{[]model.Metric{{Timestamp: time.Date(2022, 4, 24, 0, 0, 0, 0, time.UTC), CPULoad: 10}}}
+	router := createRouter(store, newTestPipeline(store, 3), newTestValidator())
+
+	cases := []struct {
+		description        string
+		body               string
+		expectedRespStatus int
+	}{
+		{"invalid body", `{"cpu_load": 12}`, http.StatusBadRequest},
+		{"empty array", `[]`, http.StatusBadRequest},
+		{"cpu load out of range", `[{"timestamp":"2022-04-25T00:00:00Z","cpu_load":120}]`, http.StatusBadRequest},
+		{"concurrency overflows int32", `[{"timestamp":"2022-04-25T00:00:00Z","concurrency":3000000000}]`, http.StatusBadRequest},
+		{"timestamp in the future", `[{"timestamp":"2999-01-01T00:00:00Z","cpu_load":12}]`, http.StatusBadRequest},
+		{"duplicate", `[{"timestamp":"2022-04-24T00:00:00Z","cpu_load":12}]`, http.StatusConflict},
+		{"late", `[{"timestamp":"2022-04-22T00:00:00Z","cpu_load":12}]`, http.StatusBadRequest},
+		{"queued", `[{"timestamp":"2022-04-25T00:00:00Z","cpu_load":12},{"timestamp":"2022-04-25T00:01:00Z","cpu_load":13}]`, http.StatusAccepted},
+		{"larger than the queue", `[{"timestamp":"2022-04-25T00:02:00Z","cpu_load":14},{"timestamp":"2022-04-25T00:03:00Z","cpu_load":15},{"timestamp":"2022-04-25T00:04:00Z","cpu_load":16},{"timestamp":"2022-04-25T00:05:00Z","cpu_load":17}]`, http.StatusRequestEntityTooLarge},
+		{"queue is full", `[{"timestamp":"2022-04-25T00:02:00Z","cpu_load":14},{"timestamp":"2022-04-25T00:03:00Z","cpu_load":15}]`, http.StatusTooManyRequests},
+	}
+	for _, c := range cases {
+		rr := serve(t, router, http.MethodPost, "/metrics", c.body)
+		assert.Equal(t, c.expectedRespStatus, rr.Code, c.description)
+	}
+}
+
+func TestPostMetricsIdempotency(t *testing.T) {
+	store := mockStore{}
+	pipe := newTestPipeline(store, 10)
+	router := createRouter(store, pipe, newTestValidator())
+	body := `[{"timestamp":"2022-04-25T00:00:00Z","cpu_load":12}]`
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodPost, "/metrics", strings.NewReader(body))
+		assert.Nil(t, err)
+		req.Header.Set("Idempotency-Key", "batch-1")
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusAccepted, rr.Code)
+		assert.Equal(t, i == 1, rr.Header().Get("Idempotency-Replayed") == "true")
+	}
+	// the repeated request is not queued again
+	assert.Equal(t, 1, pipe.Len())
+}
+
+func TestRetention(t *testing.T) {
+	router := newTestRouter(mockStore{})
+
+	cases := []struct {
+		description        string
+		method             string
+		body               string
+		expectedRespStatus int
+		expectedRetention  model.Retention
+	}{
+		{"get", http.MethodGet, "", http.StatusOK, model.Retention{Raw: 2592000, Hourly: 31536000, Daily: 315360000}},
+		{"change hourly", http.MethodPut, `{"hourly": 3600}`, http.StatusOK, model.Retention{Raw: 2592000, Hourly: 3600, Daily: 315360000}},
+		{"zero keeps the retention", http.MethodPut, `{"raw": 0, "daily": 31536000}`, http.StatusOK, model.Retention{Raw: 2592000, Hourly: 31536000, Daily: 31536000}},
+		{"negative retention", http.MethodPut, `{"raw": -1}`, http.StatusBadRequest, model.Retention{}},
+		{"rollup retention overflows int32", http.MethodPut, `{"daily": 3000000000}`, http.StatusBadRequest, model.Retention{}},
+		{"invalid body", http.MethodPut, `{"raw": "30d"}`, http.StatusBadRequest, model.Retention{}},
+	}
+	for _, c := range cases {
+		rr := serve(t, router, c.method, "/retention", c.body)
+		assert.Equal(t, c.expectedRespStatus, rr.Code, c.description)
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var retention model.Retention
+		assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &retention))
+		assert.Equal(t, c.expectedRetention, retention, c.description)
+	}
+}
+
+func TestGetSamples(t *testing.T) {
+	router := newTestRouter(mockStore{})
+
+	cases := []struct {
+		description        string
+		url                string
+		expectedRespStatus int
+	}{
+		{"recorded series", "/samples/cpu_load:p95:1h?start=1650841200&end=1650844800", http.StatusOK},
+		{"recorded series with label", "/samples/cpu_load:p95:1h?start=1650841200&end=1650844800&label=source=rules", http.StatusOK},
+		{"unknown series", "/samples/unknown?start=1650841200&end=1650844800", http.StatusNotFound},
+		{"invalid label", "/samples/cpu_load:p95:1h?start=1650841200&end=1650844800&label=source", http.StatusBadRequest},
+		{"missing time range", "/samples/cpu_load:p95:1h", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		rr := serve(t, router, http.MethodGet, c.url, "")
+		assert.Equal(t, c.expectedRespStatus, rr.Code, c.description)
+	}
+}
+
+func TestGetAnomalies(t *testing.T) {
+	series := buildSeries(60, time.Minute, func(i int) model.Metric {
+		if i == 45 {
+			return model.Metric{Concurrency: 5000}
+		}
+		return model.Metric{Concurrency: int32(1000 + 10*(i%7))}
+	})
+
+	cases := []struct {
+		description        string
+		store              mockStore
+		url                string
+		expectedRespStatus int
+		expectedAnomalies  int
+	}{
+		{"zscore", mockStore{series}, "/metrics/concurrency/anomalies?start=1650844800&end=1650848400&window=20", http.StatusOK, 1},
+		{"mad", mockStore{series}, "/metrics/concurrency/anomalies?start=1650844800&end=1650848400&method=mad&threshold=4", http.StatusOK, 1},
+		{"unknown method", mockStore{series}, "/metrics/concurrency/anomalies?start=1650844800&end=1650848400&method=prophet", http.StatusBadRequest, 0},
+		{"window too small", mockStore{series}, "/metrics/concurrency/anomalies?start=1650844800&end=1650848400&window=1", http.StatusBadRequest, 0},
+		{"season longer than the series", mockStore{series}, "/metrics/concurrency/anomalies?start=1650844800&end=1650848400&method=holtwinters&season=40", http.StatusBadRequest, 0},
+		{"no data", mockStore{}, "/metrics/concurrency/anomalies?start=1650844800&end=1650848400", http.StatusNotFound, 0},
+	}
+	for _, c := range cases {
+		rr := serve(t, newTestRouter(c.store), http.MethodGet, c.url, "")
+		assert.Equal(t, c.expectedRespStatus, rr.Code, c.description)
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var anomalies []map[string]interface{}
+		assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &anomalies))
+		assert.Len(t, anomalies, c.expectedAnomalies, c.description)
+	}
+}
+
+func TestGetForecast(t *testing.T) {
+	router := newTestRouter(mockStore{buildSeries(72, time.Hour, func(i int) model.Metric {
+		return model.Metric{Concurrency: int32(1000 + 10*i)}
+	})})
+
+	cases := []struct {
+		description        string
+		url                string
+		expectedRespStatus int
+		expectedPoints     int
+	}{
+		{"default horizon", "/metrics/concurrency/forecast?start=1650844800&end=1651104000&frequency=hours", http.StatusOK, 24},
+		{"linear for a week", "/metrics/concurrency/forecast?start=1650844800&end=1651104000&horizon=7d&model=linear", http.StatusOK, 168},
+		{"daily seasonality", "/metrics/concurrency/forecast?start=1650844800&end=1651104000&frequency=hours&seasonality=daily", http.StatusOK, 24},
+		{"not enough history for the season", "/metrics/concurrency/forecast?start=1650844800&end=1651104000&frequency=hours&seasonality=weekly", http.StatusBadRequest, 0},
+		{"invalid horizon", "/metrics/concurrency/forecast?start=1650844800&end=1651104000&horizon=soon", http.StatusBadRequest, 0},
+		{"invalid confidence", "/metrics/concurrency/forecast?start=1650844800&end=1651104000&confidence=95", http.StatusBadRequest, 0},
+		{"monthly frequency", "/metrics/concurrency/forecast?start=1650844800&end=1651104000&frequency=months", http.StatusBadRequest, 0},
+	}
+	for _, c := range cases {
+		rr := serve(t, router, http.MethodGet, c.url, "")
+		assert.Equal(t, c.expectedRespStatus, rr.Code, c.description)
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var forecast struct {
+			Points []map[string]interface{} `json:"points"`
+		}
+		assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &forecast))
+		assert.Len(t, forecast.Points, c.expectedPoints, c.description)
+	}
+}
+
+func TestGetCorrelation(t *testing.T) {
+	router := newTestRouter(mockStore{buildSeries(60, time.Minute, func(i int) model.Metric {
+		return model.Metric{CPULoad: float64(i % 10), Concurrency: int32(1000 + 10*(i%10))}
+	})})
+
+	cases := []struct {
+		description        string
+		url                string
+		expectedRespStatus int
+	}{
+		{"default metrics", "/metrics/correlation?start=1650844800&end=1650848400", http.StatusOK},
+		{"step and lags", "/metrics/correlation?start=1650844800&end=1650848400&metrics=concurrency,cpu_load&step=5m&maxLag=2", http.StatusOK},
+		{"single metric", "/metrics/correlation?start=1650844800&end=1650848400&metrics=cpu_load", http.StatusBadRequest},
+		{"repeated metric", "/metrics/correlation?start=1650844800&end=1650848400&metrics=cpu_load,cpu_load", http.StatusBadRequest},
+		{"invalid step", "/metrics/correlation?start=1650844800&end=1650848400&step=-5m", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		rr := serve(t, router, http.MethodGet, c.url, "")
+		assert.Equal(t, c.expectedRespStatus, rr.Code, c.description)
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var result analysis.CorrelationResult
+		assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		assert.Len(t, result.Pairs, 1, c.description)
+	}
+}
+
+func TestGetHistogram(t *testing.T) {
+	router := newTestRouter(mockStore{buildSeries(100, time.Minute, func(i int) model.Metric {
+		return model.Metric{CPULoad: float64(i) / 100, Concurrency: int32(i + 1)}
+	})})
+
+	// the buckets are computed by the store; the handler parses their spec
+	cases := []struct {
+		description        string
+		url                string
+		expectedRespStatus int
+		expectedBuckets    int
+	}{
+		{"default buckets", "/metrics/cpu_load/histogram?start=1650844800&end=1650848400", http.StatusOK, 10},
+		{"boundaries", "/metrics/cpu_load/histogram?start=1650844800&end=1650848400&buckets=0,0.5,0.9", http.StatusOK, 2},
+		{"log scale", "/metrics/concurrency/histogram?start=1650844800&end=1650848400&buckets=2&scale=log&min=1&max=100", http.StatusOK, 2},
+		{"auto scale", "/metrics/concurrency/histogram?start=1650844800&end=1650848400&buckets=4&scale=auto", http.StatusOK, 4},
+		{"unknown type", "/metrics/memory/histogram?start=1650844800&end=1650848400", http.StatusBadRequest, 0},
+		{"invalid buckets", "/metrics/cpu_load/histogram?start=1650844800&end=1650848400&buckets=0", http.StatusBadRequest, 0},
+		{"decreasing boundaries", "/metrics/cpu_load/histogram?start=1650844800&end=1650848400&buckets=1,0.5", http.StatusBadRequest, 0},
+		{"invalid scale", "/metrics/cpu_load/histogram?start=1650844800&end=1650848400&scale=square", http.StatusBadRequest, 0},
+		{"invalid range", "/metrics/cpu_load/histogram?start=1650844800&end=1650848400&min=1&max=0", http.StatusBadRequest, 0},
+	}
+	for _, c := range cases {
+		rr := serve(t, router, http.MethodGet, c.url, "")
+		assert.Equal(t, c.expectedRespStatus, rr.Code, c.description)
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var hist model.Histogram
+		assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &hist))
+		assert.Len(t, hist.Buckets, c.expectedBuckets, c.description)
+	}
+}
+
+func TestGetTop(t *testing.T) {
+	loads := []float64{30, 90, 10, 70, 50}
+	router := newTestRouter(mockStore{buildSeries(len(loads), time.Hour, func(i int) model.Metric {
+		return model.Metric{CPULoad: loads[i]}
+	})})
+
+	cases := []struct {
+		description        string
+		url                string
+		expectedRespStatus int
+		expectedValues     []float64
+	}{
+		{"top", "/metrics/cpu_load/top?start=1650844800&end=1650862800&k=2", http.StatusOK, []float64{90, 70}},
+		{"bottom", "/metrics/cpu_load/bottom?start=1650844800&end=1650862800&k=3", http.StatusOK, []float64{10, 30, 50}},
+		{"k above the points", "/metrics/cpu_load/top?start=1650844800&end=1650862800&k=10", http.StatusOK, []float64{90, 70, 50, 30, 10}},
+		{"invalid k", "/metrics/cpu_load/top?start=1650844800&end=1650862800&k=0", http.StatusBadRequest, nil},
+		{"k above the limit", "/metrics/cpu_load/top?start=1650844800&end=1650862800&k=1001", http.StatusBadRequest, nil},
+		{"unknown type", "/metrics/memory/top?start=1650844800&end=1650862800", http.StatusBadRequest, nil},
+	}
+	for _, c := range cases {
+		rr := serve(t, router, http.MethodGet, c.url, "")
+		assert.Equal(t, c.expectedRespStatus, rr.Code, c.description)
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var points []model.Metric
+		assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &points))
+		values := make([]float64, 0, len(points))
+		for _, p := range points {
+			values = append(values, p.CPULoad)
+		}
+		assert.Equal(t, c.expectedValues, values, c.description)
+	}
+}
+
+func TestDistributions(t *testing.T) {
+	router := newTestRouter(mockStore{})
+
+	cases := []struct {
+		description        string
+		method             string
+		url                string
+		body               string
+		expectedRespStatus int
+	}{
+		{"post", http.MethodPost, "/distributions", `[{"timestamp":"2022-04-25T10:00:00Z","name":"latency","bounds":[0.1],"counts":[2,1],"sum":0.3,"count":3}]`, http.StatusOK},
+		{"post with a wrong count", http.MethodPost, "/distributions", `[{"timestamp":"2022-04-25T10:00:00Z","name":"latency","bounds":[0.1],"counts":[2,1],"sum":0.3,"count":4}]`, http.StatusBadRequest},
+		{"post without buckets", http.MethodPost, "/distributions", `[{"timestamp":"2022-04-25T10:00:00Z","name":"latency","bounds":[0.1],"count":0}]`, http.StatusBadRequest},
+		{"post nothing", http.MethodPost, "/distributions", `[]`, http.StatusBadRequest},
+		{"get", http.MethodGet, "/distributions/latency?start=1650880800&end=1650888000", "", http.StatusOK},
+		{"get by step", http.MethodGet, "/distributions/latency?start=1650880800&end=1650888000&step=1h&quantiles=0.5", "", http.StatusOK},
+		{"get unknown", http.MethodGet, "/distributions/size?start=1650880800&end=1650888000", "", http.StatusNotFound},
+		{"invalid quantiles", http.MethodGet, "/distributions/latency?start=1650880800&end=1650888000&quantiles=50", "", http.StatusBadRequest},
+		{"invalid step", http.MethodGet, "/distributions/latency?start=1650880800&end=1650888000&step=0s", "", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		rr := serve(t, router, c.method, c.url, c.body)
+		assert.Equal(t, c.expectedRespStatus, rr.Code, c.description)
+	}
+
+	rr := serve(t, router, http.MethodGet, "/distributions/latency?start=1650880800&end=1650888000&step=1h&quantiles=0.5", "")
+	var hourly []struct {
+		Count     int64              `json:"count"`
+		Quantiles map[string]float64 `json:"quantiles"`
+	}
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &hourly))
+	if assert.Len(t, hourly, 2) {
+		// the distributions of the first hour are merged
+		assert.Equal(t, int64(12), hourly[0].Count)
+		assert.Contains(t, hourly[0].Quantiles, "0.5")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	// a point every hour of two weeks, 10% busier in the second week
+	start := time.Date(2022, 4, 18, 0, 0, 0, 0, time.UTC)
+	series := buildSeries(14*24, time.Hour, func(i int) model.Metric {
+		load := float64(20 + i%24)
+		if i >= 7*24 {
+			load *= 1.1
+		}
+		return model.Metric{CPULoad: load, Concurrency: 1000}
+	})
+	for i := range series {
+		series[i].Timestamp = start.Add(time.Duration(i) * time.Hour)
+	}
+	router := newTestRouter(mockStore{series})
+
+	cases := []struct {
+		description        string
+		url                string
+		expectedRespStatus int
+	}{
+		{"timeline", "/metrics/cpu_load?start=2022-04-25T00:00:00Z&end=2022-05-01T23:00:00Z&frequency=hours&compare=1w", http.StatusOK},
+		{"all types", "/metrics?start=2022-04-25T00:00:00Z&end=2022-05-01T23:00:00Z&compare=7d", http.StatusOK},
+		{"average", "/metrics/cpu_load/average?start=2022-04-25T00:00:00Z&end=2022-05-01T23:00:00Z&compare=1w", http.StatusOK},
+		{"calendar offset", "/metrics/average?start=2022-04-25T00:00:00Z&end=2022-05-01T23:00:00Z&compare=1y", http.StatusOK},
+		{"no current data", "/metrics/average?start=2023-04-25T00:00:00Z&end=2023-05-01T23:00:00Z&compare=1y", http.StatusNotFound},
+		{"invalid offset", "/metrics/cpu_load?start=2022-04-25T00:00:00Z&compare=last_week", http.StatusBadRequest},
+		{"negative offset", "/metrics/cpu_load/average?start=2022-04-25T00:00:00Z&compare=-1w", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		rr := serve(t, router, http.MethodGet, c.url, "")
+		assert.Equal(t, c.expectedRespStatus, rr.Code, c.description)
+	}
+
+	// the previous range is shifted by the offset and its points are matched with the current ones
+	rr := serve(t, router, http.MethodGet, cases[0].url, "")
+	var timeline analysis.SeriesComparison
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &timeline))
+	assert.Equal(t, time.Date(2022, 4, 18, 0, 0, 0, 0, time.UTC), timeline.Previous.Start)
+	// the mock returns the points of both weeks
+	assert.Len(t, timeline.Points, 14*24)
+	for _, p := range timeline.Points {
+		if p.Timestamp.Equal(time.Date(2022, 4, 25, 5, 0, 0, 0, time.UTC)) {
+			assert.Equal(t, time.Date(2022, 4, 18, 5, 0, 0, 0, time.UTC), *p.PreviousTimestamp)
+		}
+	}
+
+	rr = serve(t, router, http.MethodGet, cases[2].url, "")
+	var average analysis.AverageComparison
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &average))
+	assert.Contains(t, average.Values, "cpu_load")
+	assert.NotContains(t, average.Values, "concurrency")
+}
+
+func TestSeriesInfo(t *testing.T) {
+	start := time.Date(2022, 4, 25, 0, 0, 0, 0, time.UTC)
+	store := mockStore{[]model.Metric{
+		{Timestamp: start, CPULoad: 42},
+		{Timestamp: start.Add(time.Minute), CPULoad: 12, Concurrency: 1200},
+		{Timestamp: start.Add(2 * time.Minute), CPULoad: 87},
+	}}
+	router := newTestRouter(store)
+
+	rr := serve(t, router, http.MethodGet, "/metrics/cpu_load/info", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var info model.SeriesInfo
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &info))
+	assert.Equal(t, start, info.First)
+	assert.Equal(t, start.Add(2*time.Minute), info.Last)
+	assert.Equal(t, int64(3), info.Count)
+	assert.Equal(t, 12.0, *info.Min)
+	assert.Equal(t, 87.0, *info.Max)
+
+	rr = serve(t, router, http.MethodGet, "/series", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var series []model.SeriesInfo
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &series))
+	if assert.Len(t, series, 2) {
+		assert.Equal(t, "concurrency", series[1].Name)
+		assert.Equal(t, int64(1), series[1].Count)
+	}
+
+	cases := []struct {
+		description        string
+		store              mockStore
+		url                string
+		expectedRespStatus int
+	}{
+		{"no metrics", mockStore{}, "/metrics/concurrency/info", http.StatusNotFound},
+		{"unknown type", store, "/metrics/memory/info", http.StatusBadRequest},
+		{"no series", mockStore{}, "/series", http.StatusOK},
+	}
+	for _, c := range cases {
+		rr := serve(t, newTestRouter(c.store), http.MethodGet, c.url, "")
+		assert.Equal(t, c.expectedRespStatus, rr.Code, c.description)
+	}
+}
+
+func TestStats(t *testing.T) {
+	start := time.Date(2022, 4, 25, 0, 0, 0, 0, time.UTC)
+	router := newTestRouter(mockStore{[]model.Metric{
+		{Timestamp: start.Add(-time.Hour), CPULoad: 42},
+		{Timestamp: start, CPULoad: 12},
+		{Timestamp: start.Add(23 * time.Hour), CPULoad: 87},
+	}})
+
+	rr := serve(t, router, http.MethodGet, "/admin/stats", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var stats []model.CollectionStats
+	assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &stats))
+	if assert.Len(t, stats, 1) {
+		assert.Equal(t, int64(3), *stats[0].Buckets)
+		assert.Equal(t, 4.0, *stats[0].CompressionRatio)
+	}
+
+	cases := []struct {
+		description        string
+		query              string
+		expectedRespStatus int
+		expectedCounts     []int64
+	}{
+		{"utc days", "start=2022-04-24&end=2022-04-26", http.StatusOK, []int64{1, 2}},
+		{"berlin days", "start=2022-04-24&end=2022-04-27&tz=Europe/Berlin", http.StatusOK, []int64{2, 1}},
+		{"period", "period=2022-04-25", http.StatusOK, []int64{2}},
+		{"empty range", "start=2022-05-01&end=2022-05-02", http.StatusOK, []int64{}},
+		{"no range", "", http.StatusBadRequest, nil},
+		{"invalid timezone", "start=2022-04-24&tz=Mars", http.StatusBadRequest, nil},
+		{"server timezone", "start=2022-04-24&tz=Local", http.StatusBadRequest, nil},
+	}
+	for _, c := range cases {
+		rr := serve(t, router, http.MethodGet, "/admin/stats/daily?"+c.query, "")
+		assert.Equal(t, c.expectedRespStatus, rr.Code, c.description)
+		if rr.Code != http.StatusOK {
+			continue
+		}
+
+		var days []model.DailyCount
+		assert.Nil(t, json.Unmarshal(rr.Body.Bytes(), &days))
+		counts := make([]int64, 0)
+		for _, day := range days {
+			counts = append(counts, day.Count)
+		}
+		assert.Equal(t, c.expectedCounts, counts, c.description)
+	}
+}
+
+// serve sends a request with the body to the router and returns the recorded response
+func serve(t *testing.T, router http.Handler, method, url, body string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.Nil(t, err)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+// buildSeries returns n metrics a step apart from 2022-04-25 00:00 UTC, with the values of the metric of each index
+func buildSeries(n int, step time.Duration, metric func(i int) model.Metric) []model.Metric {
+	start := time.Date(2022, 4, 25, 0, 0, 0, 0, time.UTC)
+	series := make([]model.Metric, 0, n)
+	for i := 0; i < n; i++ {
+		m := metric(i)
+		m.Timestamp = start.Add(time.Duration(i) * step)
+		series = append(series, m)
+	}
+	return series
+}
+
+// newTestRouter returns the router of the store, with a pipeline that is not running
+func newTestRouter(store mockStore) http.Handler {
+	return createRouter(store, newTestPipeline(store, 10), newTestValidator())
+}
+
+// newTestPipeline returns a pipeline that is not running, so the written metrics stay in its queue
+func newTestPipeline(store mockStore, queueSize int) *pipeline.Pipeline {
+	return pipeline.NewPipeline(store, config.PipelineConfig{
+		QueueSize:     queueSize,
+		BatchSize:     queueSize,
+		FlushInterval: config.Duration(time.Minute),
+	})
+}
+
+func newTestValidator() *validation.Validator {
+	return validation.NewValidator(config.ValidationConfig{MaxFutureSkew: config.Duration(time.Minute), MaxLabels: 10})
+}
+
 type mockStore struct {
 	series []model.Metric
 }
@@ -97,5 +627,175 @@ func (m mockStore) GetSeries(ctx context.Context, filter model.Query) ([]model.M
 }
 
 func (m mockStore) GetAverage(ctx context.Context, filter model.Query) (*model.MetricAverage, error) {
-	return nil, nil
+	avg := model.MetricAverage{StartTime: filter.StartAt, EndTime: filter.EndAt}
+	n := 0
+	for _, metric := range m.series {
+		if metric.Timestamp.Before(filter.StartAt) || metric.Timestamp.After(filter.EndAt) {
+			continue
+		}
+		avg.CPULoad += metric.CPULoad
+		avg.Concurrency += float64(metric.Concurrency)
+		n++
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	avg.CPULoad /= float64(n)
+	avg.Concurrency /= float64(n)
+	return &avg, nil
+}
+
+func (m mockStore) GetHistogram(ctx context.Context, filter model.Query, spec model.HistogramSpec) (*model.Histogram, error) {
+	points := analysis.Points(m.series, filter.MetricType)
+	if len(points) == 0 {
+		return nil, nil
+	}
+	values := make([]float64, 0, len(points))
+	for _, p := range points {
+		values = append(values, p.Value)
+	}
+	return histogram.Compute(values, spec)
+}
+
+func (m mockStore) GetTop(ctx context.Context, filter model.Query, k int, ascending bool) ([]model.Metric, error) {
+	points := analysis.Points(m.series, filter.MetricType)
+	sort.SliceStable(points, func(i, j int) bool {
+		if ascending {
+			return points[i].Value < points[j].Value
+		}
+		return points[i].Value > points[j].Value
+	})
+	if len(points) > k {
+		points = points[:k]
+	}
+	top := make([]model.Metric, 0, len(points))
+	for _, p := range points {
+		metric := model.Metric{Timestamp: p.Timestamp, CPULoad: p.Value}
+		if filter.MetricType == model.MetricTypeConcurrency {
+			metric = model.Metric{Timestamp: p.Timestamp, Concurrency: int32(p.Value)}
+		}
+		top = append(top, metric)
+	}
+	return top, nil
+}
+
+func (m mockStore) InsertDistributions(ctx context.Context, dists []model.Distribution) error {
+	return nil
 }
+
+func (m mockStore) GetDistributions(ctx context.Context, query model.SampleQuery) ([]model.Distribution, error) {
+	if query.Name != "latency" {
+		return nil, nil
+	}
+	start := time.Date(2022, 4, 25, 10, 0, 0, 0, time.UTC)
+	return []model.Distribution{
+		{Timestamp: start, Name: "latency", Bounds: []float64{0.1, 0.5}, Counts: []int64{6, 2, 0}, Sum: 1, Count: 8},
+		{Timestamp: start.Add(30 * time.Minute), Name: "latency", Bounds: []float64{0.1, 0.5}, Counts: []int64{2, 2, 0}, Sum: 0.6, Count: 4},
+		{Timestamp: start.Add(time.Hour), Name: "latency", Bounds: []float64{0.1, 0.5}, Counts: []int64{0, 4, 0}, Sum: 1, Count: 4},
+	}, nil
+}
+
+func (m mockStore) GetSeriesInfo(ctx context.Context, metricType model.MetricType) (*model.SeriesInfo, error) {
+	var info *model.SeriesInfo
+	for _, p := range analysis.Points(m.series, metricType) {
+		// like the stored metrics, the zero values are missing
+		if p.Value == 0 {
+			continue
+		}
+		value := p.Value
+		if info == nil {
+			info = &model.SeriesInfo{Name: metricType.String(), Kind: model.SeriesKindMetric, First: p.Timestamp, Last: p.Timestamp, Min: &value, Max: &value}
+		}
+		if p.Timestamp.Before(info.First) {
+			info.First = p.Timestamp
+		}
+		if p.Timestamp.After(info.Last) {
+			info.Last = p.Timestamp
+		}
+		if value < *info.Min {
+			info.Min = &value
+		}
+		if value > *info.Max {
+			info.Max = &value
+		}
+		info.Count++
+	}
+	return info, nil
+}
+
+func (m mockStore) ListSeries(ctx context.Context) ([]model.SeriesInfo, error) {
+	series := make([]model.SeriesInfo, 0)
+	for _, metricType := range []model.MetricType{model.MetricTypeCPULoad, model.MetricTypeConcurrency} {
+		if info, _ := m.GetSeriesInfo(ctx, metricType); info != nil {
+			series = append(series, *info)
+		}
+	}
+	return series, nil
+}
+
+func (m mockStore) GetCollectionStats(ctx context.Context) ([]model.CollectionStats, error) {
+	buckets, ratio := int64(len(m.series)), 4.0
+	return []model.CollectionStats{{Name: "metrics", Size: 4096, StorageSize: 1024, Buckets: &buckets, CompressionRatio: &ratio}}, nil
+}
+
+func (m mockStore) GetDailyCounts(ctx context.Context, start, end time.Time, loc *time.Location) ([]model.DailyCount, error) {
+	counts := make([]model.DailyCount, 0)
+	for _, metric := range m.series {
+		if metric.Timestamp.Before(start) || metric.Timestamp.After(end) {
+			continue
+		}
+		t := metric.Timestamp.In(loc)
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		if len(counts) == 0 || !counts[len(counts)-1].Day.Equal(day) {
+			counts = append(counts, model.DailyCount{Day: day})
+		}
+		counts[len(counts)-1].Count++
+	}
+	return counts, nil
+}
+
+func (m mockStore) CheckMetrics(ctx context.Context, metrics []model.Metric) error {
+	stored := make(map[time.Time]bool, len(m.series))
+	for _, metric := range m.series {
+		stored[metric.Timestamp] = true
+	}
+	for _, metric := range metrics {
+		if stored[metric.Timestamp] {
+			return &model.RejectedError{Rejected: 1, Err: fmt.Errorf("%w: metric at %s is already stored", conflict.ErrDuplicate, metric.Timestamp)}
+		}
+		// the points older than the first stored one by more than a day are late
+		if len(m.series) > 0 && metric.Timestamp.Before(m.series[0].Timestamp.Add(-24*time.Hour)) {
+			return &model.RejectedError{Rejected: 1, Err: fmt.Errorf("%w: metric at %s", late.ErrLate, metric.Timestamp)}
+		}
+	}
+	return nil
+}
+
+func (m mockStore) LastSampleTime(ctx context.Context, name string, labels map[string]string) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func (m mockStore) InsertMetrics(ctx context.Context, metrics []model.Metric) error {
+	return nil
+}
+
+func (m mockStore) InsertSamples(ctx context.Context, samples []model.Sample) error {
+	return nil
+}
+
+func (m mockStore) GetSamples(ctx context.Context, query model.SampleQuery) ([]model.Sample, error) {
+	if query.Name != "cpu_load:p95:1h" {
+		return nil, nil
+	}
+	return []model.Sample{{Timestamp: query.StartAt, Name: query.Name, Type: model.SampleTypeGauge, Value: 87}}, nil
+}
+
+func (m mockStore) GetRetention(ctx context.Context) (*model.Retention, error) {
+	return &model.Retention{Raw: 2592000, Hourly: 31536000, Daily: 315360000}, nil
+}
+
+func (m mockStore) SetRetention(ctx context.Context, retention model.Retention) error {
+	return nil
+}
+
+func (m mockStore) RunRollups(ctx context.Context, interval, lookback time.Duration) {}